@@ -0,0 +1,120 @@
+package connmgr
+
+import (
+	"bytes"
+	"net"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// addrMatcher matches multiaddrs against a set of multiaddr prefixes and IP ranges.
+type addrMatcher struct {
+	prefixes []ma.Multiaddr
+	nets     []*net.IPNet
+}
+
+// empty reports whether the matcher matches nothing.
+func (am *addrMatcher) empty() bool {
+	return len(am.prefixes) == 0 && len(am.nets) == 0
+}
+
+// matches reports whether addr starts with one of the prefixes, or has its IP address
+// within one of the ranges.
+func (am *addrMatcher) matches(addr ma.Multiaddr) bool {
+	if addr == nil {
+		return false
+	}
+	// the components of a multiaddr are self-delimiting, so a prefix of the encoding
+	// always ends on a component boundary.
+	for _, prefix := range am.prefixes {
+		if bytes.HasPrefix(addr.Bytes(), prefix.Bytes()) {
+			return true
+		}
+	}
+	if len(am.nets) == 0 {
+		return false
+	}
+	ip, ok := ipOf(addr)
+	if !ok {
+		return false
+	}
+	for _, n := range am.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether the remote address of one of the connections of pi
+// matches.
+func (am *addrMatcher) matchesAny(pi *peerInfo) bool {
+	if am.empty() {
+		return false
+	}
+	for c := range pi.conns {
+		if am.matches(c.RemoteMultiaddr()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (am *addrMatcher) addPrefix(prefix ma.Multiaddr) {
+	am.removePrefix(prefix)
+	am.prefixes = append(am.prefixes, prefix)
+}
+
+func (am *addrMatcher) removePrefix(prefix ma.Multiaddr) {
+	for i, p := range am.prefixes {
+		if p.Equal(prefix) {
+			am.prefixes = append(am.prefixes[:i], am.prefixes[i+1:]...)
+			return
+		}
+	}
+}
+
+func (am *addrMatcher) addNet(n *net.IPNet) {
+	am.removeNet(n)
+	am.nets = append(am.nets, n)
+}
+
+func (am *addrMatcher) removeNet(n *net.IPNet) {
+	for i, in := range am.nets {
+		if in.String() == n.String() {
+			am.nets = append(am.nets[:i], am.nets[i+1:]...)
+			return
+		}
+	}
+}
+
+// ProtectAddr exempts from trimming the peers connected through a remote address
+// starting with prefix, e.g. /ip4/10.0.0.5 for any connection from that host, or
+// /ip4/10.0.0.5/tcp/4001 for that exact endpoint. Addresses are evaluated at trim time.
+func (cm *PhoreConnMgr) ProtectAddr(prefix ma.Multiaddr) {
+	cm.plk.Lock()
+	defer cm.plk.Unlock()
+	cm.protectedAddrs.addPrefix(prefix)
+}
+
+// UnprotectAddr reverts ProtectAddr for the same prefix.
+func (cm *PhoreConnMgr) UnprotectAddr(prefix ma.Multiaddr) {
+	cm.plk.Lock()
+	defer cm.plk.Unlock()
+	cm.protectedAddrs.removePrefix(prefix)
+}
+
+// ProtectCIDR exempts from trimming the peers connected through a remote IP address
+// within cidr, e.g. a datacenter subnet. Addresses are evaluated at trim time.
+func (cm *PhoreConnMgr) ProtectCIDR(cidr *net.IPNet) {
+	cm.plk.Lock()
+	defer cm.plk.Unlock()
+	cm.protectedAddrs.addNet(cidr)
+}
+
+// UnprotectCIDR reverts ProtectCIDR for the same range.
+func (cm *PhoreConnMgr) UnprotectCIDR(cidr *net.IPNet) {
+	cm.plk.Lock()
+	defer cm.plk.Unlock()
+	cm.protectedAddrs.removeNet(cidr)
+}
@@ -0,0 +1,136 @@
+package connmgr
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestAddrMatcher(t *testing.T) {
+	_, dc, err := net.ParseCIDR("192.168.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var am addrMatcher
+	am.addPrefix(ma.StringCast("/ip4/10.0.0.5"))
+	am.addPrefix(ma.StringCast("/ip4/10.0.0.6/tcp/4001"))
+	am.addNet(dc)
+
+	for _, tc := range []struct {
+		addr    string
+		matches bool
+	}{
+		{"/ip4/10.0.0.5/tcp/1", true},
+		{"/ip4/10.0.0.50/tcp/1", false},
+		{"/ip4/10.0.0.6/tcp/4001", true},
+		{"/ip4/10.0.0.6/tcp/4002", false},
+		{"/ip4/192.168.3.4/udp/1", true},
+		{"/ip6/2001:db8::1/tcp/1", false},
+	} {
+		if m := am.matches(ma.StringCast(tc.addr)); m != tc.matches {
+			t.Errorf("%s: expected matches=%v", tc.addr, tc.matches)
+		}
+	}
+
+	am.removePrefix(ma.StringCast("/ip4/10.0.0.5"))
+	am.removeNet(dc)
+	if am.matches(ma.StringCast("/ip4/10.0.0.5/tcp/1")) || am.matches(ma.StringCast("/ip4/192.168.3.4/udp/1")) {
+		t.Fatal("expected removed patterns not to match anymore")
+	}
+}
+
+func TestProtectAddrAndCIDR(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(3, 3, 0, ps, map[protocol.ID]int{}, WithSilencePeriod(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	static := addrConn(t, "/ip4/10.0.0.5/tcp/4001")
+	datacenter := addrConn(t, "/ip4/192.168.1.1/tcp/4001")
+	others := []network.Conn{
+		addrConn(t, "/ip4/10.0.1.1/tcp/4001"),
+		addrConn(t, "/ip4/10.0.1.2/tcp/4001"),
+	}
+	for _, c := range append([]network.Conn{static, datacenter}, others...) {
+		c.(*tconn).disconnectNotify = not.Disconnected
+		not.Connected(nil, c)
+	}
+	cm.TagPeer(others[0].RemotePeer(), "score", 100)
+
+	_, dc, err := net.ParseCIDR("192.168.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.ProtectAddr(ma.StringCast("/ip4/10.0.0.5"))
+	cm.ProtectCIDR(dc)
+	cm.TrimOpenConns(context.Background())
+	if static.(*tconn).closed || datacenter.(*tconn).closed {
+		t.Fatal("expected the protected addresses to be kept")
+	}
+	if others[0].(*tconn).closed || !others[1].(*tconn).closed {
+		t.Fatal("expected the unprotected peers to be trimmed")
+	}
+
+	cm.UnprotectAddr(ma.StringCast("/ip4/10.0.0.5"))
+	cm.UnprotectCIDR(dc)
+	cm.SetWatermarks(1, 1)
+	cm.TrimOpenConns(context.Background())
+	if !static.(*tconn).closed || !datacenter.(*tconn).closed {
+		t.Fatal("expected the addresses to be trimmed once unprotected")
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	_, dc, err := net.ParseCIDR("192.168.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	friend := randConn(t, nil)
+	friend.(*tconn).addr = ma.StringCast("/ip4/10.0.0.1/tcp/1")
+
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(2, 2, 0, ps, map[protocol.ID]int{}, WithSilencePeriod(0),
+		WithAllowlist([]peer.ID{friend.RemotePeer()}, nil, []*net.IPNet{dc}))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	datacenter := addrConn(t, "/ip4/192.168.1.1/tcp/4001")
+	others := []network.Conn{
+		addrConn(t, "/ip4/10.0.1.1/tcp/4001"),
+		addrConn(t, "/ip4/10.0.1.2/tcp/4001"),
+		addrConn(t, "/ip4/10.0.1.3/tcp/4001"),
+	}
+	for _, c := range append([]network.Conn{friend, datacenter}, others...) {
+		c.(*tconn).disconnectNotify = not.Disconnected
+		not.Connected(nil, c)
+	}
+	cm.TagPeer(others[0].RemotePeer(), "score", 10)
+	cm.TagPeer(others[1].RemotePeer(), "score", 5)
+
+	// only the three other connections count toward the watermarks.
+	if n := cm.count(); n != 3 {
+		t.Fatalf("expected 3 counted connections, got %d", n)
+	}
+	cm.TrimOpenConns(context.Background())
+	if friend.(*tconn).closed || datacenter.(*tconn).closed {
+		t.Fatal("expected the allowlisted peers to be kept")
+	}
+	if others[0].(*tconn).closed || others[1].(*tconn).closed || !others[2].(*tconn).closed {
+		t.Fatal("expected a trim of the other peers down to the low watermark")
+	}
+
+	not.Disconnected(nil, friend)
+	if n := cm.count(); n != 2 {
+		t.Fatalf("expected 2 counted connections, got %d", n)
+	}
+	if n := cm.GetInfo().ConnCount; n != 3 {
+		t.Fatalf("expected 3 connections in total, got %d", n)
+	}
+}
@@ -0,0 +1,43 @@
+package connmgr
+
+import (
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// allowlisted reports whether c is to or from a peer, or through an address, on the
+// allowlist set with WithAllowlist.
+func (cm *PhoreConnMgr) allowlisted(c network.Conn) bool {
+	if _, ok := cm.allowPeers[c.RemotePeer()]; ok {
+		return true
+	}
+	return cm.allowAddrs.matches(c.RemoteMultiaddr())
+}
+
+// trackAllowed accounts for a new allowlisted connection of pi. The segment of the
+// peer must be locked.
+func (cm *PhoreConnMgr) trackAllowed(pi *peerInfo) {
+	if pi.allowed++; pi.allowed == 1 {
+		atomic.AddInt32(&cm.allowedPeerCount, 1)
+	}
+	atomic.AddInt32(&cm.allowedConnCount, 1)
+}
+
+// untrackAllowed reverts trackAllowed. The segment of the peer must be locked.
+func (cm *PhoreConnMgr) untrackAllowed(pi *peerInfo) {
+	if pi.allowed--; pi.allowed == 0 {
+		atomic.AddInt32(&cm.allowedPeerCount, -1)
+	}
+	atomic.AddInt32(&cm.allowedConnCount, -1)
+}
+
+// allowlistOf builds the peer set of an allowlist.
+func allowlistOf(peers []peer.ID) map[peer.ID]struct{} {
+	set := make(map[peer.ID]struct{}, len(peers))
+	for _, p := range peers {
+		set[p] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,158 @@
+package connmgr
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// AuditKind is the kind of an AuditEvent.
+type AuditKind string
+
+const (
+	// AuditConnected and AuditDisconnected are for connections opening and closing.
+	AuditConnected    AuditKind = "connected"
+	AuditDisconnected AuditKind = "disconnected"
+
+	// AuditTagged and AuditUntagged are for tags set, or removed, with a change of
+	// value of at least the threshold given to WithAuditLog.
+	AuditTagged   AuditKind = "tagged"
+	AuditUntagged AuditKind = "untagged"
+
+	// AuditProtected and AuditUnprotected are for calls to Protect and Unprotect.
+	AuditProtected   AuditKind = "protected"
+	AuditUnprotected AuditKind = "unprotected"
+
+	// AuditPruned is for connections closed by a trim.
+	AuditPruned AuditKind = "pruned"
+)
+
+// AuditEvent is an entry of the audit log kept with WithAuditLog.
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	Kind AuditKind `json:"kind"`
+	Peer peer.ID   `json:"peer"`
+
+	// Addr is the remote address of the connection of connection events.
+	Addr string `json:"addr,omitempty"`
+
+	// Tag is the tag of tag and protection events.
+	Tag string `json:"tag,omitempty"`
+
+	// Value is the new value of the tag of tag events, and the value of the peer when
+	// pruned. Delta is the change of value of tag events.
+	Value int `json:"value,omitempty"`
+	Delta int `json:"delta,omitempty"`
+
+	// Reason tells which rule selected the connection of pruned events.
+	Reason CloseReason `json:"reason,omitempty"`
+}
+
+// auditLog is a ring buffer of the most recent audit events.
+type auditLog struct {
+	sync.Mutex
+	events       []AuditEvent
+	next         int  // index the next event is written at
+	full         bool // set once the buffer wrapped around
+	tagThreshold int
+}
+
+// add records e, overwriting the oldest event once the log is full.
+func (a *auditLog) add(e AuditEvent) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.events[a.next] = e
+	a.next++
+	if a.next == len(a.events) {
+		a.next = 0
+		a.full = true
+	}
+}
+
+// list returns the events kept for which keep returns true, oldest first.
+func (a *auditLog) list(keep func(AuditEvent) bool) []AuditEvent {
+	a.Lock()
+	defer a.Unlock()
+
+	ordered := a.events[:a.next]
+	if a.full {
+		ordered = append(append([]AuditEvent{}, a.events[a.next:]...), ordered...)
+	}
+	var events []AuditEvent
+	for _, e := range ordered {
+		if keep(e) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// AuditLog returns the events kept in the audit log, oldest first, nil unless
+// configured with WithAuditLog.
+func (cm *PhoreConnMgr) AuditLog() []AuditEvent {
+	if cm.audit == nil {
+		return nil
+	}
+	return cm.audit.list(func(AuditEvent) bool { return true })
+}
+
+// AuditLogFor returns the events of the audit log concerning p, oldest first.
+func (cm *PhoreConnMgr) AuditLogFor(p peer.ID) []AuditEvent {
+	if cm.audit == nil {
+		return nil
+	}
+	return cm.audit.list(func(e AuditEvent) bool { return e.Peer == p })
+}
+
+// auditConn records a connection event for c.
+func (cm *PhoreConnMgr) auditConn(kind AuditKind, c network.Conn, now time.Time) {
+	if cm.audit == nil {
+		return
+	}
+	e := AuditEvent{Time: now, Kind: kind, Peer: c.RemotePeer()}
+	if addr := c.RemoteMultiaddr(); addr != nil {
+		e.Addr = addr.String()
+	}
+	cm.audit.add(e)
+}
+
+// auditTag records the change of tag on p from old to val, if large enough.
+func (cm *PhoreConnMgr) auditTag(kind AuditKind, p peer.ID, tag string, old, val int, now time.Time) {
+	if cm.audit == nil {
+		return
+	}
+	delta := val - old
+	if delta == 0 || delta < cm.audit.tagThreshold && -delta < cm.audit.tagThreshold {
+		return
+	}
+	cm.audit.add(AuditEvent{Time: now, Kind: kind, Peer: p, Tag: tag, Value: val, Delta: delta})
+}
+
+// auditProtection records a call to Protect or Unprotect.
+func (cm *PhoreConnMgr) auditProtection(kind AuditKind, p peer.ID, tag string) {
+	if cm.audit == nil {
+		return
+	}
+	cm.audit.add(AuditEvent{Time: cm.clock.Now(), Kind: kind, Peer: p, Tag: tag})
+}
+
+// auditPruned records the closing of c by the trim that executed plan.
+func (cm *PhoreConnMgr) auditPruned(plan trimPlan, c network.Conn) {
+	if cm.audit == nil {
+		return
+	}
+	e := AuditEvent{
+		Time:   cm.clock.Now(),
+		Kind:   AuditPruned,
+		Peer:   c.RemotePeer(),
+		Value:  plan.selected[c.RemotePeer()].Value,
+		Reason: plan.reasons[c],
+	}
+	if addr := c.RemoteMultiaddr(); addr != nil {
+		e.Addr = addr.String()
+	}
+	cm.audit.add(e)
+}
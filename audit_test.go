@@ -0,0 +1,57 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestAuditLog(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 2, 0, ps, map[protocol.ID]int{}, WithTrimInterval(0), WithAuditLog(6, 5))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	if cm.AuditLog() != nil {
+		t.Fatal("expected an empty log")
+	}
+	a, b := randConn(t, not.Disconnected), randConn(t, not.Disconnected)
+	not.Connected(nil, a)
+	not.Connected(nil, b)
+	cm.TagPeer(a.RemotePeer(), "score", 2)  // below the threshold
+	cm.TagPeer(b.RemotePeer(), "score", 10) // above it
+	cm.Protect(b.RemotePeer(), "keep")
+	cm.TrimOpenConns(context.Background())
+
+	events := cm.AuditLog()
+	kinds := []AuditKind{AuditConnected, AuditConnected, AuditTagged, AuditProtected, AuditPruned, AuditDisconnected}
+	if len(events) != len(kinds) {
+		t.Fatalf("expected %d events, got %+v", len(kinds), events)
+	}
+	for i, k := range kinds {
+		if events[i].Kind != k {
+			t.Errorf("event %d: expected %s, got %+v", i, k, events[i])
+		}
+	}
+	if e := events[2]; e.Peer != b.RemotePeer() || e.Tag != "score" || e.Value != 10 || e.Delta != 10 {
+		t.Errorf("unexpected tag event %+v", e)
+	}
+	if e := events[4]; e.Peer != a.RemotePeer() || e.Reason != ReasonLowScore || e.Value != 2 {
+		t.Errorf("unexpected pruned event %+v", e)
+	}
+
+	// the log keeps the most recent events only.
+	cm.Unprotect(b.RemotePeer(), "keep")
+	events = cm.AuditLog()
+	if len(events) != 6 || events[0].Peer != b.RemotePeer() || events[5].Kind != AuditUnprotected {
+		t.Fatalf("expected the oldest event to be overwritten, got %+v", events)
+	}
+
+	history := cm.AuditLogFor(a.RemotePeer())
+	if len(history) != 2 || history[0].Kind != AuditPruned || history[1].Kind != AuditDisconnected {
+		t.Fatalf("unexpected history %+v", history)
+	}
+}
@@ -0,0 +1,94 @@
+package connmgr
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Ban describes a peer banned through BanPeer.
+type Ban struct {
+	Peer   peer.ID
+	Reason string
+	Until  time.Time
+}
+
+// BanPeer bans id for duration: its connections are closed right away, and any new
+// connection reported to the notifee while the ban lasts is closed without being
+// tracked. Banning a banned peer replaces the ban.
+func (cm *PhoreConnMgr) BanPeer(id peer.ID, duration time.Duration, reason string) {
+	cm.banMu.Lock()
+	if cm.bans == nil {
+		cm.bans = make(map[peer.ID]Ban)
+	}
+	cm.bans[id] = Ban{Peer: id, Reason: reason, Until: cm.clock.Now().Add(duration)}
+	cm.banMu.Unlock()
+
+	log.Infof("banning peer %s for %s: %s", id, duration, reason)
+	if err := cm.ClosePeer(id); err != nil && err != ErrNotConnected {
+		log.Warning("failed to close the connections of banned peer: ", err)
+	}
+}
+
+// UnbanPeer lifts the ban of id, and reports whether it was banned.
+func (cm *PhoreConnMgr) UnbanPeer(id peer.ID) bool {
+	cm.banMu.Lock()
+	defer cm.banMu.Unlock()
+
+	_, banned := cm.bans[id]
+	delete(cm.bans, id)
+	return banned
+}
+
+// IsBanned reports whether id is currently banned.
+func (cm *PhoreConnMgr) IsBanned(id peer.ID) bool {
+	cm.banMu.Lock()
+	defer cm.banMu.Unlock()
+
+	if len(cm.bans) == 0 {
+		return false
+	}
+	ban, ok := cm.bans[id]
+	if !ok {
+		return false
+	}
+	if !ban.Until.After(cm.clock.Now()) {
+		delete(cm.bans, id)
+		return false
+	}
+	return true
+}
+
+// Bans returns the current bans, soonest to expire first.
+func (cm *PhoreConnMgr) Bans() []Ban {
+	cm.expireBans()
+
+	cm.banMu.Lock()
+	bans := make([]Ban, 0, len(cm.bans))
+	for _, ban := range cm.bans {
+		bans = append(bans, ban)
+	}
+	cm.banMu.Unlock()
+
+	sort.Slice(bans, func(i, j int) bool {
+		if !bans[i].Until.Equal(bans[j].Until) {
+			return bans[i].Until.Before(bans[j].Until)
+		}
+		return bans[i].Peer < bans[j].Peer
+	})
+	return bans
+}
+
+// expireBans lifts the bans that have expired.
+func (cm *PhoreConnMgr) expireBans() {
+	now := cm.clock.Now()
+
+	cm.banMu.Lock()
+	defer cm.banMu.Unlock()
+	for id, ban := range cm.bans {
+		if !ban.Until.After(now) {
+			delete(cm.bans, id)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package connmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+// signalConn is a tconn signaling when it gets closed.
+type signalConn struct {
+	*tconn
+	closedCh chan struct{}
+}
+
+func (c *signalConn) Close() error {
+	err := c.tconn.Close()
+	close(c.closedCh)
+	return err
+}
+
+func TestBanPeer(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	c := randConn(t, not.Disconnected)
+	not.Connected(nil, c)
+	p := c.RemotePeer()
+
+	cm.BanPeer(p, time.Hour, "misbehaving")
+	if !c.(*tconn).closed {
+		t.Fatal("expected the banned peer to be disconnected")
+	}
+	if !cm.IsBanned(p) {
+		t.Fatal("expected the peer to be banned")
+	}
+	if bans := cm.Bans(); len(bans) != 1 || bans[0].Peer != p || bans[0].Reason != "misbehaving" || !bans[0].Until.Equal(clock.Now().Add(time.Hour)) {
+		t.Fatalf("unexpected bans %+v", bans)
+	}
+
+	// reconnections are closed without being tracked.
+	again := &signalConn{tconn: &tconn{peer: p, disconnectNotify: not.Disconnected}, closedCh: make(chan struct{})}
+	not.Connected(nil, again)
+	select {
+	case <-again.closedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the reconnection to be closed")
+	}
+	if cm.GetTagInfo(p) != nil || cm.GetInfo().ConnCount != 0 {
+		t.Fatal("expected the reconnection not to be tracked")
+	}
+
+	// bans expire.
+	clock.Add(time.Hour)
+	if cm.IsBanned(p) || len(cm.Bans()) != 0 {
+		t.Fatal("expected the ban to expire")
+	}
+
+	cm.BanPeer(p, time.Hour, "again")
+	if !cm.UnbanPeer(p) || cm.IsBanned(p) {
+		t.Fatal("expected the ban to be lifted")
+	}
+	if cm.UnbanPeer(p) {
+		t.Fatal("expected the peer not to be banned anymore")
+	}
+}
+
+func TestBanPeerDuringConnect(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(100, 200, 0, ps, map[protocol.ID]int{})
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// whichever of the ban and the connection comes first, the conn ends up closed.
+	for i := 0; i < 50; i++ {
+		tc := randConn(t, nil).(*tconn)
+		c := &signalConn{tconn: tc, closedCh: make(chan struct{})}
+		done := make(chan struct{})
+		go func() {
+			cm.BanPeer(tc.peer, time.Hour, "misbehaving")
+			close(done)
+		}()
+		not.Connected(nil, c)
+		<-done
+
+		select {
+		case <-c.closedCh:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("conn %d: expected the conn of the banned peer to be closed", i)
+		}
+		not.Disconnected(nil, c)
+	}
+	if n := cm.GetInfo().ConnCount; n != 0 {
+		t.Fatalf("expected no tracked conns, got %d", n)
+	}
+}
@@ -0,0 +1,98 @@
+package connmgr
+
+import (
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// bySegment groups peers by the segment tracking them.
+func (ss *segments) bySegment(ids []peer.ID) map[*segment][]peer.ID {
+	groups := make(map[*segment][]peer.ID)
+	for _, id := range ids {
+		s := ss.get(id)
+		groups[s] = append(groups[s], id)
+	}
+	return groups
+}
+
+// TagPeers is like calling TagPeer for every peer, tag and value in tags, but it locks
+// each segment only once for all the peers it tracks.
+func (cm *PhoreConnMgr) TagPeers(tags map[peer.ID]map[string]int) {
+	ids := make([]peer.ID, 0, len(tags))
+	for id := range tags {
+		ids = append(ids, id)
+	}
+
+	now := cm.clock.Now()
+	for s, group := range cm.segments.bySegment(ids) {
+		s.Lock()
+		for _, p := range group {
+			pi := cm.tagInfoFor(s, p)
+			if pi == nil {
+				log.Debug("temporary entry limit reached, dropping tags for untracked peer: ", p)
+				continue
+			}
+			cm.expireTags(pi, now)
+			for tag, val := range tags[p] {
+				delete(pi.expiry, tag)
+				cm.setTag(pi, tag, val, now)
+			}
+		}
+		s.Unlock()
+	}
+}
+
+// GetTagInfos is like calling GetTagInfo for every peer of ids, but it locks each
+// segment only once for all the peers it tracks. Untracked peers are left out of the
+// returned map.
+func (cm *PhoreConnMgr) GetTagInfos(ids []peer.ID) map[peer.ID]*connmgr.TagInfo {
+	infos := make(map[peer.ID]*connmgr.TagInfo, len(ids))
+
+	now := cm.clock.Now()
+	for s, group := range cm.segments.bySegment(ids) {
+		s.Lock()
+		for _, p := range group {
+			if pi, ok := s.peers[p]; ok {
+				infos[p] = cm.tagInfo(pi, now)
+			}
+		}
+		s.Unlock()
+	}
+	return infos
+}
+
+// UntagAll removes all the tags of p, e.g. when the subsystem that set them shuts down.
+func (cm *PhoreConnMgr) UntagAll(p peer.ID) {
+	s := cm.segments.get(p)
+	s.Lock()
+	defer s.Unlock()
+
+	pi, ok := s.peers[p]
+	if !ok {
+		return
+	}
+	now := cm.clock.Now()
+	for tag := range pi.tags {
+		cm.removeTag(pi, tag, now)
+	}
+}
+
+// ClearTag removes tag from every tracked peer, and returns the number of peers that
+// carried it.
+func (cm *PhoreConnMgr) ClearTag(tag string) int {
+	now := cm.clock.Now()
+
+	cleared := 0
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+		for _, pi := range s.peers {
+			cm.expireTags(pi, now)
+			if _, ok := pi.tags[tag]; ok {
+				cm.removeTag(pi, tag, now)
+				cleared++
+			}
+		}
+		s.Unlock()
+	}
+	return cleared
+}
@@ -0,0 +1,107 @@
+package connmgr
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestTagPeers(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithSegments(4, nil))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	tags := make(map[peer.ID]map[string]int)
+	for i := 0; i < 50; i++ {
+		c := randConn(t, nil)
+		not.Connected(nil, c)
+		cm.TagPeer(c.RemotePeer(), "gossip", 100)
+		tags[c.RemotePeer()] = map[string]int{"gossip": i, "sync": 1}
+	}
+	early := randConn(t, nil).RemotePeer()
+	tags[early] = map[string]int{"gossip": 7}
+
+	cm.TagPeers(tags)
+	for p, pt := range tags {
+		ti := cm.GetTagInfo(p)
+		if ti == nil {
+			t.Fatalf("expected %s to be tracked", p)
+		}
+		want := 0
+		for tag, v := range pt {
+			want += v
+			if ti.Tags[tag] != v {
+				t.Fatalf("expected tag %s of %s to be %d, got %d", tag, p, v, ti.Tags[tag])
+			}
+		}
+		if ti.Value != want {
+			t.Fatalf("expected a value of %d for %s, got %d", want, p, ti.Value)
+		}
+	}
+}
+
+func TestGetTagInfos(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithSegments(4, nil))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var ids []peer.ID
+	for i := 0; i < 20; i++ {
+		c := randConn(t, nil)
+		not.Connected(nil, c)
+		cm.TagPeer(c.RemotePeer(), "score", i)
+		ids = append(ids, c.RemotePeer())
+	}
+	untracked := randConn(t, nil).RemotePeer()
+
+	infos := cm.GetTagInfos(append(ids, untracked))
+	if len(infos) != len(ids) {
+		t.Fatalf("expected %d infos, got %d", len(ids), len(infos))
+	}
+	for i, id := range ids {
+		if infos[id] == nil || infos[id].Value != i || len(infos[id].Conns) != 1 {
+			t.Fatalf("unexpected info for peer %d: %+v", i, infos[id])
+		}
+	}
+	if _, ok := infos[untracked]; ok {
+		t.Fatal("expected untracked peers to be left out")
+	}
+}
+
+func TestUntagAllAndClearTag(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{})
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var ids []peer.ID
+	for i := 0; i < 3; i++ {
+		c := randConn(t, nil)
+		not.Connected(nil, c)
+		cm.TagPeer(c.RemotePeer(), "sync", 5)
+		cm.TagPeer(c.RemotePeer(), "relay", 2)
+		ids = append(ids, c.RemotePeer())
+	}
+
+	cm.UntagAll(ids[0])
+	if info := cm.GetTagInfo(ids[0]); info.Value != 0 || len(info.Tags) != 0 {
+		t.Fatalf("expected no tags left, got %+v", info)
+	}
+
+	if n := cm.ClearTag("sync"); n != 2 {
+		t.Fatalf("expected 2 peers to carry the tag, got %d", n)
+	}
+	for _, id := range ids[1:] {
+		if info := cm.GetTagInfo(id); info.Value != 2 || len(info.Tags) != 1 || info.Tags["relay"] != 2 {
+			t.Errorf("expected only the relay tag to be left, got %+v", info)
+		}
+	}
+	if n := cm.ClearTag("sync"); n != 0 {
+		t.Errorf("expected no peer to carry the tag anymore, got %d", n)
+	}
+}
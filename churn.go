@@ -0,0 +1,157 @@
+package connmgr
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultChurnWindow is the window the churn rate is computed over, unless configured
+// otherwise through WithChurnWindow.
+const DefaultChurnWindow = 10 * time.Minute
+
+// churnSlots is the number of slots the churn window is divided into. The window
+// slides by a slot at a time.
+const churnSlots = 10
+
+// SessionBuckets are the upper bounds of the buckets of the histogram of session
+// lengths reported in ChurnStats.
+var SessionBuckets = []time.Duration{
+	10 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+// ChurnStats describes how often peers connect and disconnect. Durations are encoded
+// to JSON as nanoseconds.
+type ChurnStats struct {
+	// Window is the window Connects, Disconnects and Rate are computed over.
+	Window time.Duration `json:"window"`
+
+	// Connects and Disconnects are the number of peers that connected, or
+	// disconnected their last connection, within the window.
+	Connects    int `json:"connects"`
+	Disconnects int `json:"disconnects"`
+
+	// Rate is the number of connects and disconnects per second within the window.
+	Rate float64 `json:"rate"`
+
+	// TotalConnects and TotalDisconnects are counted since the connection manager was
+	// created.
+	TotalConnects    int `json:"totalConnects"`
+	TotalDisconnects int `json:"totalDisconnects"`
+
+	// Sessions is the histogram of the lengths of the sessions ended since the
+	// connection manager was created, from the first connection of a peer opening to
+	// its last connection closing.
+	Sessions SessionHistogram `json:"sessions"`
+}
+
+// SessionHistogram is a histogram of session lengths.
+type SessionHistogram struct {
+	// Counts holds the number of sessions in each bucket: Counts[i] is the number of
+	// sessions longer than SessionBuckets[i-1] and no longer than SessionBuckets[i],
+	// and the last count is the number of sessions longer than all buckets.
+	Counts []int `json:"counts"`
+
+	// Count and Sum are the number of sessions, and their total length.
+	Count int           `json:"count"`
+	Sum   time.Duration `json:"sum"`
+}
+
+// churnTracker counts the peers connecting and disconnecting within a sliding window.
+type churnTracker struct {
+	sync.Mutex
+	window time.Duration
+
+	start       time.Time // when the current slot started
+	slot        int
+	connects    [churnSlots]int
+	disconnects [churnSlots]int
+
+	totalConnects    int
+	totalDisconnects int
+	sessions         SessionHistogram
+}
+
+// advance moves the window forward to now.
+func (ct *churnTracker) advance(now time.Time) {
+	width := ct.window / churnSlots
+	if ct.start.IsZero() || now.Sub(ct.start) >= ct.window+width {
+		// nothing recorded within the window, start afresh.
+		ct.start = now
+		ct.connects = [churnSlots]int{}
+		ct.disconnects = [churnSlots]int{}
+		return
+	}
+	for now.Sub(ct.start) >= width {
+		ct.start = ct.start.Add(width)
+		ct.slot = (ct.slot + 1) % churnSlots
+		ct.connects[ct.slot] = 0
+		ct.disconnects[ct.slot] = 0
+	}
+}
+
+// connected counts a peer connecting at now.
+func (ct *churnTracker) connected(now time.Time) {
+	ct.Lock()
+	defer ct.Unlock()
+
+	ct.advance(now)
+	ct.connects[ct.slot]++
+	ct.totalConnects++
+}
+
+// disconnected counts a peer disconnecting at now, after a session of the given length.
+func (ct *churnTracker) disconnected(now time.Time, session time.Duration) {
+	ct.Lock()
+	defer ct.Unlock()
+
+	ct.advance(now)
+	ct.disconnects[ct.slot]++
+	ct.totalDisconnects++
+
+	if ct.sessions.Counts == nil {
+		ct.sessions.Counts = make([]int, len(SessionBuckets)+1)
+	}
+	i := 0
+	for i < len(SessionBuckets) && session > SessionBuckets[i] {
+		i++
+	}
+	ct.sessions.Counts[i]++
+	ct.sessions.Count++
+	ct.sessions.Sum += session
+}
+
+// stats returns the churn statistics as of now.
+func (ct *churnTracker) stats(now time.Time) ChurnStats {
+	ct.Lock()
+	defer ct.Unlock()
+
+	ct.advance(now)
+	st := ChurnStats{
+		Window:           ct.window,
+		TotalConnects:    ct.totalConnects,
+		TotalDisconnects: ct.totalDisconnects,
+		Sessions: SessionHistogram{
+			Counts: make([]int, len(SessionBuckets)+1),
+			Count:  ct.sessions.Count,
+			Sum:    ct.sessions.Sum,
+		},
+	}
+	copy(st.Sessions.Counts, ct.sessions.Counts)
+	for i := 0; i < churnSlots; i++ {
+		st.Connects += ct.connects[i]
+		st.Disconnects += ct.disconnects[i]
+	}
+	st.Rate = float64(st.Connects+st.Disconnects) / ct.window.Seconds()
+	return st
+}
+
+// Churn returns how often peers connected and disconnected recently.
+func (cm *PhoreConnMgr) Churn() ChurnStats {
+	return cm.churn.stats(cm.clock.Now())
+}
@@ -0,0 +1,57 @@
+package connmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestChurn(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithTrimInterval(0), WithChurnWindow(time.Minute))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// a peer with two connections connects once, and disconnects once.
+	first := randConn(t, nil)
+	second := &tconn{peer: first.RemotePeer()}
+	not.Connected(nil, first)
+	not.Connected(nil, second)
+	clock.Add(30 * time.Second)
+	not.Disconnected(nil, first)
+	not.Disconnected(nil, second)
+
+	flapping := randConn(t, nil)
+	not.Connected(nil, flapping)
+	not.Disconnected(nil, flapping)
+
+	st := cm.GetInfo().Churn
+	if st.Window != time.Minute || st.Connects != 2 || st.Disconnects != 2 || st.Rate != 4.0/60 {
+		t.Fatalf("unexpected churn %+v", st)
+	}
+	if st.Sessions.Count != 2 || st.Sessions.Sum != 30*time.Second {
+		t.Fatalf("unexpected sessions %+v", st.Sessions)
+	}
+	// one session of 30s in the second bucket, and one instant one in the first.
+	if st.Sessions.Counts[0] != 1 || st.Sessions.Counts[1] != 1 {
+		t.Fatalf("unexpected session buckets %v", st.Sessions.Counts)
+	}
+
+	// the first peer leaves the window first.
+	clock.Add(36 * time.Second)
+	if st := cm.Churn(); st.Connects != 1 || st.Disconnects != 2 {
+		t.Fatalf("expected the first connect to leave the window, got %+v", st)
+	}
+	clock.Add(time.Hour)
+	st = cm.Churn()
+	if st.Connects != 0 || st.Disconnects != 0 || st.Rate != 0 {
+		t.Fatalf("expected an empty window, got %+v", st)
+	}
+	if st.TotalConnects != 2 || st.TotalDisconnects != 2 {
+		t.Fatalf("unexpected totals %+v", st)
+	}
+}
@@ -0,0 +1,97 @@
+package connmgr
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+// mockClock is a Clock whose time only moves through Add.
+type mockClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*mockTicker
+}
+
+func newMockClock() *mockClock {
+	return &mockClock{now: time.Unix(1500000000, 0)}
+}
+
+func (c *mockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *mockClock) NewTicker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &mockTicker{c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Add advances the clock, firing every ticker whose period elapsed.
+func (c *mockClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+type mockTicker struct {
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (t *mockTicker) C() <-chan time.Time { return t.c }
+func (t *mockTicker) Stop()               {}
+
+func TestMockClockDrivesGraceAndSilencePeriods(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 2, time.Minute, ps, map[protocol.ID]int{}, WithClock(clock), WithSilencePeriod(10*time.Second))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 3; i++ {
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+
+	cm.TrimOpenConns(context.Background())
+	if cm.GetInfo().ConnCount != 3 {
+		t.Fatal("expected connections within their grace period to be kept")
+	}
+
+	clock.Add(2 * time.Minute)
+	cm.TrimOpenConns(context.Background())
+	if n := cm.GetInfo().ConnCount; n != 1 {
+		t.Fatalf("expected a trim down to the low watermark after the grace period, got %d", n)
+	}
+	if !cm.GetInfo().LastTrim.Equal(clock.Now()) {
+		t.Fatal("expected the last trim to be timestamped by the mock clock")
+	}
+
+	for i := 0; i < 2; i++ {
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+	clock.Add(2 * time.Minute)
+	cm.TrimOpenConns(context.Background())
+	if n := cm.GetInfo().ConnCount; n != 1 {
+		t.Fatalf("expected another trim once the silence period passed, got %d", n)
+	}
+}
@@ -0,0 +1,46 @@
+package connmgr
+
+import (
+	"errors"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ErrNotConnected is returned by ClosePeer for peers without tracked connections.
+var ErrNotConnected = errors.New("connmgr: peer has no tracked connection")
+
+// ClosePeer closes all the tracked connections to id right away, whatever its tags and
+// protections, e.g. to enforce a ban decided by the application. InterceptReconnect
+// refuses the peer for ClosePeerCooldown afterwards, and if a reconnect cooldown is
+// configured with WithReconnectCooldown, the peer also enters it as if pruned by a
+// trim. It returns the first error met closing the connections.
+func (cm *PhoreConnMgr) ClosePeer(id peer.ID) error {
+	s := cm.segments.get(id)
+	s.Lock()
+	var conns []network.Conn
+	if pi, ok := s.peers[id]; ok {
+		for c := range pi.conns {
+			conns = append(conns, c)
+		}
+	}
+	s.Unlock()
+	if len(conns) == 0 {
+		return ErrNotConnected
+	}
+
+	// recorded first, so that the redial of a static peer woken by the disconnection
+	// sees the cooldown.
+	cm.recordClosed(id)
+	cm.recordPruned(conns)
+
+	// closing notifies the notifee, which locks the segment: do not hold it.
+	var firstErr error
+	for _, c := range conns {
+		log.Info("closing conn: ", id)
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
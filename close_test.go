@@ -0,0 +1,190 @@
+package connmgr
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	tu "github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestClosePeer(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithReconnectCooldown(time.Minute, CooldownReject, 0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	c := randConn(t, not.Disconnected)
+	extra := &tconn{peer: c.RemotePeer(), disconnectNotify: not.Disconnected}
+	other := randConn(t, not.Disconnected)
+	not.Connected(nil, c)
+	not.Connected(nil, extra)
+	not.Connected(nil, other)
+	cm.Protect(c.RemotePeer(), "important")
+
+	if err := cm.ClosePeer(c.RemotePeer()); err != nil {
+		t.Fatal(err)
+	}
+	if !c.(*tconn).closed || !extra.closed || other.(*tconn).closed {
+		t.Fatal("expected all the connections of the peer, and only those, to be closed")
+	}
+	if cm.GetTagInfo(c.RemotePeer()) != nil {
+		t.Fatal("expected the peer not to be tracked anymore")
+	}
+	if cm.InterceptReconnect(c.RemotePeer()) {
+		t.Fatal("expected the peer to enter the reconnect cooldown")
+	}
+
+	if err := cm.ClosePeer(c.RemotePeer()); err != ErrNotConnected {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	}
+}
+
+func TestClosePeerCooldown(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	d := &testDialer{fail: make(chan bool, 1), dials: make(chan *tconn, 1)}
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithTrimInterval(0), WithDialer(d, time.Minute))
+	defer cm.Close()
+	d.cm = cm
+
+	// a static peer is not redialed right after being closed.
+	id := tu.RandPeerIDFatal(t)
+	d.fail <- false
+	cm.AddStaticPeer(id)
+	c := <-d.dials
+	waitDialDone(t, cm, id)
+	c.disconnectNotify = cm.Notifee().Disconnected
+
+	d.fail <- false
+	if err := cm.ClosePeer(id); err != nil {
+		t.Fatal(err)
+	}
+	if cm.InterceptReconnect(id) {
+		t.Fatal("expected the closed peer to be refused without a configured cooldown")
+	}
+	select {
+	case <-d.dials:
+		t.Fatal("expected the closed static peer not to be redialed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Add(ClosePeerCooldown)
+	if !cm.InterceptReconnect(id) {
+		t.Fatal("expected the peer to be allowed once the cooldown ended")
+	}
+	cm.wakePeering()
+	if c := <-d.dials; c == nil || c.peer != id {
+		t.Fatal("expected the static peer to be redialed once the cooldown ended")
+	}
+	waitDialDone(t, cm, id)
+}
+
+func TestCloseWaitsForTrims(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{})
+	not := cm.Notifee()
+	for i := 0; i < 3; i++ {
+		not.Connected(nil, randConn(t, nil))
+	}
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	cm.SetCloseInterceptor(func(network.Conn) bool {
+		once.Do(func() { close(entered) })
+		<-release
+		return true
+	})
+	go cm.TrimOpenConns(context.Background())
+	<-entered
+
+	closed := make(chan struct{})
+	go func() {
+		cm.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("expected Close to wait for the trim in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to return once the trim is done")
+	}
+	if cm.Health().Background {
+		t.Error("expected the background loop to be stopped")
+	}
+	if _, err := cm.TrimOpenConnsResult(context.Background()); err != context.Canceled {
+		t.Errorf("expected trims to be refused once closed, got %v", err)
+	}
+}
+
+func TestCloseWaitsForTrimCallbacks(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithTrimReporter(func(TrimDetails) {
+		close(entered)
+		<-release
+	}))
+	not := cm.Notifee()
+	for i := 0; i < 3; i++ {
+		not.Connected(nil, randConn(t, nil))
+	}
+
+	go cm.TrimOpenConns(context.Background())
+	<-entered
+
+	closed := make(chan struct{})
+	go func() {
+		cm.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("expected Close to wait for the trim reporter")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to return once the trim reporter is done")
+	}
+}
+
+func TestCloseConnsOnShutdown(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithCloseConnsOnShutdown())
+	not := cm.Notifee()
+
+	var conns []*tconn
+	for i := 0; i < 3; i++ {
+		c := randConn(t, not.Disconnected).(*tconn)
+		conns = append(conns, c)
+		not.Connected(nil, c)
+	}
+
+	cm.Close()
+	for _, c := range conns {
+		if !c.closed {
+			t.Fatal("expected every connection to be closed")
+		}
+	}
+	if n := cm.GetInfo().ConnCount; n != 0 {
+		t.Errorf("expected no connection left, got %d", n)
+	}
+	// closing again is harmless.
+	cm.Close()
+}
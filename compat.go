@@ -0,0 +1,154 @@
+package connmgr
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/network"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// LegacyNotifiee is the notifiee interface of libp2p releases that still deliver
+// stream events to notifiees.
+type LegacyNotifiee interface {
+	ConnNotifiee
+	OpenedStream(network.Network, network.Stream)
+	ClosedStream(network.Network, network.Stream)
+}
+
+// ConnNotifiee is the notifiee interface of newer libp2p releases, which dropped the
+// stream callbacks.
+type ConnNotifiee interface {
+	Listen(network.Network, ma.Multiaddr)
+	ListenClose(network.Network, ma.Multiaddr)
+	Connected(network.Network, network.Conn)
+	Disconnected(network.Network, network.Conn)
+}
+
+// EvtConnOpened may be emitted on an event bus by components that report connection
+// lifecycle through the bus rather than through a notifiee.
+type EvtConnOpened struct {
+	Network network.Network
+	Conn    network.Conn
+}
+
+// EvtConnClosed is the counterpart of EvtConnOpened.
+type EvtConnClosed struct {
+	Network network.Network
+	Conn    network.Conn
+}
+
+// EvtStreamOpened may be emitted on an event bus by components that report stream
+// lifecycle through the bus rather than through a notifiee.
+type EvtStreamOpened struct {
+	Network network.Network
+	Stream  network.Stream
+}
+
+// EvtStreamClosed is the counterpart of EvtStreamOpened.
+type EvtStreamClosed struct {
+	Network network.Network
+	Stream  network.Stream
+}
+
+// DualNotifee adapts a PhoreConnMgr to every generation of libp2p notifications at
+// once: it satisfies both LegacyNotifiee and ConnNotifiee, and can additionally ingest
+// connection events from an event bus (see Subscribe). During a staged upgrade the
+// same connection may be reported through more than one of these channels, so
+// duplicate connection and stream notifications are dropped silently.
+type DualNotifee struct {
+	cm *PhoreConnMgr
+}
+
+var (
+	_ LegacyNotifiee = (*DualNotifee)(nil)
+	_ ConnNotifiee   = (*DualNotifee)(nil)
+)
+
+// DualNotifee returns an adapter that feeds this connection manager from legacy and
+// current notifiers, as well as from an event bus.
+func (cm *PhoreConnMgr) DualNotifee() *DualNotifee {
+	return &DualNotifee{cm: cm}
+}
+
+func (dn *DualNotifee) notifee() *cmNotifee {
+	return (*cmNotifee)(dn.cm)
+}
+
+// Listen is no-op in this implementation.
+func (dn *DualNotifee) Listen(n network.Network, addr ma.Multiaddr) {}
+
+// ListenClose is no-op in this implementation.
+func (dn *DualNotifee) ListenClose(n network.Network, addr ma.Multiaddr) {}
+
+// Connected starts tracking c, unless it is already being tracked.
+func (dn *DualNotifee) Connected(n network.Network, c network.Conn) {
+	dn.notifee().connected(c, true)
+}
+
+// Disconnected stops tracking c, unless it was not being tracked.
+func (dn *DualNotifee) Disconnected(n network.Network, c network.Conn) {
+	dn.notifee().disconnected(c, true)
+}
+
+// OpenedStream forwards the stream event to the connection manager.
+func (dn *DualNotifee) OpenedStream(n network.Network, s network.Stream) {
+	dn.notifee().OpenedStream(n, s)
+}
+
+// ClosedStream forwards the stream event to the connection manager.
+func (dn *DualNotifee) ClosedStream(n network.Network, s network.Stream) {
+	dn.notifee().ClosedStream(n, s)
+}
+
+// Subscribe consumes EvtConnOpened, EvtConnClosed, EvtStreamOpened and EvtStreamClosed
+// events from bus until the connection manager is closed, which waits for the event
+// being handled.
+func (dn *DualNotifee) Subscribe(bus event.Bus) error {
+	sub, err := bus.Subscribe([]interface{}{
+		new(EvtConnOpened),
+		new(EvtConnClosed),
+		new(EvtStreamOpened),
+		new(EvtStreamClosed),
+	})
+	if err != nil {
+		return err
+	}
+	if !dn.cm.enter() {
+		sub.Close()
+		return dn.cm.ctx.Err()
+	}
+
+	dn.cm.goLabelled("events", func(context.Context) {
+		defer dn.cm.running.Done()
+		defer sub.Close()
+		for {
+			select {
+			case evt, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				dn.handleEvent(evt)
+			case <-dn.cm.ctx.Done():
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (dn *DualNotifee) handleEvent(evt interface{}) {
+	switch evt := evt.(type) {
+	case EvtConnOpened:
+		dn.Connected(evt.Network, evt.Conn)
+	case EvtConnClosed:
+		dn.Disconnected(evt.Network, evt.Conn)
+	case EvtStreamOpened:
+		dn.OpenedStream(evt.Network, evt.Stream)
+	case EvtStreamClosed:
+		dn.ClosedStream(evt.Network, evt.Stream)
+	default:
+		log.Debugf("ignoring unexpected event of type %T", evt)
+	}
+}
@@ -0,0 +1,87 @@
+package connmgr
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+type chanSub chan interface{}
+
+func (s chanSub) Out() <-chan interface{} { return s }
+func (s chanSub) Close() error            { return nil }
+
+type chanBus struct {
+	sub chanSub
+}
+
+func (b *chanBus) Subscribe(interface{}, ...event.SubscriptionOpt) (event.Subscription, error) {
+	return b.sub, nil
+}
+
+func (b *chanBus) Emitter(interface{}, ...event.EmitterOpt) (event.Emitter, error) {
+	panic("not implemented")
+}
+
+func TestDualNotifeeDeduplicatesBusEvents(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{})
+	defer cm.Close()
+
+	dn := cm.DualNotifee()
+	bus := &chanBus{sub: make(chanSub)}
+	if err := dn.Subscribe(bus); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := randConn(t, nil)
+	dn.Connected(nil, conn)
+	// the same connection reported again over the bus must not be counted twice.
+	bus.sub <- EvtConnOpened{Conn: conn}
+	bus.sub <- EvtConnClosed{Conn: conn}
+	// a sync point: the subscriber has consumed the close event once this send returns.
+	bus.sub <- EvtConnClosed{Conn: conn}
+
+	if n := cm.GetInfo().ConnCount; n != 0 {
+		t.Fatalf("expected no connections, got %d", n)
+	}
+	if cm.GetTagInfo(conn.RemotePeer()) != nil {
+		t.Fatal("expected peer to be untracked")
+	}
+}
+
+func TestDualNotifeeSubscribeAfterClose(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{})
+	cm.Close()
+
+	if err := cm.DualNotifee().Subscribe(&chanBus{sub: make(chanSub)}); err == nil {
+		t.Fatal("expected subscribing to a closed connection manager to fail")
+	}
+}
+
+func TestDualNotifeeConcurrentDuplicates(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{})
+	defer cm.Close()
+	dn := cm.DualNotifee()
+
+	conn := randConn(t, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dn.Connected(nil, conn)
+		}()
+	}
+	wg.Wait()
+
+	if n := cm.GetInfo().ConnCount; n != 1 {
+		t.Fatalf("expected a single connection, got %d", n)
+	}
+}
@@ -0,0 +1,132 @@
+package connmgr
+
+import (
+	"context"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// Config mirrors the parameters of NewConnManager in a form that can be loaded from
+// JSON or YAML configuration files, e.g.:
+//
+//	{
+//	  "lowWater": 600,
+//	  "highWater": 900,
+//	  "gracePeriod": "20s",
+//	  "silencePeriod": "10s",
+//	  "protocolMinimums": {"/phore/sync/1.0.0": 8}
+//	}
+type Config struct {
+	// LowWater is the low watermark, as described in NewConnManager.
+	LowWater int `json:"lowWater" yaml:"lowWater"`
+
+	// HighWater is the high watermark, as described in NewConnManager.
+	HighWater int `json:"highWater" yaml:"highWater"`
+
+	// GracePeriod is the grace period, as described in NewConnManager.
+	GracePeriod Duration `json:"gracePeriod" yaml:"gracePeriod"`
+
+	// SilencePeriod is the minimum time between two trims. When unset, the value of
+	// the package-level SilencePeriod is used.
+	SilencePeriod *Duration `json:"silencePeriod,omitempty" yaml:"silencePeriod,omitempty"`
+
+	// ProtocolMinimums maps protocol IDs to the minimum number of peers supporting
+	// them that trims must leave connected.
+	ProtocolMinimums map[string]int `json:"protocolMinimums,omitempty" yaml:"protocolMinimums,omitempty"`
+}
+
+// Duration is a time.Duration that is encoded as a duration string such as "1m30s"
+// in configuration files.
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// protocolMinimums converts the configured minimums to the form used by
+// NewConnManager.
+func (cfg Config) protocolMinimums() map[protocol.ID]int {
+	minimums := make(map[protocol.ID]int, len(cfg.ProtocolMinimums))
+	for p, min := range cfg.ProtocolMinimums {
+		minimums[protocol.ID(p)] = min
+	}
+	return minimums
+}
+
+// silencePeriod returns the configured silence period, or the package default.
+func (cfg Config) silencePeriod() time.Duration {
+	if cfg.SilencePeriod == nil {
+		return SilencePeriod
+	}
+	return time.Duration(*cfg.SilencePeriod)
+}
+
+// validate checks the invariants documented in NewValidatedConnManager.
+func (cfg Config) validate(ps pstore.Peerstore) error {
+	return validateParams(cfg.LowWater, cfg.HighWater, time.Duration(cfg.GracePeriod), ps, cfg.protocolMinimums())
+}
+
+// NewFromConfig creates a PhoreConnMgr from cfg, enforcing the same invariants as
+// NewValidatedConnManager. Options are applied after the configuration.
+func NewFromConfig(cfg Config, ps pstore.Peerstore, opts ...Option) (*PhoreConnMgr, error) {
+	if err := cfg.validate(ps); err != nil {
+		return nil, err
+	}
+
+	opts = append([]Option{WithSilencePeriod(cfg.silencePeriod())}, opts...)
+	return NewConnManager(cfg.LowWater, cfg.HighWater, time.Duration(cfg.GracePeriod), ps, cfg.protocolMinimums(), opts...), nil
+}
+
+// ApplyConfig atomically replaces the watermarks, grace period, silence period and
+// protocol minimums of a running connection manager with those in cfg. The
+// configuration is validated first, and left untouched if it's rejected. The protocol
+// minimums are replaced as a whole: minimums set through SetMinimumPeersForProtocol
+// and missing from cfg are dropped. When a trim is in progress, ApplyConfig waits for
+// it to finish, so that no trim ever observes a mix of old and new settings, unless the
+// connection manager is closed first.
+func (cm *PhoreConnMgr) ApplyConfig(cfg Config) error {
+	return cm.ApplyConfigContext(context.Background(), cfg)
+}
+
+// ApplyConfigContext is ApplyConfig, but gives up waiting for the trim in progress and
+// returns the error of ctx once ctx is done.
+func (cm *PhoreConnMgr) ApplyConfigContext(ctx context.Context, cfg Config) error {
+	if err := cfg.validate(cm.peerstore); err != nil {
+		return err
+	}
+
+	select {
+	case cm.trimRunningCh <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-cm.ctx.Done():
+		return cm.ctx.Err()
+	}
+	defer func() { <-cm.trimRunningCh }()
+
+	cm.cfglk.Lock()
+	cm.lowWater = cfg.LowWater
+	cm.highWater = cfg.HighWater
+	cm.gracePeriod = time.Duration(cfg.GracePeriod)
+	cm.silencePeriod = cfg.silencePeriod()
+	cm.cfglk.Unlock()
+
+	cm.plk.Lock()
+	cm.minimumPeersForProtocol = cfg.protocolMinimums()
+	cm.plk.Unlock()
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package connmgr
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestNewFromConfig(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+
+	var cfg Config
+	err := json.Unmarshal([]byte(`{
+		"lowWater": 100,
+		"highWater": 200,
+		"gracePeriod": "30s",
+		"silencePeriod": "1m",
+		"protocolMinimums": {"/phore/1.0.0": 5}
+	}`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cm, err := NewFromConfig(cfg, ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm.Close()
+
+	info := cm.GetInfo()
+	if info.LowWater != 100 || info.HighWater != 200 || info.GracePeriod != 30*time.Second {
+		t.Fatalf("unexpected configuration: %+v", info)
+	}
+	if cm.silencePeriod != time.Minute {
+		t.Fatalf("unexpected silence period: %s", cm.silencePeriod)
+	}
+	if cm.minimumPeersForProtocol["/phore/1.0.0"] != 5 {
+		t.Fatal("expected protocol minimum to be loaded")
+	}
+
+	out, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var roundTrip Config
+	if err := json.Unmarshal(out, &roundTrip); err != nil {
+		t.Fatal(err)
+	}
+	if roundTrip.GracePeriod != cfg.GracePeriod || *roundTrip.SilencePeriod != *cfg.SilencePeriod {
+		t.Fatalf("durations did not survive a round trip: %s", out)
+	}
+
+	if _, err := NewFromConfig(Config{LowWater: 10, HighWater: 5}, ps); err == nil {
+		t.Fatal("expected an error for low watermark above high watermark")
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm, err := NewFromConfig(Config{LowWater: 10, HighWater: 20}, ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm.Close()
+
+	if err := cm.ApplyConfig(Config{LowWater: 30, HighWater: 20}); err == nil {
+		t.Fatal("expected an invalid configuration to be rejected")
+	}
+	if info := cm.GetInfo(); info.LowWater != 10 || info.HighWater != 20 {
+		t.Fatal("expected a rejected configuration to leave the settings untouched")
+	}
+
+	cm.SetMinimumPeersForProtocol("/phore/2.0.0", 1)
+	silence := Duration(0)
+	err = cm.ApplyConfig(Config{
+		LowWater:         50,
+		HighWater:        100,
+		GracePeriod:      Duration(time.Minute),
+		SilencePeriod:    &silence,
+		ProtocolMinimums: map[string]int{"/phore/1.0.0": 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := cm.GetInfo()
+	if info.LowWater != 50 || info.HighWater != 100 || info.GracePeriod != time.Minute {
+		t.Fatalf("unexpected configuration after reload: %+v", info)
+	}
+	if _, s := cm.timing(); s != 0 {
+		t.Fatalf("unexpected silence period after reload: %s", s)
+	}
+	if m := cm.ProtocolMinimums(); len(m) != 1 || m["/phore/1.0.0"] != 3 {
+		t.Fatalf("expected protocol minimums to be replaced, got %v", m)
+	}
+}
+
+func TestApplyConfigWaitsForTrim(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm, err := NewFromConfig(Config{LowWater: 10, HighWater: 20}, ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm.Close()
+
+	// hold the trim semaphore, as a long running trim would.
+	cm.trimRunningCh <- struct{}{}
+	defer func() { <-cm.trimRunningCh }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := cm.ApplyConfigContext(ctx, Config{LowWater: 50, HighWater: 100}); err != context.DeadlineExceeded {
+		t.Fatalf("expected the wait for the trim to be cancelled, got %v", err)
+	}
+	if info := cm.GetInfo(); info.LowWater != 10 || info.HighWater != 20 {
+		t.Fatal("expected a cancelled reload to leave the settings untouched")
+	}
+}
@@ -2,7 +2,10 @@ package connmgr
 
 import (
 	"context"
-	"sort"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,6 +18,7 @@ import (
 
 	logging "github.com/ipfs/go-log"
 	ma "github.com/multiformats/go-multiaddr"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var SilencePeriod = 10 * time.Second
@@ -31,22 +35,242 @@ var log = logging.Logger("connmgr")
 //
 // See configuration parameters in NewConnManager.
 type PhoreConnMgr struct {
-	highWater   int
-	lowWater    int
-	connCount   int32
-	gracePeriod time.Duration
-	segments    segments
+	// cfglk guards the settings that can be changed at runtime.
+	cfglk         sync.RWMutex
+	highWater     int
+	lowWater      int
+	gracePeriod   time.Duration
+	silencePeriod time.Duration
+	interceptor   func(network.Conn) bool // see SetCloseInterceptor
+
+	connCount int32
+	peerCount int32 // peers holding at least one connection
+	basis     WatermarkBasis
+	segments  segments
+	policy    TrimPolicy
 
 	plk                     sync.RWMutex
 	protected               map[peer.ID]map[string]struct{}
 	minimumPeersForProtocol map[protocol.ID]int
+	maximumPeersForProtocol map[protocol.ID]int
+	protectedProtocols      map[protocol.ID]struct{} // see ProtectProtocol
+	protectedAddrs          addrMatcher              // see ProtectAddr and ProtectCIDR
+	protocolAccounting      ProtocolAccounting
+
+	dialHints         func([]DialHint)
+	dialHintThreshold int
 
 	peerstore pstore.Peerstore
 
 	// channel-based semaphore that enforces only a single trim is in progress
 	trimRunningCh chan struct{}
-	lastTrim      time.Time
-	silencePeriod time.Duration
+	trimInterval  time.Duration
+
+	// callback run when the count drops below a threshold, see WithLowPeerCallback.
+	lowPeers          func(count, threshold int)
+	lowPeersThreshold int
+	lowPeersFired     int32
+
+	// peers kept connected through the dialer, see WithDialer and AddStaticPeer.
+	dialer       Dialer
+	dialInterval time.Duration
+	staticMu     sync.Mutex
+	static       map[peer.ID]*staticPeer
+	staticWake   chan struct{}
+
+	// minimum number of connected peers, see WithMinPeers.
+	minPeers  int
+	needPeers chan PeerDemand
+
+	// goroutines and trims Close waits for, which refuse to start once closed is set.
+	closeMu    sync.Mutex
+	closed     bool
+	running    sync.WaitGroup
+	closeConns bool // see WithCloseConnsOnShutdown
+
+	// state of the background loop reported by Health.
+	backgroundRunning int32
+	overloadedSince   int64 // unix nanoseconds, zero while at or below the high watermark
+	overloadedAfter   time.Duration
+
+	// requests awaiting the pending trim, see RequestTrim.
+	trimReqMu    sync.Mutex
+	trimRequests []chan TrimReport
+
+	// slots below the high watermark kept free for outbound dials, see
+	// WithReservedOutboundSlots.
+	reservedOutbound int
+
+	// hysteresis of the background loop, see WithTrimHysteresis. overHighSince is only
+	// touched by the background goroutine.
+	hysteresisDelay  time.Duration
+	hysteresisMargin int
+	overHighSince    time.Time
+
+	maxClosesPerTrim    int
+	pruneDuplicates     bool
+	comparator          func(a, b PeerSnapshot) bool
+	pruneTemperature    float64
+	directionPreference DirectionPreference
+
+	// bounds of tag values and of peer values, see WithTagBounds, WithNegativeTagFloor
+	// and WithMaxPeerValue.
+	tagBounds        map[string]TagBounds
+	negativeTagFloor int
+	maxPeerValue     int
+
+	// multipliers of namespaced tag values, see WithNamespaceWeights.
+	namespaceWeights map[string]float64
+
+	// tag prefixes evicted proportionally, see WithFairEviction.
+	tagClasses []string
+
+	// treatment of transient connections, see WithTransientConns.
+	isTransient       func(network.Conn) bool
+	transientHandling TransientHandling
+
+	// treatment of relayed connections, see WithRelayPolicy.
+	relayPolicy RelayPolicy
+	maxRelayed  int
+
+	// bonus for long-lived peers, see WithAgeBonus.
+	ageWeight float64
+	ageCurve  AgeCurve
+
+	// bonus for peers supporting priority protocols, see WithProtocolWeights.
+	protocolWeights map[protocol.ID]float64
+
+	// what the grace period applies to, see WithGraceBasis.
+	graceBasis GraceBasis
+
+	// tags removing the grace period of peers, see WithGraceExemptTags.
+	graceExemptTags []string
+
+	// peers pruned recently, see WithReconnectCooldown.
+	cooldown        time.Duration
+	cooldownMode    CooldownMode
+	cooldownPenalty float64
+	cooldownMu      sync.Mutex
+	pruned          map[peer.ID]time.Time
+	closedPeers     map[peer.ID]time.Time // closed with ClosePeer, see ClosePeerCooldown
+
+	// tags of recently disconnected peers, see WithTagRetention.
+	retention time.Duration
+	retainMu  sync.Mutex
+	retained  map[peer.ID]*retainedPeer
+
+	// durations of the sessions of peers, see WithSessionStats.
+	sessionMu sync.Mutex
+	sessions  *sessionRecorder
+
+	// peers exempt from trimming and watermarks, see WithAllowlist.
+	allowPeers       map[peer.ID]struct{}
+	allowAddrs       addrMatcher
+	allowedConnCount int32
+	allowedPeerCount int32 // peers holding at least one allowlisted connection
+
+	// connections per remote IP address, see WithMaxConnsPerIP.
+	maxConnsPerIP int
+	ipMu          sync.Mutex
+	ipConns       map[string]int
+
+	// banned peers, see BanPeer.
+	banMu sync.Mutex
+	bans  map[peer.ID]Ban
+
+	// score contributors, see RegisterScoreContributor.
+	contributorsMu sync.RWMutex
+	contributors   map[string]ScoreContributor
+
+	// callbacks notified of pruned peers, see RegisterOnPeerPruned.
+	prunedHooksMu sync.RWMutex
+	prunedHooks   map[string]OnPeerPruned
+
+	// event bus emitters, see WithEventBus.
+	events *emitters
+
+	// persisted peer reputations, see WithReputationStore.
+	store        ReputationStore
+	saveInterval time.Duration
+	lastSave     time.Time
+	saveMu       sync.Mutex
+	storeMu      sync.Mutex
+	stored       map[peer.ID]PeerRecord
+
+	// bonus for every trim survived, see WithSurvivorBonus.
+	survivorBonus float64
+
+	// penalty for high latency peers, see WithLatencyPenalty.
+	latencyWeight float64
+	latencyUnit   time.Duration
+
+	// subnet diversity pass, see WithSubnetDiversity.
+	subnetDiversity bool
+	maxPerSubnet    int
+
+	// geographic diversity pass, see WithGeoDiversity.
+	geoIP           GeoIPProvider
+	maxCountryShare float64
+
+	// peers without activity for this long are pruned first, see WithIdleTimeout.
+	idleTimeout time.Duration
+
+	// temporary entries buffering early tags, see WithMaxTempEntries.
+	tempCount      int32
+	maxTempEntries int
+
+	// connection count triggering an emergency trim, see WithCriticalWater.
+	criticalWater    int
+	emergencyPending int32
+
+	// overload ratio triggering an emergency trim, see WithOverloadTrim.
+	overloadRatio        float64
+	overloadIgnoresGrace bool
+
+	// watermarks derived from the file descriptor limit, see WithFDLimitWatermarks.
+	fdHighFraction float64
+	fdLowFraction  float64
+	fdLimit        uint64
+
+	// fraction of the file descriptor limit triggering an emergency trim, see
+	// WithFDExhaustionTrim.
+	fdExhaustionFraction float64
+	fdExhausted          int32
+
+	// memory usage lowering the watermarks, see WithMemoryPressure.
+	memProbe     MemoryProbe
+	memThreshold uint64
+	memFactor    float64
+	memPressure  int32
+
+	lastTrimMu     sync.RWMutex
+	lastTrim       time.Time
+	lastTrimClosed int // connections closed by the last trim
+	trims          int // trims performed so far
+	closedTotal    int // connections closed by all trims so far
+	lastReport     *TrimDetails
+	lastTrimCost   TrimCost
+	totalTrimCost  TrimCost
+
+	// peerstore lookups counted toward the cost of trims, see TrimCost.
+	peerstoreLookups int64
+
+	// receives the report of every trim, see WithTrimReporter.
+	trimReporter func(TrimDetails)
+
+	clock Clock
+
+	// tracer of the spans of trims, the global one when nil.
+	tracer trace.Tracer
+
+	// peers connecting and disconnecting, see WithChurnWindow.
+	churn churnTracker
+
+	// connected peers per protocol, see WithProtocolGauges.
+	protoGauges *protocolGauges
+
+	// recent events, see WithAuditLog.
+	audit *auditLog
 
 	ctx    context.Context
 	cancel func()
@@ -59,14 +283,64 @@ type segment struct {
 	peers map[peer.ID]*peerInfo
 }
 
-type segments [256]*segment
+// DefaultSegmentCount is the number of segments peers are sharded into, unless
+// configured otherwise through WithSegments.
+const DefaultSegmentCount = 256
+
+// SegmentHash maps a peer to a number from which the segment holding the peer is
+// chosen, by taking it modulo the segment count.
+type SegmentHash func(p peer.ID) uint32
+
+// LastByteHash uses the last byte of the peer ID. It is cheap, but it only yields 256
+// distinct values, so it's only sensible for segment counts up to 256.
+func LastByteHash(p peer.ID) uint32 {
+	return uint32(p[len(p)-1])
+}
+
+// FNVHash computes the 32-bit FNV-1a hash of the peer ID, spreading peers evenly over
+// any number of segments.
+func FNVHash(p peer.ID) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(p))
+	return h.Sum32()
+}
+
+type segments struct {
+	buckets []*segment
+	hash    SegmentHash
+}
+
+// newSegments creates count segments addressed through hash. A nil hash selects
+// LastByteHash for up to 256 segments, and FNVHash otherwise.
+func newSegments(count int, hash SegmentHash) segments {
+	if count <= 0 {
+		count = DefaultSegmentCount
+	}
+	if hash == nil {
+		hash = LastByteHash
+		if count > 256 {
+			hash = FNVHash
+		}
+	}
+
+	ss := segments{
+		buckets: make([]*segment, count),
+		hash:    hash,
+	}
+	for i := range ss.buckets {
+		ss.buckets[i] = &segment{
+			peers: make(map[peer.ID]*peerInfo),
+		}
+	}
+	return ss
+}
 
 func (ss *segments) get(p peer.ID) *segment {
-	return ss[byte(p[len(p)-1])]
+	return ss.buckets[ss.hash(p)%uint32(len(ss.buckets))]
 }
 
 func (ss *segments) countPeers() (count int) {
-	for _, seg := range ss {
+	for _, seg := range ss.buckets {
 		seg.Lock()
 		count += len(seg.peers)
 		seg.Unlock()
@@ -74,61 +348,247 @@ func (ss *segments) countPeers() (count int) {
 	return count
 }
 
-func (s *segment) tagInfoFor(p peer.ID) *peerInfo {
+// tagInfoFor returns the peerInfo tracking p, creating a temporary entry for it if
+// necessary. It returns nil if a temporary entry is needed but the limit set through
+// WithMaxTempEntries has been reached. s must be the segment of p, and locked.
+func (cm *PhoreConnMgr) tagInfoFor(s *segment, p peer.ID) *peerInfo {
 	pi, ok := s.peers[p]
 	if ok {
 		return pi
 	}
+	if n := atomic.AddInt32(&cm.tempCount, 1); cm.maxTempEntries >= 0 && int(n) > cm.maxTempEntries {
+		atomic.AddInt32(&cm.tempCount, -1)
+		return nil
+	}
 	// create a temporary peer to buffer early tags before the Connected notification arrives.
 	pi = &peerInfo{
 		id:        p,
-		firstSeen: time.Now(), // this timestamp will be updated when the first Connected notification arrives.
+		firstSeen: cm.clock.Now(), // this timestamp will be updated when the first Connected notification arrives.
 		temp:      true,
 		tags:      make(map[string]int),
-		conns:     make(map[network.Conn]time.Time),
+		conns:     make(map[network.Conn]*connInfo),
 	}
 	s.peers[p] = pi
 	return pi
 }
 
 // NewConnManager creates a new PhoreConnMgr with the provided params:
-// * lo and hi are watermarks governing the number of connections that'll be maintained.
-//   When the peer count exceeds the 'high watermark', as many peers will be pruned (and
-//   their connections terminated) until 'low watermark' peers remain.
-// * grace is the amount of time a newly opened connection is given before it becomes
-//   subject to pruning.
-func NewConnManager(low, hi int, grace time.Duration, peerstore pstore.Peerstore, protectedProtocols map[protocol.ID]int) *PhoreConnMgr {
+//   - lo and hi are watermarks governing the number of connections that'll be maintained.
+//     When the peer count exceeds the 'high watermark', as many peers will be pruned (and
+//     their connections terminated) until 'low watermark' peers remain.
+//   - grace is the amount of time a newly opened connection is given before it becomes
+//     subject to pruning.
+//   - protectedProtocols maps protocols to the minimum number of peers supporting them
+//     that trims must leave connected.
+//   - opts tune optional behaviour; see the Option constructors in options.go.
+func NewConnManager(low, hi int, grace time.Duration, peerstore pstore.Peerstore, protectedProtocols map[protocol.ID]int, opts ...Option) *PhoreConnMgr {
 	ctx, cancel := context.WithCancel(context.Background())
 	cm := &PhoreConnMgr{
-		highWater:     hi,
-		lowWater:      low,
-		gracePeriod:   grace,
-		trimRunningCh: make(chan struct{}, 1),
-		protected:     make(map[peer.ID]map[string]struct{}, 16),
-		peerstore: peerstore,
-		silencePeriod: SilencePeriod,
-		ctx:           ctx,
-		cancel:        cancel,
+		highWater:               hi,
+		lowWater:                low,
+		gracePeriod:             grace,
+		trimRunningCh:           make(chan struct{}, 1),
+		protected:               make(map[peer.ID]map[string]struct{}, 16),
+		peerstore:               peerstore,
+		silencePeriod:           SilencePeriod,
+		trimInterval:            time.Minute,
+		maxTempEntries:          -1,
+		clock:                   realClock{},
+		ctx:                     ctx,
+		cancel:                  cancel,
 		minimumPeersForProtocol: protectedProtocols,
-		segments: func() (ret segments) {
-			for i := range ret {
-				ret[i] = &segment{
-					peers: make(map[peer.ID]*peerInfo),
-				}
-			}
-			return ret
-		}(),
+		segments:                newSegments(DefaultSegmentCount, nil),
+	}
+	cm.churn.window = DefaultChurnWindow
+
+	for _, opt := range opts {
+		opt(cm)
 	}
+	cm.refreshFDWatermarks()
+	cm.loadReputations()
 
-	go cm.background()
+	if cm.trimInterval > 0 {
+		atomic.StoreInt32(&cm.backgroundRunning, 1)
+		cm.running.Add(1)
+		cm.goLabelled("background", func(context.Context) { cm.background() })
+	}
+	if cm.dialer != nil {
+		cm.running.Add(1)
+		cm.goLabelled("peering", func(context.Context) { cm.peeringLoop() })
+	}
 	return cm
 }
 
+// NewValidatedConnManager is like NewConnManager, but it rejects nonsensical
+// configurations instead of silently misbehaving later. The following invariants are
+// enforced:
+// * 0 <= lo <= hi. Either watermark being zero disables trimming altogether.
+// * grace is not negative.
+// * peerstore is not nil, as it is consulted for protocol support during trims.
+// * no per-protocol minimum is negative.
+func NewValidatedConnManager(low, hi int, grace time.Duration, peerstore pstore.Peerstore, protectedProtocols map[protocol.ID]int, opts ...Option) (*PhoreConnMgr, error) {
+	if err := validateParams(low, hi, grace, peerstore, protectedProtocols); err != nil {
+		return nil, err
+	}
+	return NewConnManager(low, hi, grace, peerstore, protectedProtocols, opts...), nil
+}
+
+// validateParams checks the invariants documented in NewValidatedConnManager.
+func validateParams(low, hi int, grace time.Duration, peerstore pstore.Peerstore, protectedProtocols map[protocol.ID]int) error {
+	if err := validateWatermarks(low, hi); err != nil {
+		return err
+	}
+	if grace < 0 {
+		return fmt.Errorf("connmgr: grace period must not be negative (%s)", grace)
+	}
+	if peerstore == nil {
+		return fmt.Errorf("connmgr: a peerstore is required")
+	}
+	for p, min := range protectedProtocols {
+		if min < 0 {
+			return fmt.Errorf("connmgr: minimum peers for protocol %s must not be negative (%d)", p, min)
+		}
+	}
+	return nil
+}
+
+// validateWatermarks checks the watermark invariants documented in
+// NewValidatedConnManager.
+func validateWatermarks(low, hi int) error {
+	if low < 0 || hi < 0 {
+		return fmt.Errorf("connmgr: watermarks must not be negative (low: %d, high: %d)", low, hi)
+	}
+	if low > hi {
+		return fmt.Errorf("connmgr: low watermark (%d) exceeds high watermark (%d)", low, hi)
+	}
+	return nil
+}
+
+// Close stops the background loop and waits for it, as well as for the trims in
+// progress and their notifications, to finish. Trims requested from then on do not run. If configured with
+// WithCloseConnsOnShutdown, the tracked connections are closed too. Close must not be
+// called from the callbacks run by trims, which it would wait for.
 func (cm *PhoreConnMgr) Close() error {
+	cm.closeMu.Lock()
+	first := !cm.closed
+	cm.closed = true
+	cm.closeMu.Unlock()
+
 	cm.cancel()
+	cm.running.Wait()
+	if first {
+		if cm.closeConns {
+			cm.closeAllConns()
+		}
+		if cm.events != nil {
+			cm.events.close()
+		}
+	}
+	return cm.saveReputations()
+}
+
+// enter registers a trim, or another task, for Close to wait for, unless the connection
+// manager is closed. Callers that entered must call cm.running.Done once done.
+func (cm *PhoreConnMgr) enter() bool {
+	cm.closeMu.Lock()
+	defer cm.closeMu.Unlock()
+
+	if cm.closed {
+		return false
+	}
+	cm.running.Add(1)
+	return true
+}
+
+// closeAllConns closes every tracked connection.
+func (cm *PhoreConnMgr) closeAllConns() {
+	var conns []network.Conn
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+		for _, pi := range s.peers {
+			for c := range pi.conns {
+				conns = append(conns, c)
+			}
+		}
+		s.Unlock()
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// SetWatermarks changes the low and high watermarks of a running connection manager.
+// The new values take effect from the next trim onwards, whether it is triggered by
+// the background loop or explicitly through TrimOpenConns. Watermarks rejected by
+// NewValidatedConnManager are rejected here too, leaving the current ones in place.
+func (cm *PhoreConnMgr) SetWatermarks(low, hi int) error {
+	if err := validateWatermarks(low, hi); err != nil {
+		return err
+	}
+
+	cm.cfglk.Lock()
+	defer cm.cfglk.Unlock()
+
+	cm.lowWater = low
+	cm.highWater = hi
 	return nil
 }
 
+// watermarks returns the current low and high watermarks, lowered while under memory
+// pressure (see WithMemoryPressure).
+func (cm *PhoreConnMgr) watermarks() (low, hi int) {
+	cm.cfglk.RLock()
+	low, hi = cm.lowWater, cm.highWater
+	cm.cfglk.RUnlock()
+
+	if low > 0 && hi > 0 && cm.underMemoryPressure() {
+		// never scale down to zero, which would disable trimming altogether.
+		low = int(math.Max(1, float64(low)*cm.memFactor))
+		hi = int(math.Max(1, float64(hi)*cm.memFactor))
+	}
+	return low, hi
+}
+
+// SetCloseInterceptor installs a hook consulted by trims for every connection they
+// selected, before closing it: when interceptor returns false, the connection is left
+// open. This lets the application veto closes, e.g. of connections with in-flight
+// downloads. Vetoed connections are not replaced with other candidates. Passing nil
+// removes the hook. The interceptor is called without holding any locks.
+func (cm *PhoreConnMgr) SetCloseInterceptor(interceptor func(network.Conn) bool) {
+	cm.cfglk.Lock()
+	defer cm.cfglk.Unlock()
+
+	cm.interceptor = interceptor
+}
+
+// intercept returns the connections the close interceptor lets trims close.
+func (cm *PhoreConnMgr) intercept(conns []network.Conn) []network.Conn {
+	cm.cfglk.RLock()
+	interceptor := cm.interceptor
+	cm.cfglk.RUnlock()
+	if interceptor == nil {
+		return conns
+	}
+
+	allowed := conns[:0]
+	for _, c := range conns {
+		if interceptor(c) {
+			allowed = append(allowed, c)
+		} else {
+			log.Info("close vetoed by interceptor: ", c.RemotePeer())
+		}
+	}
+	return allowed
+}
+
+// timing returns the current grace and silence periods.
+func (cm *PhoreConnMgr) timing() (grace, silence time.Duration) {
+	cm.cfglk.RLock()
+	defer cm.cfglk.RUnlock()
+
+	return cm.gracePeriod, cm.silencePeriod
+}
+
 func (cm *PhoreConnMgr) Protect(id peer.ID, tag string) {
 	cm.plk.Lock()
 	defer cm.plk.Unlock()
@@ -139,6 +599,7 @@ func (cm *PhoreConnMgr) Protect(id peer.ID, tag string) {
 		cm.protected[id] = tags
 	}
 	tags[tag] = struct{}{}
+	cm.auditProtection(AuditProtected, id, tag)
 }
 
 func (cm *PhoreConnMgr) Unprotect(id peer.ID, tag string) (protected bool) {
@@ -149,6 +610,9 @@ func (cm *PhoreConnMgr) Unprotect(id peer.ID, tag string) (protected bool) {
 	if !ok {
 		return false
 	}
+	if _, ok := tags[tag]; ok {
+		cm.auditProtection(AuditUnprotected, id, tag)
+	}
 	if delete(tags, tag); len(tags) == 0 {
 		delete(cm.protected, id)
 		return false
@@ -156,56 +620,399 @@ func (cm *PhoreConnMgr) Unprotect(id peer.ID, tag string) (protected bool) {
 	return true
 }
 
+// IsProtected reports whether id is protected under tag, or under any tag if tag is
+// empty.
+func (cm *PhoreConnMgr) IsProtected(id peer.ID, tag string) (protected bool) {
+	cm.plk.RLock()
+	defer cm.plk.RUnlock()
+
+	tags, ok := cm.protected[id]
+	if !ok {
+		return false
+	}
+	if tag == "" {
+		return true
+	}
+	_, protected = tags[tag]
+	return protected
+}
+
 // peerInfo stores metadata for a given peer.
 type peerInfo struct {
-	id    peer.ID
-	tags  map[string]int // value for each tag
-	value int            // cached sum of all tag values
-	temp  bool           // this is a temporary entry holding early tags, and awaiting connections
+	id     peer.ID
+	tags   map[string]int       // value for each tag
+	expiry map[string]time.Time // expiry of the tags set with a TTL
+	value  int                  // cached sum of all tag values, weighted by namespace
+	temp   bool                 // this is a temporary entry holding early tags, and awaiting connections
+
+	conns map[network.Conn]*connInfo
+
+	firstSeen   time.Time // timestamp when we began tracking this peer.
+	connectedAt time.Time // timestamp when the first connection of the session opened.
+	lastSeen    time.Time // timestamp of the last connection opened or closed.
+	lastStream  time.Time // timestamp of the last stream opened or closed.
+	lastTagged  time.Time // timestamp of the last tag update.
+
+	survived int // number of trims survived with at least one connection closed.
+	allowed  int // number of allowlisted connections, see WithAllowlist.
+}
 
-	conns map[network.Conn]time.Time // start time of each connection
+// onlyTransient reports whether the peer is connected through transient connections
+// only.
+func (pi *peerInfo) onlyTransient() bool {
+	for _, ci := range pi.conns {
+		if !ci.transient {
+			return false
+		}
+	}
+	return len(pi.conns) > 0
+}
+
+// inboundCount returns how much the peer counts toward the inbound connections, given
+// the watermark basis: its number of inbound connections, or one if all its
+// connections are inbound.
+func (pi *peerInfo) inboundCount(basis WatermarkBasis) int {
+	n := 0
+	for _, ci := range pi.conns {
+		if ci.dir == network.DirInbound {
+			n++
+		}
+	}
+	if basis == PeerBasis {
+		if n > 0 && n == len(pi.conns) {
+			return 1
+		}
+		return 0
+	}
+	return n
+}
 
-	firstSeen time.Time // timestamp when we began tracking this peer.
+// connInfo stores metadata for a given connection.
+type connInfo struct {
+	opened    time.Time                   // timestamp when the connection was reported.
+	dir       network.Direction           // direction of the connection.
+	relayed   bool                        // whether the connection goes through a relay.
+	transient bool                        // whether the connection is transient.
+	allowed   bool                        // whether the connection is allowlisted.
+	streams   map[network.Stream]struct{} // active streams, allocated on first use.
 }
 
 // TrimOpenConns closes the connections of as many peers as needed to make the peer count
 // equal the low watermark. Peers are sorted in ascending order based on their total value,
 // pruning those peers with the lowest scores first, as long as they are not within their
-// grace period.
-//
-// TODO: error return value so we can cleanly signal we are aborting because:
-// (a) there's another trim in progress, or (b) the silence period is in effect.
+// grace period. See TrimOpenConnsResult for a variant reporting the outcome.
 func (cm *PhoreConnMgr) TrimOpenConns(ctx context.Context) {
-	select {
-	case cm.trimRunningCh <- struct{}{}:
-	default:
-		return
+	cm.TrimOpenConnsResult(ctx)
+}
+
+var (
+	// ErrTrimInProgress is returned by TrimOpenConnsResult when another trim is running.
+	ErrTrimInProgress = errors.New("connmgr: a trim is already in progress")
+
+	// ErrSilencePeriod is returned by TrimOpenConnsResult when the last trim took place
+	// less than the silence period ago.
+	ErrSilencePeriod = errors.New("connmgr: the silence period is in effect")
+)
+
+// TrimResult is the outcome of a trim.
+type TrimResult struct {
+	// Closed is the number of connections closed.
+	Closed int
+
+	// Peers are the peers that had connections closed.
+	Peers []peer.ID
+}
+
+// TrimOpenConnsResult is like TrimOpenConns, but it reports the connections closed, or
+// why the trim did not run: ErrTrimInProgress, ErrSilencePeriod, or context.Canceled
+// once the connection manager is closed. If ctx is done before the trim closed all the
+// connections it selected, the trim stops there, and the connections closed so far are
+// reported along with the error of ctx.
+func (cm *PhoreConnMgr) TrimOpenConnsResult(ctx context.Context) (TrimResult, error) {
+	plan, err := cm.trim(ctx, trimOpts{})
+	return plan.result(), err
+}
+
+// TrimReport is delivered by RequestTrim once the requested trim is done.
+type TrimReport struct {
+	Result TrimResult
+
+	// Err is set if the trim did not run or was cut short, as for
+	// TrimOpenConnsResult, or if the connection manager was closed first.
+	Err error
+}
+
+// RequestTrim schedules a trim without blocking, and returns a channel delivering its
+// report once done. If a trim is in progress, the requested one runs after it instead
+// of being skipped, although it is still subject to the silence period. Requests made
+// while a trim is pending share it, and its report.
+func (cm *PhoreConnMgr) RequestTrim() <-chan TrimReport {
+	ch := make(chan TrimReport, 1)
+
+	cm.trimReqMu.Lock()
+	defer cm.trimReqMu.Unlock()
+	cm.trimRequests = append(cm.trimRequests, ch)
+	if len(cm.trimRequests) == 1 {
+		cm.goLabelled("trim-requests", func(context.Context) { cm.serveTrimRequests() })
+	}
+	return ch
+}
+
+// serveTrimRequests runs the trim pending for the queued requests, and reports it to
+// them.
+func (cm *PhoreConnMgr) serveTrimRequests() {
+	plan, err := cm.trim(cm.ctx, trimOpts{wait: true})
+
+	// requests made from now on need another trim.
+	cm.trimReqMu.Lock()
+	requests := cm.trimRequests
+	cm.trimRequests = nil
+	cm.trimReqMu.Unlock()
+
+	report := TrimReport{Result: plan.result(), Err: err}
+	for _, ch := range requests {
+		ch <- report
+		close(ch)
+	}
+}
+
+// result summarizes the connections closed by the plan.
+func (plan trimPlan) result() TrimResult {
+	res := TrimResult{Closed: len(plan.conns)}
+	seen := make(map[peer.ID]struct{}, len(plan.conns))
+	for _, c := range plan.conns {
+		p := c.RemotePeer()
+		if _, ok := seen[p]; !ok {
+			seen[p] = struct{}{}
+			res.Peers = append(res.Peers, p)
+		}
+	}
+	return res
+}
+
+// afterTrim runs the notifications following a completed trim. It must be called
+// without holding any locks.
+func (cm *PhoreConnMgr) afterTrim(plan trimPlan) {
+	if len(plan.hints) > 0 && cm.dialHints != nil {
+		cm.dialHints(plan.hints)
+	}
+	cm.emitTrimmed(plan)
+	cm.deliverReport(plan.report)
+}
+
+// trimOpts tweak the behaviour of a single trim.
+type trimOpts struct {
+	// ignoreSilence runs the trim even if the silence period is in effect.
+	ignoreSilence bool
+	// ignoreGrace makes peers within their grace period subject to pruning.
+	ignoreGrace bool
+	// surplusOnly only evicts the peers in excess of protocol maximums and reserved
+	// outbound slots, leaving the count above the low watermark alone.
+	surplusOnly bool
+	// rankAll selects every candidate in pruning order, whatever the watermarks and the
+	// close budget, see PrunableCandidates.
+	rankAll bool
+
+	// wait for the trim in progress to complete instead of giving up.
+	wait bool
+}
+
+// trim runs a single trim and returns its plan. It returns ErrTrimInProgress or
+// ErrSilencePeriod without doing anything if another trim is in progress (unless told
+// to wait for it), or if the silence period is in effect, and context.Canceled once the
+// connection manager is closed. If ctx is done before all the selected connections are
+// closed, the trim stops, and returns the error of ctx with the plan cut down to the
+// connections closed so far. The trim runs labelled op=trim for profiles. The
+// notifications of afterTrim are run before returning, and Close waits for them.
+func (cm *PhoreConnMgr) trim(ctx context.Context, opts trimOpts) (plan trimPlan, err error) {
+	if !cm.enter() {
+		return trimPlan{}, cm.ctx.Err()
+	}
+	defer cm.running.Done()
+
+	withLabels(ctx, "trim", func(ctx context.Context) {
+		plan, err = cm.runTrim(ctx, opts)
+	})
+	cm.afterTrim(plan)
+	return plan, err
+}
+
+// runTrim implements trim. The caller must have entered.
+func (cm *PhoreConnMgr) runTrim(ctx context.Context, opts trimOpts) (trimPlan, error) {
+	if opts.wait {
+		select {
+		case cm.trimRunningCh <- struct{}{}:
+		case <-ctx.Done():
+			return trimPlan{}, ctx.Err()
+		}
+	} else {
+		select {
+		case cm.trimRunningCh <- struct{}{}:
+		default:
+			return trimPlan{}, ErrTrimInProgress
+		}
 	}
 	defer func() { <-cm.trimRunningCh }()
-	if time.Since(cm.lastTrim) < cm.silencePeriod {
+	if _, silence := cm.timing(); !opts.ignoreSilence && cm.clock.Now().Sub(cm.getLastTrim()) < silence {
 		// skip this attempt to trim as the last one just took place.
-		return
+		return trimPlan{}, ErrSilencePeriod
+	}
+
+	if err := ctx.Err(); err != nil {
+		return trimPlan{}, err
 	}
 
 	defer log.EventBegin(ctx, "connCleanup").Done()
-	for _, c := range cm.getConnsToClose(ctx) {
-		log.Info("closing conn: ", c.RemotePeer())
+	ctx, span := cm.startTrimSpan(ctx)
+	defer span.End()
+	cm.expireAllTags()
+	cm.expireCooldowns()
+	plan := cm.measuredPlanTrim(ctx, opts)
+	for _, p := range plan.expired {
+		cm.pruneTempEntry(p)
+	}
+	plan.conns = cm.intercept(plan.conns)
+	cm.explainSelection(&plan)
+	traceSelection(span, plan)
+	closeStart := time.Now()
+	var err error
+	for i, c := range plan.conns {
+		if err = ctx.Err(); err != nil {
+			log.Warningf("trim interrupted after closing %d of %d connections: %s", i, len(plan.conns), err)
+			traceInterrupted(span, i, err)
+			plan.conns = plan.conns[:i]
+			break
+		}
+		p := plan.selected[c.RemotePeer()]
+		log.Infof("closing conn of %s (%s): value %d, score %.2f, top tags %s",
+			c.RemotePeer(), plan.reasons[c], p.Value, p.Score, formatTopTags(plan.topTags[c.RemotePeer()]))
 		log.Event(ctx, "closeConn", c.RemotePeer())
+		traceClose(span, c, plan.reasons[c])
+		cm.auditPruned(plan, c)
 		c.Close()
 	}
+	plan.cost.Close = Duration(time.Since(closeStart))
+	if err != nil && len(plan.conns) == 0 {
+		return trimPlan{}, err
+	}
+	if err == nil {
+		// the peers left connected by an interrupted trim did not survive it.
+		for _, p := range plan.survivors {
+			cm.recordSurvival(p)
+		}
+	}
+	cm.recordPruned(plan.conns)
+	cm.notifyPruned(plan)
+
+	cm.lastTrimMu.Lock()
+	cm.lastTrim = cm.clock.Now()
+	cm.lastTrimClosed = len(plan.conns)
+	cm.trims++
+	cm.closedTotal += len(plan.conns)
+	cm.lastTrimCost = plan.cost
+	cm.totalTrimCost.add(plan.cost)
+	plan.report = newTrimDetails(plan, cm.lastTrim, err)
+	cm.lastReport = plan.report
+	cm.lastTrimMu.Unlock()
+	return plan, err
+}
 
-	cm.lastTrim = time.Now()
+// recordSurvival counts a trim survived by the peer.
+func (cm *PhoreConnMgr) recordSurvival(p peer.ID) {
+	s := cm.segments.get(p)
+	s.Lock()
+	defer s.Unlock()
+
+	if pi, ok := s.peers[p]; ok {
+		pi.survived++
+	}
+}
+
+// getLastTrim returns the time at which the last trim finished.
+func (cm *PhoreConnMgr) getLastTrim() time.Time {
+	cm.lastTrimMu.RLock()
+	defer cm.lastTrimMu.RUnlock()
+
+	return cm.lastTrim
+}
+
+// trimStats returns the number of trims performed so far, the number of connections
+// closed by the last one, and by all of them.
+func (cm *PhoreConnMgr) trimStats() (trims, lastClosed, closed int) {
+	cm.lastTrimMu.RLock()
+	defer cm.lastTrimMu.RUnlock()
+
+	return cm.trims, cm.lastTrimClosed, cm.closedTotal
+}
+
+// ConnCounts returns the number of inbound, outbound and all tracked connections, from
+// the direction their Stat reported when they were opened. Connections of unknown
+// direction only count toward the total.
+func (cm *PhoreConnMgr) ConnCounts() (inbound, outbound, total int) {
+	inbound, outbound, total, _ = cm.directionCounts()
+	return inbound, outbound, total
+}
+
+// directionCounts returns the number of inbound, outbound and all connections, and the
+// number of peers tracked, including peers tagged before connecting.
+func (cm *PhoreConnMgr) directionCounts() (inbound, outbound, conns, peers int) {
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+		peers += len(s.peers)
+		for _, inf := range s.peers {
+			conns += len(inf.conns)
+			for _, ci := range inf.conns {
+				switch ci.dir {
+				case network.DirInbound:
+					inbound++
+				case network.DirOutbound:
+					outbound++
+				}
+			}
+		}
+		s.Unlock()
+	}
+	return inbound, outbound, conns, peers
+}
+
+// protectedCount returns the number of peers protected under at least one tag.
+func (cm *PhoreConnMgr) protectedCount() int {
+	cm.plk.RLock()
+	defer cm.plk.RUnlock()
+
+	return len(cm.protected)
 }
 
 func (cm *PhoreConnMgr) background() {
-	ticker := time.NewTicker(time.Minute)
+	defer cm.running.Done()
+	defer atomic.StoreInt32(&cm.backgroundRunning, 0)
+	ticker := cm.clock.NewTicker(cm.trimInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			if atomic.LoadInt32(&cm.connCount) > int32(cm.highWater) {
+		case <-ticker.C():
+			cm.refreshFDWatermarks()
+			cm.checkMemory()
+			cm.checkFDs()
+			cm.expireAllTags()
+			cm.expireCooldowns()
+			cm.expireRetained()
+			cm.expireBans()
+			cm.maybeSaveReputations()
+			cm.refreshProtocolGauges()
+			low, hi := cm.watermarks()
+			cm.emitWatermarkExceeded(hi)
+			cm.trackOverload(hi)
+			cm.checkNeedPeers()
+			if cm.overCriticalWater() || cm.fdsExhausted() {
+				if atomic.CompareAndSwapInt32(&cm.emergencyPending, 0, 1) {
+					cm.emergencyTrim()
+				}
+			} else if cm.overHighWater(hi) || cm.underMemoryPressure() && cm.count() > low {
 				cm.TrimOpenConns(cm.ctx)
+			} else if cm.evictsBelowWatermarks() {
+				cm.trim(cm.ctx, trimOpts{surplusOnly: true})
 			}
 
 		case <-cm.ctx.Done():
@@ -214,109 +1021,374 @@ func (cm *PhoreConnMgr) background() {
 	}
 }
 
+// trimPlan is the outcome of running the trim heuristics.
+type trimPlan struct {
+	conns     []network.Conn
+	reasons   map[network.Conn]CloseReason // why each connection was selected
+	selected  map[peer.ID]PeerSnapshot     // snapshots of the peers of the connections
+	hints     []DialHint
+	expired   []peer.ID // temporary entries to prune
+	survivors []peer.ID // candidates left connected, see WithSurvivorBonus
+
+	considered int // peers considered for pruning
+	cost       TrimCost
+	topTags    map[peer.ID][]TagContribution // of the peers closed, see explainSelection
+
+	report *TrimDetails // set once the trim completed
+}
+
+// overHighWater reports whether the background loop should trim because the count
+// exceeds the high watermark, taking into account the hysteresis configured through
+// WithTrimHysteresis. It must only be called from the background goroutine.
+func (cm *PhoreConnMgr) overHighWater(hi int) bool {
+	count := cm.count()
+	if count <= hi {
+		cm.overHighSince = time.Time{}
+		return false
+	}
+
+	now := cm.clock.Now()
+	if cm.overHighSince.IsZero() {
+		cm.overHighSince = now
+	}
+	if cm.hysteresisDelay <= 0 && cm.hysteresisMargin <= 0 {
+		return true
+	}
+	if cm.hysteresisMargin > 0 && count > hi+cm.hysteresisMargin {
+		return true
+	}
+	return cm.hysteresisDelay > 0 && now.Sub(cm.overHighSince) >= cm.hysteresisDelay
+}
+
+// hasProtocolMaximums reports whether maximums are configured for some protocols.
+func (cm *PhoreConnMgr) hasProtocolMaximums() bool {
+	cm.plk.RLock()
+	defer cm.plk.RUnlock()
+	return len(cm.maximumPeersForProtocol) > 0
+}
+
+// evictsBelowWatermarks reports whether trims may evict peers while the count is below
+// the watermarks, to enforce protocol maximums or reserved outbound slots. In that case
+// every background tick runs a trim.
+func (cm *PhoreConnMgr) evictsBelowWatermarks() bool {
+	return cm.reservedOutbound > 0 || cm.hasProtocolMaximums()
+}
+
+// overCriticalWater reports whether the connection count exceeds the critical
+// watermark set through WithCriticalWater.
+func (cm *PhoreConnMgr) overCriticalWater() bool {
+	return cm.criticalWater > 0 && cm.count() > cm.criticalWater || cm.overloaded()
+}
+
+// overloaded reports whether the count exceeds the high watermark by the overload
+// ratio set through WithOverloadTrim.
+func (cm *PhoreConnMgr) overloaded() bool {
+	if cm.overloadRatio <= 0 {
+		return false
+	}
+	_, hi := cm.watermarks()
+	return hi > 0 && float64(cm.count()) > cm.overloadRatio*float64(hi)
+}
+
+// count returns the number of connections or connected peers, depending on the
+// configured WatermarkBasis. Allowlisted connections and peers do not count.
+func (cm *PhoreConnMgr) count() int {
+	if cm.basis == PeerBasis {
+		return int(atomic.LoadInt32(&cm.peerCount) - atomic.LoadInt32(&cm.allowedPeerCount))
+	}
+	return int(atomic.LoadInt32(&cm.connCount) - atomic.LoadInt32(&cm.allowedConnCount))
+}
+
+// emergencyTrim trims down to the low watermark right away, ignoring the silence and
+// grace periods. Callers must have set emergencyPending, so that a single emergency
+// trim is scheduled at a time.
+func (cm *PhoreConnMgr) emergencyTrim() {
+	defer atomic.StoreInt32(&cm.emergencyPending, 0)
+
+	log.Warning("connection count above critical watermark or overload threshold, or file descriptors running out, trimming aggressively")
+	// overloads only bypass the grace period if configured to.
+	ignoreGrace := cm.criticalWater > 0 && cm.count() > cm.criticalWater || cm.overloadIgnoresGrace || cm.fdsExhausted()
+	cm.trim(cm.ctx, trimOpts{ignoreSilence: true, ignoreGrace: ignoreGrace})
+}
+
 // getConnsToClose runs the heuristics described in TrimOpenConns and returns the
 // connections to close.
 func (cm *PhoreConnMgr) getConnsToClose(ctx context.Context) []network.Conn {
-	if cm.lowWater == 0 || cm.highWater == 0 {
+	return cm.planTrim(ctx, trimOpts{}).conns
+}
+
+// planTrim runs the heuristics described in TrimOpenConns and returns the connections
+// to close, along with any dial hints for protocols left short of good peers.
+func (cm *PhoreConnMgr) planTrim(ctx context.Context, opts trimOpts) trimPlan {
+	low, hi := cm.watermarks()
+	if low == 0 || hi == 0 {
 		// disabled
-		return nil
+		return trimPlan{}
+	}
+	grace, _ := cm.timing()
+	if opts.ignoreGrace {
+		grace = 0
 	}
-	now := time.Now()
-	nconns := int(atomic.LoadInt32(&cm.connCount))
-	if nconns <= cm.lowWater {
+	now := cm.clock.Now()
+	ncount := cm.count()
+	capped := cm.hasProtocolMaximums()
+	if ncount <= low && !cm.evictsBelowWatermarks() && !opts.rankAll {
 		log.Info("open connection count below limit")
-		return nil
+		return trimPlan{}
 	}
 
 	npeers := cm.segments.countPeers()
-	candidates := make([]*peerInfo, 0, npeers)
+	candidates := make([]PeerSnapshot, 0, npeers)
+
+	// peers supporting a protocol with a configured minimum compete for the reserved
+	// slots, everyone else goes straight to the candidate list.
+	var contenders, protectedContenders []protocolContender
 
-	numPeersForProto := make(map[protocol.ID]int)
+	// peers supporting a protocol with a configured maximum, and how many connected
+	// peers support each of those protocols.
+	var (
+		cappedProtos map[peer.ID][]protocol.ID
+		cappedCounts map[protocol.ID]int
+	)
 
 	cm.plk.RLock()
-	for _, s := range cm.segments {
+	if capped {
+		cappedProtos = make(map[peer.ID][]protocol.ID)
+		cappedCounts = make(map[protocol.ID]int)
+	}
+	inbound := 0
+	for _, s := range cm.segments.buckets {
 		s.Lock()
-		next_peer_loop:
 		for id, inf := range s.peers {
-			if _, ok := cm.protected[id]; ok {
-				// skip over protected peer.
-				continue
+			if inf.allowed == 0 {
+				inbound += inf.inboundCount(cm.basis)
 			}
-
-			peerSupportedProtos, err := cm.peerstore.GetProtocols(id)
-			if err != nil {
-				candidates = append(candidates, inf)
-				continue next_peer_loop
+			if capped && len(inf.conns) > 0 {
+				if protos := cm.cappedProtocolsOf(id); len(protos) > 0 {
+					cappedProtos[id] = protos
+					for _, p := range protos {
+						cappedCounts[p]++
+					}
+				}
 			}
 
-			for _, supportedProto := range peerSupportedProtos {
-				supportedID := protocol.ID(supportedProto)
-				_, found := numPeersForProto[supportedID]
-				if !found {
-					numPeersForProto[supportedID] = 1
-				} else {
-					numPeersForProto[supportedID]++
-				}
-				minPeers, found := cm.minimumPeersForProtocol[supportedID]
-				if !found || minPeers <= 0 {
-					continue
+			if _, ok := cm.protected[id]; ok || inf.allowed > 0 || cm.protectedByProtocol(id) || cm.protectedAddrs.matchesAny(inf) {
+				// protected peers are never pruned, but they still count toward the
+				// protocol minimums.
+				if cm.countsTowardMinimums(inf, now, grace) {
+					if protos := cm.minimumProtocolsOf(id); len(protos) > 0 {
+						protectedContenders = append(protectedContenders, protocolContender{peer: inf.snapshot(), protos: protos})
+					}
 				}
+				continue
+			}
 
-				if numPeersForProto[supportedID] <= minPeers {
-					// if we don't have enough enough peers for this yet, don't allow deletion
-					continue next_peer_loop
-				}
+			if !cm.countsTowardMinimums(inf, now, grace) {
+				candidates = append(candidates, cm.snapshotPeer(inf, now))
+				continue
 			}
 
-			candidates = append(candidates, inf)
+			protos := cm.minimumProtocolsOf(id)
+			if len(protos) == 0 {
+				candidates = append(candidates, cm.snapshotPeer(inf, now))
+				continue
+			}
+			contenders = append(contenders, protocolContender{peer: cm.snapshotPeer(inf, now), protos: protos})
 		}
 		s.Unlock()
 	}
-	cm.plk.RUnlock()
+	candidates, hints := cm.reserveForProtocols(protectedContenders, contenders, candidates)
 
-	// Sort peers according to their value.
-	sort.Slice(candidates, func(i, j int) bool {
-		left, right := candidates[i], candidates[j]
-		// temporary peers are preferred for pruning.
-		if left.temp != right.temp {
-			return left.temp
+	snapshot := TrimSnapshot{
+		Now:        now,
+		Basis:      cm.basis,
+		Target:     ncount - low,
+		Candidates: candidates[:0],
+	}
+	if opts.surplusOnly {
+		snapshot.Target = 0
+	}
+	if opts.rankAll {
+		snapshot.Target = ncount
+	}
+	var expired []peer.ID
+	for _, p := range candidates {
+		if !cm.graceFilter(&p, now, grace) {
+			continue
 		}
-		// otherwise, compare by value.
-		return left.value < right.value
-	})
+		if p.Temp {
+			// temporary entries for early tags that have gone past the grace period and
+			// still hold no connections are pruned.
+			expired = append(expired, p.ID)
+			continue
+		}
+		snapshot.Candidates = append(snapshot.Candidates, p)
+	}
+	considered := make(map[peer.ID]PeerSnapshot, len(snapshot.Candidates))
+	for _, p := range snapshot.Candidates {
+		considered[p.ID] = p
+	}
 
-	target := nconns - cm.lowWater
+	// remember who is subject to pruning, to credit the survivors.
+	var eligible []peer.ID
+	if cm.survivorBonus != 0 {
+		for _, p := range snapshot.Candidates {
+			eligible = append(eligible, p.ID)
+		}
+	}
 
-	// slightly overallocate because we may have more than one conns per peer
-	selected := make([]network.Conn, 0, target+10)
+	// the surplus of protocols over their maximum, and of inbound connections over the
+	// slots left to them, is evicted whatever the watermarks.
+	var selected []network.Conn
+	reasons := make(map[network.Conn]CloseReason)
+	evict := func(evicted []PeerSnapshot, reason CloseReason) {
+		for _, p := range evicted {
+			for _, c := range p.Conns {
+				selected = append(selected, c.Conn)
+				reasons[c.Conn] = reason
+			}
+			snapshot.Target -= snapshot.Count(p)
+			if p.Direction() == network.DirInbound {
+				inbound -= snapshot.Count(p)
+			}
+		}
+	}
+	if capped {
+		var evicted []PeerSnapshot
+		evicted, snapshot.Candidates = cm.evictOverMaximums(snapshot.Candidates, cappedProtos, cappedCounts)
+		evict(evicted, ReasonProtocolMaximum)
+	}
+	cm.plk.RUnlock()
+	if cm.reservedOutbound > 0 {
+		if excess := inbound - (hi - cm.reservedOutbound); excess > 0 {
+			var evicted []PeerSnapshot
+			evicted, snapshot.Candidates = evictInbound(snapshot, excess)
+			evict(evicted, ReasonInboundSurplus)
+		}
+	}
 
-	for _, inf := range candidates {
-		if target <= 0 {
-			break
+	if snapshot.Target > 0 {
+		policy := cm.policy
+		if policy == nil {
+			policy = builtinPolicy{cm: cm, reasons: reasons}
 		}
-		// TODO: should we be using firstSeen or the time associated with the connection itself?
-		if inf.firstSeen.Add(cm.gracePeriod).After(now) {
-			continue
+		for _, c := range policy.SelectConnsToClose(snapshot) {
+			if _, ok := reasons[c]; !ok {
+				reasons[c] = ReasonPolicy
+			}
+			selected = append(selected, c)
 		}
+	}
+	if !opts.rankAll {
+		selected = cm.withinBudget(selected)
+	}
 
-		// lock this to protect from concurrent modifications from connect/disconnect events
-		s := cm.segments.get(inf.id)
-		s.Lock()
+	chosen := make(map[peer.ID]PeerSnapshot)
+	for _, c := range selected {
+		chosen[c.RemotePeer()] = considered[c.RemotePeer()]
+	}
 
-		if len(inf.conns) == 0 && inf.temp {
-			// handle temporary entries for early tags -- this entry has gone past the grace period
-			// and still holds no connections, so prune it.
-			delete(s.peers, inf.id)
-		} else {
-			for c := range inf.conns {
-				selected = append(selected, c)
+	var survivors []peer.ID
+	if cm.survivorBonus != 0 && len(selected) > 0 {
+		closing := make(map[peer.ID]struct{})
+		for _, c := range selected {
+			closing[c.RemotePeer()] = struct{}{}
+		}
+		for _, p := range eligible {
+			if _, ok := closing[p]; !ok {
+				survivors = append(survivors, p)
 			}
 		}
-		target -= len(inf.conns)
-		s.Unlock()
 	}
 
-	return selected
+	return trimPlan{conns: selected, reasons: reasons, selected: chosen, hints: hints, expired: expired, survivors: survivors, considered: len(considered)}
+}
+
+// graceFilter applies the grace period to a candidate, and reports whether it is still
+// subject to pruning. Depending on the configured GraceBasis, the grace period starts
+// when we began tracking the peer, or when each connection was opened; in the latter
+// case, the connections still in their grace period are removed from the candidate.
+// Peers reconnecting during their cooldown, and peers carrying a tag registered
+// through WithGraceExemptTags, get no grace period.
+func (cm *PhoreConnMgr) graceFilter(p *PeerSnapshot, now time.Time, grace time.Duration) bool {
+	if p.Cooldown {
+		return true
+	}
+	for _, t := range cm.graceExemptTags {
+		if _, ok := p.Tags[t]; ok {
+			return true
+		}
+	}
+
+	if cm.graceBasis != GracePerConnection || p.Temp {
+		return !p.FirstSeen.Add(grace).After(now)
+	}
+
+	eligible := make([]ConnSnapshot, 0, len(p.Conns))
+	for _, c := range p.Conns {
+		if !c.Opened.Add(grace).After(now) {
+			eligible = append(eligible, c)
+		}
+	}
+	if len(eligible) == 0 || (cm.basis == PeerBasis && len(eligible) < len(p.Conns)) {
+		// with PeerBasis, only the peers that can be disconnected altogether count.
+		return false
+	}
+	p.Conns = eligible
+	return true
+}
+
+// withinBudget truncates conns to the budget set through WithMaxClosesPerTrim. The
+// connections of a peer are kept together, with the exception of a first peer holding
+// more connections than the budget allows.
+func (cm *PhoreConnMgr) withinBudget(conns []network.Conn) []network.Conn {
+	if cm.maxClosesPerTrim <= 0 || len(conns) <= cm.maxClosesPerTrim {
+		return conns
+	}
+
+	end := 0
+	for end < len(conns) {
+		next := end + 1
+		for next < len(conns) && conns[next].RemotePeer() == conns[end].RemotePeer() {
+			next++
+		}
+		if end > 0 && next > cm.maxClosesPerTrim {
+			// out of budget, the rest is left to the next trim.
+			break
+		}
+		end = next
+	}
+	return conns[:end]
+}
+
+// pruneTempEntry drops the temporary entry of p, unless it got connected in the
+// meantime.
+func (cm *PhoreConnMgr) pruneTempEntry(p peer.ID) {
+	s := cm.segments.get(p)
+	s.Lock()
+	defer s.Unlock()
+
+	if pi, ok := s.peers[p]; ok && pi.temp && len(pi.conns) == 0 {
+		delete(s.peers, p)
+		atomic.AddInt32(&cm.tempCount, -1)
+	}
+}
+
+// countsTowardMinimums reports whether the given peer may be counted toward (and
+// therefore reserved for) the minimums in minimumPeersForProtocol, according to the
+// configured ProtocolAccounting.
+func (cm *PhoreConnMgr) countsTowardMinimums(inf *peerInfo, now time.Time, grace time.Duration) bool {
+	if cm.transientHandling&ExcludeTransientFromMinimums != 0 && inf.onlyTransient() {
+		return false
+	}
+	switch cm.protocolAccounting {
+	case CountAllTracked:
+		return true
+	case CountEstablished:
+		return len(inf.conns) > 0 && !inf.firstSeen.Add(grace).After(now)
+	default:
+		return len(inf.conns) > 0
+	}
 }
 
 // GetTagInfo is called to fetch the tag information associated with a given
@@ -330,6 +1402,13 @@ func (cm *PhoreConnMgr) GetTagInfo(p peer.ID) *connmgr.TagInfo {
 	if !ok {
 		return nil
 	}
+	return cm.tagInfo(pi, cm.clock.Now())
+}
+
+// tagInfo returns the TagInfo of pi, after expiring its tags. The segment of the peer
+// must be locked.
+func (cm *PhoreConnMgr) tagInfo(pi *peerInfo, now time.Time) *connmgr.TagInfo {
+	cm.expireTags(pi, now)
 
 	out := &connmgr.TagInfo{
 		FirstSeen: pi.firstSeen,
@@ -341,8 +1420,8 @@ func (cm *PhoreConnMgr) GetTagInfo(p peer.ID) *connmgr.TagInfo {
 	for t, v := range pi.tags {
 		out.Tags[t] = v
 	}
-	for c, t := range pi.conns {
-		out.Conns[c.RemoteMultiaddr().String()] = t
+	for c, ci := range pi.conns {
+		out.Conns[c.RemoteMultiaddr().String()] = ci.opened
 	}
 
 	return out
@@ -354,11 +1433,76 @@ func (cm *PhoreConnMgr) TagPeer(p peer.ID, tag string, val int) {
 	s.Lock()
 	defer s.Unlock()
 
-	pi := s.tagInfoFor(p)
+	pi := cm.tagInfoFor(s, p)
+	if pi == nil {
+		log.Debug("temporary entry limit reached, dropping tag for untracked peer: ", p)
+		return
+	}
+
+	now := cm.clock.Now()
+	cm.expireTags(pi, now)
+	delete(pi.expiry, tag)
+	cm.setTag(pi, tag, val, now)
+}
+
+// TagPeerWithTTL is like TagPeer, but the tag is removed once ttl has elapsed, unless it
+// is set again in the meantime. Temporary boosts thus disappear without a call to
+// UntagPeer. UpsertTag updates the value of such a tag but leaves its expiry alone.
+func (cm *PhoreConnMgr) TagPeerWithTTL(p peer.ID, tag string, val int, ttl time.Duration) {
+	s := cm.segments.get(p)
+	s.Lock()
+	defer s.Unlock()
 
-	// Update the total value of the peer.
-	pi.value += val - pi.tags[tag]
+	pi := cm.tagInfoFor(s, p)
+	if pi == nil {
+		log.Debug("temporary entry limit reached, dropping tag for untracked peer: ", p)
+		return
+	}
+
+	now := cm.clock.Now()
+	cm.expireTags(pi, now)
+	cm.setTag(pi, tag, val, now)
+	if pi.expiry == nil {
+		pi.expiry = make(map[string]time.Time)
+	}
+	pi.expiry[tag] = now.Add(ttl)
+}
+
+// setTag sets the value of a tag and updates the total value of the peer. The segment
+// of the peer must be locked.
+func (cm *PhoreConnMgr) setTag(pi *peerInfo, tag string, val int, now time.Time) {
+	val = cm.clampTag(tag, val)
+	cm.auditTag(AuditTagged, pi.id, tag, pi.tags[tag], val, now)
+	pi.value += cm.weighTag(tag, val) - cm.weighTag(tag, pi.tags[tag])
 	pi.tags[tag] = val
+	pi.lastTagged = now
+}
+
+// expireTags removes the tags of the peer whose TTL has elapsed. The segment of the
+// peer must be locked.
+func (cm *PhoreConnMgr) expireTags(pi *peerInfo, now time.Time) {
+	for tag, at := range pi.expiry {
+		if at.After(now) {
+			continue
+		}
+		pi.value -= cm.weighTag(tag, pi.tags[tag])
+		delete(pi.tags, tag)
+		delete(pi.expiry, tag)
+	}
+}
+
+// expireAllTags removes the tags of all peers whose TTL has elapsed.
+func (cm *PhoreConnMgr) expireAllTags() {
+	now := cm.clock.Now()
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+		for _, pi := range s.peers {
+			if len(pi.expiry) > 0 {
+				cm.expireTags(pi, now)
+			}
+		}
+		s.Unlock()
+	}
 }
 
 // UntagPeer is called to disassociate a string and integer from a given peer.
@@ -373,9 +1517,19 @@ func (cm *PhoreConnMgr) UntagPeer(p peer.ID, tag string) {
 		return
 	}
 
-	// Update the total value of the peer.
-	pi.value -= pi.tags[tag]
+	now := cm.clock.Now()
+	cm.expireTags(pi, now)
+	cm.removeTag(pi, tag, now)
+}
+
+// removeTag removes tag from the peer, updating its total value. The segment of the
+// peer must be locked.
+func (cm *PhoreConnMgr) removeTag(pi *peerInfo, tag string, now time.Time) {
+	cm.auditTag(AuditUntagged, pi.id, tag, pi.tags[tag], 0, now)
+	pi.value -= cm.weighTag(tag, pi.tags[tag])
 	delete(pi.tags, tag)
+	delete(pi.expiry, tag)
+	pi.lastTagged = now
 }
 
 // UpsertTag is called to insert/update a peer tag
@@ -384,12 +1538,15 @@ func (cm *PhoreConnMgr) UpsertTag(p peer.ID, tag string, upsert func(int) int) {
 	s.Lock()
 	defer s.Unlock()
 
-	pi := s.tagInfoFor(p)
+	pi := cm.tagInfoFor(s, p)
+	if pi == nil {
+		log.Debug("temporary entry limit reached, dropping tag for untracked peer: ", p)
+		return
+	}
 
-	oldval := pi.tags[tag]
-	newval := upsert(oldval)
-	pi.value += newval - oldval
-	pi.tags[tag] = newval
+	now := cm.clock.Now()
+	cm.expireTags(pi, now)
+	cm.setTag(pi, tag, upsert(pi.tags[tag]), now)
 }
 
 // CMInfo holds the configuration for PhoreConnMgr, as well as status data.
@@ -409,18 +1566,73 @@ type CMInfo struct {
 	// The current connection count.
 	ConnCount int
 
+	// The current inbound and outbound connection counts. Connections of unknown
+	// direction count toward neither.
+	InboundConns  int
+	OutboundConns int
+
+	// The number of peers tracked, including peers tagged but not connected.
+	TrackedPeers int
+
+	// The number of peers protected under at least one tag.
+	ProtectedPeers int
+
+	// The number of trims performed since the connection manager was created.
+	TotalTrims int
+
+	// The number of connections closed by the last trim.
+	LastTrimClosed int
+
+	// The number of connections closed by all trims since the connection manager was
+	// created.
+	TotalClosed int
+
 	// The minimum number of peers to maintain per protocol
 	PerProtocolMinimum map[protocol.ID]string
+
+	// The number of peers counted toward each protocol minimum, next to the minimum.
+	ProtocolCounts map[protocol.ID]ProtocolCount
+
+	// How often peers connected and disconnected recently.
+	Churn ChurnStats
+
+	// The number of connected peers supporting each protocol configured with
+	// WithProtocolGauges.
+	ProtocolPeers map[protocol.ID]int
+
+	// The work done by the last trim, and by all trims since the connection manager
+	// was created.
+	LastTrimCost  TrimCost
+	TotalTrimCost TrimCost
 }
 
 // GetInfo returns the configuration and status data for this connection manager.
 func (cm *PhoreConnMgr) GetInfo() CMInfo {
+	low, hi := cm.watermarks()
+	grace, _ := cm.timing()
+	inbound, outbound, _, peers := cm.directionCounts()
+	trims, lastClosed, closed := cm.trimStats()
+	lastCost, totalCost := cm.trimCosts()
 	return CMInfo{
-		HighWater:   cm.highWater,
-		LowWater:    cm.lowWater,
-		LastTrim:    cm.lastTrim,
-		GracePeriod: cm.gracePeriod,
+		HighWater:   hi,
+		LowWater:    low,
+		LastTrim:    cm.getLastTrim(),
+		GracePeriod: grace,
 		ConnCount:   int(atomic.LoadInt32(&cm.connCount)),
+
+		InboundConns:   inbound,
+		OutboundConns:  outbound,
+		TrackedPeers:   peers,
+		ProtectedPeers: cm.protectedCount(),
+		TotalTrims:     trims,
+		LastTrimClosed: lastClosed,
+		TotalClosed:    closed,
+
+		ProtocolCounts: cm.protocolCounts(),
+		Churn:          cm.Churn(),
+		ProtocolPeers:  cm.ProtocolPeers(),
+		LastTrimCost:   lastCost,
+		TotalTrimCost:  totalCost,
 	}
 }
 
@@ -441,68 +1653,141 @@ func (nn *cmNotifee) cm() *PhoreConnMgr {
 // The notifee updates the PhoreConnMgr to start tracking the connection. If the new connection
 // count exceeds the high watermark, a trim may be triggered.
 func (nn *cmNotifee) Connected(n network.Network, c network.Conn) {
+	nn.connected(c, false)
+}
+
+// connected implements Connected. When dedupe is set, notifications for conns that are
+// already tracked are dropped silently.
+func (nn *cmNotifee) connected(c network.Conn, dedupe bool) {
 	cm := nn.cm()
 
 	p := c.RemotePeer()
 	s := cm.segments.get(p)
+	// run once the segment is unlocked.
+	defer cm.updateProtocolGauges(p)
 	s.Lock()
 	defer s.Unlock()
 
+	// checked under the segment lock, so a concurrent BanPeer either sees the conn
+	// tracked and closes it, or is seen here.
+	if cm.IsBanned(p) {
+		log.Info("closing conn of banned peer: ", p)
+		go c.Close()
+		return
+	}
 
-
-	id := c.RemotePeer()
-	pinfo, ok := s.peers[id]
+	now := cm.clock.Now()
+	pinfo, ok := s.peers[p]
 	if !ok {
 		pinfo = &peerInfo{
-			id:        id,
-			firstSeen: time.Now(),
+			id:        p,
+			firstSeen: now,
 			tags:      make(map[string]int),
-			conns:     make(map[network.Conn]time.Time),
+			conns:     make(map[network.Conn]*connInfo),
 		}
-		s.peers[id] = pinfo
+		s.peers[p] = pinfo
+		cm.restoreTags(pinfo, now)
+		cm.restoreReputation(pinfo)
 	} else if pinfo.temp {
 		// we had created a temporary entry for this peer to buffer early tags before the
 		// Connected notification arrived: flip the temporary flag, and update the firstSeen
 		// timestamp to the real one.
 		pinfo.temp = false
-		pinfo.firstSeen = time.Now()
+		pinfo.firstSeen = now
+		atomic.AddInt32(&cm.tempCount, -1)
+		cm.restoreTags(pinfo, now)
+		cm.restoreReputation(pinfo)
 	}
 
 	_, ok = pinfo.conns[c]
 	if ok {
-		log.Error("received connected notification for conn we are already tracking: ", p)
+		if !dedupe {
+			log.Error("received connected notification for conn we are already tracking: ", p)
+		}
 		return
 	}
 
-	pinfo.conns[c] = time.Now()
+	if len(pinfo.conns) == 0 {
+		pinfo.connectedAt = now
+		atomic.AddInt32(&cm.peerCount, 1)
+		cm.churn.connected(now)
+	}
+	ci := &connInfo{
+		opened:    now,
+		dir:       c.Stat().Direction,
+		relayed:   isRelayed(c.RemoteMultiaddr()),
+		transient: cm.isTransient != nil && cm.isTransient(c),
+		allowed:   cm.allowlisted(c),
+	}
+	pinfo.conns[c] = ci
+	pinfo.lastSeen = now
+	if ci.allowed {
+		cm.trackAllowed(pinfo)
+	}
+	cm.trackIP(c.RemoteMultiaddr())
 	atomic.AddInt32(&cm.connCount, 1)
+	cm.auditConn(AuditConnected, c, now)
+
+	if cm.overCriticalWater() && atomic.CompareAndSwapInt32(&cm.emergencyPending, 0, 1) {
+		cm.goLabelled("emergency-trim", func(context.Context) { cm.emergencyTrim() })
+	}
+	cm.rearmLowPeers()
 }
 
 // Disconnected is called by notifiers to inform that an existing connection has been closed or terminated.
 // The notifee updates the PhoreConnMgr accordingly to stop tracking the connection, and performs housekeeping.
 func (nn *cmNotifee) Disconnected(n network.Network, c network.Conn) {
+	nn.disconnected(c, false)
+}
+
+// disconnected implements Disconnected. When dedupe is set, notifications for conns
+// that are not tracked are dropped silently.
+func (nn *cmNotifee) disconnected(c network.Conn, dedupe bool) {
 	cm := nn.cm()
 
 	p := c.RemotePeer()
 	s := cm.segments.get(p)
+	// run once the segment is unlocked.
+	defer cm.updateProtocolGauges(p)
+	defer cm.checkNeedPeers()
+	defer cm.checkLowPeers()
 	s.Lock()
 	defer s.Unlock()
 
 	cinf, ok := s.peers[p]
 	if !ok {
-		log.Error("received disconnected notification for peer we are not tracking: ", p)
+		// the connections of banned peers are closed without being tracked.
+		if !dedupe && !cm.IsBanned(p) {
+			log.Error("received disconnected notification for peer we are not tracking: ", p)
+		}
 		return
 	}
 
-	_, ok = cinf.conns[c]
+	ci, ok := cinf.conns[c]
 	if !ok {
-		log.Error("received disconnected notification for conn we are not tracking: ", p)
+		if !dedupe {
+			log.Error("received disconnected notification for conn we are not tracking: ", p)
+		}
 		return
 	}
 
+	if ci.allowed {
+		cm.untrackAllowed(cinf)
+	}
+	cm.untrackIP(c.RemoteMultiaddr())
+	cm.auditConn(AuditDisconnected, c, cm.clock.Now())
 	delete(cinf.conns, c)
 	if len(cinf.conns) == 0 {
+		now := cm.clock.Now()
+		cm.retainTags(cinf, now)
+		cm.keepReputation(cinf)
+		cm.recordSession(cinf, now)
+		cm.churn.disconnected(now, now.Sub(cinf.connectedAt))
+		cm.wakePeering()
 		delete(s.peers, p)
+		atomic.AddInt32(&cm.peerCount, -1)
+	} else {
+		cinf.lastSeen = cm.clock.Now()
 	}
 	atomic.AddInt32(&cm.connCount, -1)
 }
@@ -513,8 +1798,44 @@ func (nn *cmNotifee) Listen(n network.Network, addr ma.Multiaddr) {}
 // ListenClose is no-op in this implementation.
 func (nn *cmNotifee) ListenClose(n network.Network, addr ma.Multiaddr) {}
 
-// OpenedStream is no-op in this implementation.
-func (nn *cmNotifee) OpenedStream(network.Network, network.Stream) {}
+// OpenedStream is called by notifiers to inform that a new stream has been opened. The
+// notifee counts the active streams of each connection, and records the activity.
+func (nn *cmNotifee) OpenedStream(n network.Network, st network.Stream) {
+	nn.streamEvent(st, true)
+}
+
+// ClosedStream is called by notifiers to inform that a stream has been closed.
+func (nn *cmNotifee) ClosedStream(n network.Network, st network.Stream) {
+	nn.streamEvent(st, false)
+}
+
+// streamEvent adds st to, or removes it from, the active streams of its connection.
+// Streams are kept in a set so repeated reports of the same stream are harmless.
+func (nn *cmNotifee) streamEvent(st network.Stream, opened bool) {
+	cm := nn.cm()
 
-// ClosedStream is no-op in this implementation.
-func (nn *cmNotifee) ClosedStream(network.Network, network.Stream) {}
+	c := st.Conn()
+	p := c.RemotePeer()
+	s := cm.segments.get(p)
+	s.Lock()
+	defer s.Unlock()
+
+	pinfo, ok := s.peers[p]
+	if !ok {
+		return
+	}
+	cinf, ok := pinfo.conns[c]
+	if !ok {
+		return
+	}
+
+	if opened {
+		if cinf.streams == nil {
+			cinf.streams = make(map[network.Stream]struct{})
+		}
+		cinf.streams[st] = struct{}{}
+	} else {
+		delete(cinf.streams, st)
+	}
+	pinfo.lastStream = cm.clock.Now()
+}
@@ -3,6 +3,7 @@ package connmgr
 import (
 	"context"
 	"github.com/libp2p/go-libp2p-core/protocol"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -21,10 +22,16 @@ type tconn struct {
 	network.Conn
 
 	peer             peer.ID
+	dir              network.Direction
+	addr             ma.Multiaddr
 	closed           bool
 	disconnectNotify func(net network.Network, conn network.Conn)
 }
 
+func (c *tconn) Stat() network.Stat {
+	return network.Stat{Direction: c.dir}
+}
+
 func (c *tconn) Close() error {
 	c.closed = true
 	if c.disconnectNotify != nil {
@@ -38,6 +45,9 @@ func (c *tconn) RemotePeer() peer.ID {
 }
 
 func (c *tconn) RemoteMultiaddr() ma.Multiaddr {
+	if c.addr != nil {
+		return c.addr
+	}
 	addr, err := ma.NewMultiaddr("/ip4/127.0.0.1/udp/1234")
 	if err != nil {
 		panic("cannot create multiaddr")
@@ -45,6 +55,16 @@ func (c *tconn) RemoteMultiaddr() ma.Multiaddr {
 	return addr
 }
 
+type tstream struct {
+	network.Stream
+
+	conn network.Conn
+}
+
+func (s *tstream) Conn() network.Conn {
+	return s.conn
+}
+
 func randConn(t testing.TB, discNotify func(network.Network, network.Conn)) network.Conn {
 	pid := tu.RandPeerIDFatal(t)
 	return &tconn{peer: pid, disconnectNotify: discNotify}
@@ -664,4 +684,471 @@ func TestConnProtocolLogic(t *testing.T) {
 	if numPeers != 80 {
 		t.Fatal("expected 80 connections after trimming as many peers as possible")
 	}
-}
\ No newline at end of file
+}
+
+func TestProtocolMinimumsIgnoreTemporaryEntries(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{
+		"/phore/1.0.0": 5,
+	})
+	not := cm.Notifee()
+
+	// temporary entries advertising the protocol must not take up reservations.
+	for i := 0; i < 5; i++ {
+		id := tu.RandPeerIDFatal(t)
+		cm.TagPeer(id, "early", 1)
+		if err := ps.AddProtocols(id, "/phore/1.0.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var phoreConns []network.Conn
+	for i := 0; i < 10; i++ {
+		rc := randConn(t, nil)
+		phoreConns = append(phoreConns, rc)
+		not.Connected(nil, rc)
+		if err := ps.AddProtocols(rc.RemotePeer(), "/phore/1.0.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		not.Connected(nil, randConn(t, nil))
+	}
+
+	cm.TrimOpenConns(context.Background())
+
+	open := 0
+	for _, c := range phoreConns {
+		if !c.(*tconn).closed {
+			open++
+		}
+	}
+	if open != 5 {
+		t.Fatalf("expected 5 connected peers to be reserved for the protocol, got %d", open)
+	}
+}
+
+func TestSetWatermarks(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{})
+	not := cm.Notifee()
+
+	var conns []network.Conn
+	for i := 0; i < 10; i++ {
+		rc := randConn(t, not.Disconnected)
+		conns = append(conns, rc)
+		not.Connected(nil, rc)
+	}
+
+	cm.TrimOpenConns(context.Background())
+	if cm.GetInfo().ConnCount != 10 {
+		t.Fatal("expected no connections to be trimmed below the original low watermark")
+	}
+
+	if err := cm.SetWatermarks(4, 8); err != nil {
+		t.Fatal(err)
+	}
+	if info := cm.GetInfo(); info.LowWater != 4 || info.HighWater != 8 {
+		t.Fatalf("unexpected watermarks: %d/%d", info.LowWater, info.HighWater)
+	}
+	if err := cm.SetWatermarks(8, 4); err == nil {
+		t.Fatal("expected a low watermark above the high watermark to be rejected")
+	}
+	if err := cm.SetWatermarks(-1, 4); err == nil {
+		t.Fatal("expected a negative watermark to be rejected")
+	}
+	if info := cm.GetInfo(); info.LowWater != 4 || info.HighWater != 8 {
+		t.Fatalf("expected rejected watermarks to leave the current ones, got %d/%d", info.LowWater, info.HighWater)
+	}
+
+	cm.lastTrim = time.Time{}
+	cm.TrimOpenConns(context.Background())
+	if n := cm.GetInfo().ConnCount; n != 4 {
+		t.Fatalf("expected trimming down to the new low watermark, got %d connections", n)
+	}
+}
+
+func TestNewValidatedConnManager(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+
+	invalid := []struct {
+		name      string
+		low, hi   int
+		grace     time.Duration
+		peerstore pstore.Peerstore
+		protos    map[protocol.ID]int
+	}{
+		{"low above high", 20, 10, 0, ps, nil},
+		{"negative low", -1, 10, 0, ps, nil},
+		{"negative grace", 1, 10, -time.Second, ps, nil},
+		{"nil peerstore", 1, 10, 0, nil, nil},
+		{"negative minimum", 1, 10, 0, ps, map[protocol.ID]int{"/phore/1.0.0": -1}},
+	}
+	for _, tc := range invalid {
+		if _, err := NewValidatedConnManager(tc.low, tc.hi, tc.grace, tc.peerstore, tc.protos); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+
+	cm, err := NewValidatedConnManager(10, 20, time.Minute, ps, map[protocol.ID]int{"/phore/1.0.0": 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.Close()
+}
+
+func TestCriticalWaterTriggersEmergencyTrim(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(5, 10, time.Hour, ps, map[protocol.ID]int{}, WithCriticalWater(15))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// crossing the high watermark alone leaves connections within their grace period alone.
+	for i := 0; i < 15; i++ {
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+	cm.TrimOpenConns(context.Background())
+	if n := atomic.LoadInt32(&cm.connCount); n != 15 {
+		t.Fatalf("expected a regular trim to respect the grace period, got %d connections", n)
+	}
+
+	// crossing the critical watermark trims right away, even within the silence period.
+	not.Connected(nil, randConn(t, not.Disconnected))
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&cm.connCount) != 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected an emergency trim down to 5 connections, got %d", atomic.LoadInt32(&cm.connCount))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamActivityBreaksTies(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(5, 5, 0, ps, map[protocol.ID]int{})
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var conns []network.Conn
+	for i := 0; i < 10; i++ {
+		rc := randConn(t, nil)
+		conns = append(conns, rc)
+		not.Connected(nil, rc)
+	}
+	// the first five peers are busy; a stream reported twice is only counted once.
+	for _, c := range conns[:5] {
+		not.OpenedStream(nil, &tstream{conn: c})
+	}
+	st := &tstream{conn: conns[0]}
+	not.OpenedStream(nil, st)
+	not.OpenedStream(nil, st)
+	not.ClosedStream(nil, st)
+
+	if n := len(cm.segments.get(conns[0].RemotePeer()).peers[conns[0].RemotePeer()].conns[conns[0]].streams); n != 1 {
+		t.Fatalf("expected one active stream, got %d", n)
+	}
+
+	cm.TrimOpenConns(context.Background())
+	for i, c := range conns {
+		if shouldClose := i >= 5; c.(*tconn).closed != shouldClose {
+			t.Errorf("connection %d: expected closed=%v", i, shouldClose)
+		}
+	}
+}
+
+func TestTagPeerWithTTL(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithClock(clock))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	serving, other := randConn(t, nil), randConn(t, nil)
+	not.Connected(nil, serving)
+	not.Connected(nil, other)
+	cm.TagPeer(serving.RemotePeer(), "base", 1)
+	cm.TagPeerWithTTL(serving.RemotePeer(), "serving-block", 100, time.Minute)
+	cm.TagPeer(other.RemotePeer(), "base", 10)
+
+	// setting the tag again without a TTL makes it permanent.
+	cm.TagPeerWithTTL(other.RemotePeer(), "sticky", 5, time.Minute)
+	cm.TagPeer(other.RemotePeer(), "sticky", 5)
+
+	if v := cm.GetTagInfo(serving.RemotePeer()).Value; v != 101 {
+		t.Fatalf("expected value 101 before expiry, got %d", v)
+	}
+
+	clock.Add(time.Minute)
+	if v := cm.GetTagInfo(serving.RemotePeer()).Value; v != 1 {
+		t.Fatalf("expected the boost to expire, got value %d", v)
+	}
+	if v := cm.GetTagInfo(other.RemotePeer()).Value; v != 15 {
+		t.Fatalf("expected the permanent tag to stay, got value %d", v)
+	}
+
+	cm.TrimOpenConns(context.Background())
+	if !serving.(*tconn).closed || other.(*tconn).closed {
+		t.Fatal("expected the peer whose boost expired to be pruned")
+	}
+}
+
+func TestOverloadTrimBypassesSilence(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(5, 10, 0, ps, map[protocol.ID]int{}, WithOverloadTrim(2, false))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 11; i++ {
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+	cm.TrimOpenConns(context.Background())
+	if n := atomic.LoadInt32(&cm.connCount); n != 5 {
+		t.Fatalf("expected a regular trim down to 5 connections, got %d", n)
+	}
+
+	// within the silence period, twice the high watermark is too many.
+	for i := 0; i < 16; i++ {
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&cm.connCount) != 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected an emergency trim down to 5 connections, got %d", atomic.LoadInt32(&cm.connCount))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloseInterceptor(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithSilencePeriod(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	downloading, idle, kept := randConn(t, not.Disconnected), randConn(t, not.Disconnected), randConn(t, not.Disconnected)
+	not.Connected(nil, downloading)
+	not.Connected(nil, idle)
+	not.Connected(nil, kept)
+	cm.TagPeer(kept.RemotePeer(), "score", 10)
+
+	cm.SetCloseInterceptor(func(c network.Conn) bool {
+		return c != downloading
+	})
+	cm.TrimOpenConns(context.Background())
+	if downloading.(*tconn).closed {
+		t.Fatal("expected the interceptor to veto the close")
+	}
+	if !idle.(*tconn).closed || kept.(*tconn).closed {
+		t.Fatal("expected the other selected connection to be closed")
+	}
+
+	cm.SetCloseInterceptor(nil)
+	cm.TrimOpenConns(context.Background())
+	if !downloading.(*tconn).closed {
+		t.Fatal("expected the connection to be closed once the interceptor is removed")
+	}
+}
+
+func TestTrimOpenConnsResult(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithClock(clock))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	pruned, kept := randConn(t, not.Disconnected), randConn(t, not.Disconnected)
+	not.Connected(nil, pruned)
+	not.Connected(nil, &tconn{peer: pruned.RemotePeer(), disconnectNotify: not.Disconnected})
+	not.Connected(nil, kept)
+	cm.TagPeer(kept.RemotePeer(), "score", 10)
+
+	clock.Add(time.Minute)
+	res, err := cm.TrimOpenConnsResult(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Closed != 2 || len(res.Peers) != 1 || res.Peers[0] != pruned.RemotePeer() {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	if _, err := cm.TrimOpenConnsResult(context.Background()); err != ErrSilencePeriod {
+		t.Fatalf("expected the silence period to skip the trim, got %v", err)
+	}
+
+	cm.trimRunningCh <- struct{}{}
+	clock.Add(time.Minute)
+	if _, err := cm.TrimOpenConnsResult(context.Background()); err != ErrTrimInProgress {
+		t.Fatalf("expected the running trim to skip the trim, got %v", err)
+	}
+	<-cm.trimRunningCh
+}
+
+func TestRequestTrim(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithSilencePeriod(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	pruned, kept := randConn(t, not.Disconnected), randConn(t, not.Disconnected)
+	not.Connected(nil, pruned)
+	not.Connected(nil, kept)
+	cm.TagPeer(kept.RemotePeer(), "score", 10)
+
+	// the requests wait for the trim in progress, and share the next one.
+	cm.trimRunningCh <- struct{}{}
+	first, second := cm.RequestTrim(), cm.RequestTrim()
+	select {
+	case <-first:
+		t.Fatal("expected the request to wait for the trim in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-cm.trimRunningCh
+
+	for _, ch := range []<-chan TrimReport{first, second} {
+		report := <-ch
+		if report.Err != nil {
+			t.Fatal(report.Err)
+		}
+		if report.Result.Closed != 1 || report.Result.Peers[0] != pruned.RemotePeer() {
+			t.Fatalf("unexpected report %+v", report)
+		}
+	}
+}
+
+func TestRequestTrimAfterClose(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{})
+
+	cm.trimRunningCh <- struct{}{}
+	ch := cm.RequestTrim()
+	cm.Close()
+	if report := <-ch; report.Err != context.Canceled {
+		t.Fatalf("expected the request to be canceled, got %v", report.Err)
+	}
+}
+
+func TestIsProtected(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{})
+	defer cm.Close()
+
+	id := tu.RandPeerIDFatal(t)
+	if cm.IsProtected(id, "") {
+		t.Fatal("expected the peer not to be protected")
+	}
+
+	cm.Protect(id, "global")
+	if !cm.IsProtected(id, "") || !cm.IsProtected(id, "global") {
+		t.Fatal("expected the peer to be protected")
+	}
+	if cm.IsProtected(id, "other") {
+		t.Fatal("expected the peer not to be protected under another tag")
+	}
+
+	cm.Unprotect(id, "global")
+	if cm.IsProtected(id, "") {
+		t.Fatal("expected the peer not to be protected anymore")
+	}
+}
+
+func TestGetInfoStats(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(2, 4, 0, ps, map[protocol.ID]int{})
+	not := cm.Notifee()
+
+	var conns []*tconn
+	for i := 0; i < 5; i++ {
+		c := &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirInbound}
+		if i < 2 {
+			c.dir = network.DirOutbound
+		}
+		conns = append(conns, c)
+		not.Connected(nil, c)
+	}
+	cm.Protect(conns[0].peer, "foo")
+	cm.Protect(conns[0].peer, "bar")
+	cm.TagPeer(tu.RandPeerIDFatal(t), "later", 1)
+
+	info := cm.GetInfo()
+	if info.InboundConns != 3 || info.OutboundConns != 2 {
+		t.Fatalf("expected 3 inbound and 2 outbound connections, got %d and %d", info.InboundConns, info.OutboundConns)
+	}
+	if info.TrackedPeers != 6 {
+		t.Fatalf("expected 6 tracked peers, got %d", info.TrackedPeers)
+	}
+	if info.ProtectedPeers != 1 {
+		t.Fatalf("expected 1 protected peer, got %d", info.ProtectedPeers)
+	}
+	if info.TotalTrims != 0 || info.LastTrimClosed != 0 {
+		t.Fatalf("expected no trims yet, got %d closing %d", info.TotalTrims, info.LastTrimClosed)
+	}
+
+	cm.TrimOpenConns(context.Background())
+	info = cm.GetInfo()
+	if info.TotalTrims != 1 || info.LastTrimClosed != 3 {
+		t.Fatalf("expected 1 trim closing 3 connections, got %d closing %d", info.TotalTrims, info.LastTrimClosed)
+	}
+}
+
+func TestConnCounts(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{})
+	defer cm.Close()
+	not := cm.Notifee()
+
+	id := tu.RandPeerIDFatal(t)
+	in := &tconn{peer: id, dir: network.DirInbound}
+	not.Connected(nil, in)
+	not.Connected(nil, &tconn{peer: id, dir: network.DirOutbound})
+	not.Connected(nil, &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirInbound})
+	not.Connected(nil, randConn(t, nil))
+
+	if inbound, outbound, total := cm.ConnCounts(); inbound != 2 || outbound != 1 || total != 4 {
+		t.Fatalf("expected 2 inbound, 1 outbound and 4 connections, got %d, %d and %d", inbound, outbound, total)
+	}
+
+	not.Disconnected(nil, in)
+	if inbound, outbound, total := cm.ConnCounts(); inbound != 1 || outbound != 1 || total != 3 {
+		t.Fatalf("expected 1 inbound, 1 outbound and 3 connections, got %d, %d and %d", inbound, outbound, total)
+	}
+}
+
+func TestTrimHonorsContextCancellation(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithSilencePeriod(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	closes := 0
+	for i := 0; i < 5; i++ {
+		rc := randConn(t, func(network.Network, network.Conn) {
+			if closes++; closes == 2 {
+				cancel()
+			}
+		})
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "score", i)
+	}
+
+	res, err := cm.TrimOpenConnsResult(ctx)
+	if err != context.Canceled {
+		t.Fatalf("expected the trim to be canceled, got %v", err)
+	}
+	if res.Closed != 2 || len(res.Peers) != 2 || closes != 2 {
+		t.Fatalf("expected 2 connections closed before the cancellation, got %+v", res)
+	}
+	if info := cm.GetInfo(); info.TotalTrims != 1 || info.LastTrimClosed != 2 {
+		t.Fatalf("expected the partial trim to be recorded, got %+v", info)
+	}
+
+	// a context done beforehand prevents the trim altogether.
+	res, err = cm.TrimOpenConnsResult(ctx)
+	if err != context.Canceled || res.Closed != 0 || closes != 2 {
+		t.Fatalf("expected nothing to be closed, got %+v, %v", res, err)
+	}
+	if n := cm.GetInfo().TotalTrims; n != 1 {
+		t.Fatalf("expected no trim to be recorded, got %d", n)
+	}
+}
@@ -0,0 +1,44 @@
+package connmgr
+
+import "github.com/libp2p/go-libp2p-core/peer"
+
+// ScoreContributor computes a part of the score of p during trims, on top of its tags,
+// for signals that live outside the connection manager (e.g. stake or masternode
+// status). Contributors are called with the lock of the segment of p held, and must
+// not call back into the connection manager.
+type ScoreContributor func(p peer.ID) int
+
+// RegisterScoreContributor adds c to the score contributors consulted by trims,
+// replacing any contributor previously registered under name. The contributions of
+// all registered contributors are summed into PeerSnapshot.Contributed, and added to
+// the score.
+func (cm *PhoreConnMgr) RegisterScoreContributor(name string, c ScoreContributor) {
+	cm.contributorsMu.Lock()
+	defer cm.contributorsMu.Unlock()
+
+	if cm.contributors == nil {
+		cm.contributors = make(map[string]ScoreContributor)
+	}
+	cm.contributors[name] = c
+}
+
+// UnregisterScoreContributor removes the score contributor registered under name, if
+// any.
+func (cm *PhoreConnMgr) UnregisterScoreContributor(name string) {
+	cm.contributorsMu.Lock()
+	defer cm.contributorsMu.Unlock()
+
+	delete(cm.contributors, name)
+}
+
+// contribution returns the sum of the registered score contributions for p.
+func (cm *PhoreConnMgr) contribution(p peer.ID) int {
+	cm.contributorsMu.RLock()
+	defer cm.contributorsMu.RUnlock()
+
+	total := 0
+	for _, c := range cm.contributors {
+		total += c(p)
+	}
+	return total
+}
@@ -0,0 +1,124 @@
+package connmgr
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// CooldownMode selects how peers reconnecting shortly after being pruned are treated,
+// see WithReconnectCooldown.
+type CooldownMode int
+
+const (
+	// CooldownPenalize lets pruned peers reconnect, but denies them a grace period and
+	// subtracts a penalty from their score until the cooldown ends.
+	CooldownPenalize CooldownMode = iota
+
+	// CooldownReject additionally makes InterceptReconnect refuse pruned peers until
+	// the cooldown ends, for use in connection gaters and dial filters. Connections
+	// that get through anyway are penalized.
+	CooldownReject
+)
+
+// ClosePeerCooldown is how long InterceptReconnect refuses the peers closed with
+// ClosePeer, whatever the cooldown configured with WithReconnectCooldown. The static
+// peers are not redialed meanwhile either.
+const ClosePeerCooldown = 30 * time.Second
+
+// recordClosed starts the ClosePeerCooldown of id.
+func (cm *PhoreConnMgr) recordClosed(id peer.ID) {
+	until := cm.clock.Now().Add(ClosePeerCooldown)
+
+	cm.cooldownMu.Lock()
+	defer cm.cooldownMu.Unlock()
+	if cm.closedPeers == nil {
+		cm.closedPeers = make(map[peer.ID]time.Time)
+	}
+	cm.closedPeers[id] = until
+}
+
+// closedRecently reports whether p was closed with ClosePeer less than
+// ClosePeerCooldown ago.
+func (cm *PhoreConnMgr) closedRecently(p peer.ID, now time.Time) bool {
+	cm.cooldownMu.Lock()
+	defer cm.cooldownMu.Unlock()
+
+	until, ok := cm.closedPeers[p]
+	if !ok {
+		return false
+	}
+	if !until.After(now) {
+		delete(cm.closedPeers, p)
+		return false
+	}
+	return true
+}
+
+// recordPruned starts the cooldown of the peers of the given closed connections.
+func (cm *PhoreConnMgr) recordPruned(conns []network.Conn) {
+	if cm.cooldown <= 0 || len(conns) == 0 {
+		return
+	}
+	until := cm.clock.Now().Add(cm.cooldown)
+
+	cm.cooldownMu.Lock()
+	defer cm.cooldownMu.Unlock()
+	for _, c := range conns {
+		cm.pruned[c.RemotePeer()] = until
+	}
+}
+
+// inCooldown reports whether p was pruned less than the cooldown window ago.
+func (cm *PhoreConnMgr) inCooldown(p peer.ID, now time.Time) bool {
+	if cm.cooldown <= 0 {
+		return false
+	}
+	cm.cooldownMu.Lock()
+	defer cm.cooldownMu.Unlock()
+
+	until, ok := cm.pruned[p]
+	if !ok {
+		return false
+	}
+	if !until.After(now) {
+		delete(cm.pruned, p)
+		return false
+	}
+	return true
+}
+
+// expireCooldowns forgets the peers whose cooldown has ended.
+func (cm *PhoreConnMgr) expireCooldowns() {
+	now := cm.clock.Now()
+
+	cm.cooldownMu.Lock()
+	defer cm.cooldownMu.Unlock()
+	for p, until := range cm.pruned {
+		if !until.After(now) {
+			delete(cm.pruned, p)
+		}
+	}
+	for p, until := range cm.closedPeers {
+		if !until.After(now) {
+			delete(cm.closedPeers, p)
+		}
+	}
+}
+
+// InterceptReconnect reports whether a connection to or from p should be allowed. It
+// refuses peers closed with ClosePeer less than ClosePeerCooldown ago, as well as peers
+// pruned less than the cooldown window ago when WithReconnectCooldown is configured
+// with CooldownReject, and allows everyone otherwise. It is meant to be called from
+// connection gaters and dial filters.
+func (cm *PhoreConnMgr) InterceptReconnect(p peer.ID) bool {
+	now := cm.clock.Now()
+	if cm.closedRecently(p, now) {
+		return false
+	}
+	if cm.cooldownMode != CooldownReject {
+		return true
+	}
+	return !cm.inCooldown(p, now)
+}
@@ -0,0 +1,55 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestReconnectCooldown(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, time.Minute, ps, map[protocol.ID]int{}, WithClock(clock), WithSilencePeriod(0),
+		WithReconnectCooldown(10*time.Minute, CooldownReject, 5))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	pruned, kept := randConn(t, not.Disconnected), randConn(t, not.Disconnected)
+	not.Connected(nil, pruned)
+	not.Connected(nil, kept)
+	cm.TagPeer(kept.RemotePeer(), "score", 10)
+
+	clock.Add(2 * time.Minute)
+	cm.TrimOpenConns(context.Background())
+	if !pruned.(*tconn).closed || kept.(*tconn).closed {
+		t.Fatal("expected the low value peer to be pruned")
+	}
+	if cm.InterceptReconnect(pruned.RemotePeer()) {
+		t.Fatal("expected the pruned peer to be refused during its cooldown")
+	}
+	if !cm.InterceptReconnect(kept.RemotePeer()) {
+		t.Fatal("expected other peers to be allowed")
+	}
+
+	// the pruned peer gets through anyway: it gets no grace period, unlike a newcomer.
+	again := &tconn{peer: pruned.RemotePeer()}
+	not.Connected(nil, again)
+	newcomer := randConn(t, nil)
+	not.Connected(nil, newcomer)
+	cm.TrimOpenConns(context.Background())
+	if !again.closed {
+		t.Fatal("expected the reconnected peer to be pruned without grace")
+	}
+	if newcomer.(*tconn).closed {
+		t.Fatal("expected the newcomer to be protected by its grace period")
+	}
+
+	clock.Add(10 * time.Minute)
+	if !cm.InterceptReconnect(pruned.RemotePeer()) {
+		t.Fatal("expected the cooldown to end")
+	}
+}
@@ -0,0 +1,254 @@
+package connmgr
+
+import (
+	"container/heap"
+	"net"
+	"sort"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// groupFunc assigns a peer to a diversity group, and returns false for peers that
+// belong to no group.
+type groupFunc func(p PeerSnapshot) (string, bool)
+
+// groupKeys returns the group key of each candidate. Peers that belong to no group
+// count as groups of their own.
+func groupKeys(candidates []PeerSnapshot, group groupFunc) []string {
+	keys := make([]string, len(candidates))
+	for i, p := range candidates {
+		key, ok := group(p)
+		if !ok {
+			// the peer ID cannot be mistaken for a group key, which are addresses or
+			// names.
+			key = "peer:" + string(p.ID)
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// capGroups splits candidates, ordered from first to last pruned, into the surplus of
+// the groups holding more than max peers, which is pruned whatever the target, and the
+// rest, in the original order. The first peers of a crowded group are the surplus, so
+// the best ones are kept.
+func capGroups(candidates []PeerSnapshot, group groupFunc, max int) (surplus, rest []PeerSnapshot) {
+	keys := groupKeys(candidates, group)
+	sizes := make(map[string]int)
+	for _, key := range keys {
+		sizes[key]++
+	}
+
+	rest = make([]PeerSnapshot, 0, len(candidates))
+	for i, p := range candidates {
+		if sizes[keys[i]] > max {
+			surplus = append(surplus, p)
+			sizes[keys[i]]--
+			continue
+		}
+		rest = append(rest, p)
+	}
+	return surplus, rest
+}
+
+// crowdedFirst reorders candidates, ordered from first to last pruned, so that the
+// peers of the most crowded groups come first. Within a group, and among groups of
+// equal size, the original order is preserved.
+func crowdedFirst(candidates []PeerSnapshot, group groupFunc) []PeerSnapshot {
+	// repeatedly pick the next peer of the largest remaining group; ties go to the
+	// group whose next peer comes first.
+	return interleave(candidates, groupKeys(candidates, group), func(a, b *groupQueue) bool {
+		if len(a.next) != len(b.next) {
+			return len(a.next) > len(b.next)
+		}
+		return a.next[0] < b.next[0]
+	})
+}
+
+// groupQueue holds the peers of a group that are still to be placed by interleave, as
+// indices into the candidates in their original order, along with the size of the
+// group and the number of its peers placed so far.
+type groupQueue struct {
+	next  []int
+	size  int
+	taken int
+}
+
+// groupQueues is a heap of the group queues that are not empty, see interleave.
+type groupQueues struct {
+	queues []*groupQueue
+	less   func(a, b *groupQueue) bool
+}
+
+func (h *groupQueues) Len() int           { return len(h.queues) }
+func (h *groupQueues) Less(i, j int) bool { return h.less(h.queues[i], h.queues[j]) }
+func (h *groupQueues) Swap(i, j int)      { h.queues[i], h.queues[j] = h.queues[j], h.queues[i] }
+
+func (h *groupQueues) Push(x interface{}) {
+	h.queues = append(h.queues, x.(*groupQueue))
+}
+
+func (h *groupQueues) Pop() interface{} {
+	q := h.queues[len(h.queues)-1]
+	h.queues = h.queues[:len(h.queues)-1]
+	return q
+}
+
+// interleave reorders candidates, grouped by keys, by repeatedly picking the next peer
+// of the group that less orders first, which must break ties by the next peer of each
+// group. Groups are kept in a heap, so reordering n candidates costs O(n log g) for g
+// groups.
+func interleave(candidates []PeerSnapshot, keys []string, less func(a, b *groupQueue) bool) []PeerSnapshot {
+	byKey := make(map[string]*groupQueue)
+	h := &groupQueues{less: less}
+	for i, key := range keys {
+		q, ok := byKey[key]
+		if !ok {
+			q = &groupQueue{}
+			byKey[key] = q
+			h.queues = append(h.queues, q)
+		}
+		q.next = append(q.next, i)
+		q.size++
+	}
+	heap.Init(h)
+
+	out := make([]PeerSnapshot, 0, len(candidates))
+	for h.Len() > 0 {
+		q := h.queues[0]
+		out = append(out, candidates[q.next[0]])
+		q.next = q.next[1:]
+		q.taken++
+		if len(q.next) == 0 {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+	return out
+}
+
+// remoteIP returns the remote IP address of the oldest connection to the peer that
+// runs over IP.
+func remoteIP(p PeerSnapshot) (net.IP, bool) {
+	conns := make([]ConnSnapshot, len(p.Conns))
+	copy(conns, p.Conns)
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].Opened.Before(conns[j].Opened)
+	})
+
+	for _, c := range conns {
+		if ip, ok := ipOf(c.Conn.RemoteMultiaddr()); ok {
+			return ip, true
+		}
+	}
+	return nil, false
+}
+
+// ipOf returns the IP address of addr, if it has one.
+func ipOf(addr ma.Multiaddr) (net.IP, bool) {
+	if addr == nil {
+		return nil, false
+	}
+	for _, code := range []int{ma.P_IP4, ma.P_IP6} {
+		if v, err := addr.ValueForProtocol(code); err == nil {
+			if ip := net.ParseIP(v); ip != nil {
+				return ip, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// subnetOf groups peers by the /24 (IPv4) or /48 (IPv6) subnet of their address.
+func subnetOf(p PeerSnapshot) (string, bool) {
+	ip, ok := remoteIP(p)
+	if !ok {
+		return "", false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String(), true
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String(), true
+}
+
+// GeoIPProvider locates IP addresses, see WithGeoDiversity.
+type GeoIPProvider interface {
+	// Country returns a code identifying the country ip is located in, such as its
+	// ISO 3166-1 alpha-2 code, or false if the country is unknown.
+	Country(ip net.IP) (string, bool)
+}
+
+// countryOf returns a groupFunc grouping peers by the country of their address.
+func countryOf(geo GeoIPProvider) groupFunc {
+	return func(p PeerSnapshot) (string, bool) {
+		ip, ok := remoteIP(p)
+		if !ok {
+			return "", false
+		}
+		return geo.Country(ip)
+	}
+}
+
+// shareCeiling converts a share of the candidates that survive the trim into a number
+// of peers, of at least one.
+func shareCeiling(snap TrimSnapshot, ordered []PeerSnapshot, share float64) int {
+	pruned, target := 0, snap.Target
+	for _, p := range ordered {
+		if target <= 0 {
+			break
+		}
+		pruned++
+		target -= snap.Count(p)
+	}
+
+	ceiling := int(share * float64(len(ordered)-pruned))
+	if ceiling < 1 {
+		ceiling = 1
+	}
+	return ceiling
+}
+
+// tagClassOf returns a groupFunc putting each peer into the tag class, identified by a
+// tag prefix, that contributes most to its value. Ties go to the first class listed.
+func tagClassOf(prefixes []string) groupFunc {
+	return func(p PeerSnapshot) (string, bool) {
+		best, bestValue := "", 0
+		for _, prefix := range prefixes {
+			value, found := 0, false
+			for t, v := range p.Tags {
+				if strings.HasPrefix(t, prefix) {
+					value += v
+					found = true
+				}
+			}
+			if found && (best == "" || value > bestValue) {
+				best, bestValue = prefix, value
+			}
+		}
+		return best, best != ""
+	}
+}
+
+// proportionalOrder reorders candidates, ordered from first to last pruned, so that
+// any prefix of the result takes about the same fraction of the peers of every group.
+// Peers that belong to no group form a group of their own. Within a group the original
+// order is preserved.
+func proportionalOrder(candidates []PeerSnapshot, group groupFunc) []PeerSnapshot {
+	keys := make([]string, len(candidates))
+	for i, p := range candidates {
+		keys[i], _ = group(p)
+	}
+
+	// repeatedly pick the next peer of the group that lost the smallest fraction of
+	// its peers so far; ties go to the group whose next peer comes first.
+	return interleave(candidates, keys, func(a, b *groupQueue) bool {
+		// compare a.taken/a.size with b.taken/b.size.
+		l, r := a.taken*b.size, b.taken*a.size
+		if l != r {
+			return l < r
+		}
+		return a.next[0] < b.next[0]
+	})
+}
@@ -0,0 +1,194 @@
+package connmgr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func addrConn(t *testing.T, addr string) network.Conn {
+	c := randConn(t, nil)
+	c.(*tconn).addr = ma.StringCast(addr)
+	return c
+}
+
+func TestSubnetDiversity(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(3, 3, 0, ps, map[protocol.ID]int{}, WithSubnetDiversity(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// three valuable peers sharing a subnet, and two cheap ones on their own.
+	crowded := []network.Conn{
+		addrConn(t, "/ip4/10.0.0.1/tcp/1"),
+		addrConn(t, "/ip4/10.0.0.2/tcp/1"),
+		addrConn(t, "/ip4/10.0.0.3/tcp/1"),
+	}
+	alone := []network.Conn{
+		addrConn(t, "/ip4/10.0.1.1/tcp/1"),
+		addrConn(t, "/ip6/2001:db8::1/tcp/1"),
+	}
+	for i, c := range crowded {
+		not.Connected(nil, c)
+		cm.TagPeer(c.RemotePeer(), "score", 10*(i+1))
+	}
+	for _, c := range alone {
+		not.Connected(nil, c)
+	}
+
+	cm.TrimOpenConns(context.Background())
+
+	for i, c := range crowded {
+		if shouldClose := i < 2; c.(*tconn).closed != shouldClose {
+			t.Errorf("crowded peer %d: expected closed=%v", i, shouldClose)
+		}
+	}
+	for i, c := range alone {
+		if c.(*tconn).closed {
+			t.Errorf("expected lone peer %d to be kept", i)
+		}
+	}
+}
+
+func TestMaxPeersPerSubnet(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(4, 4, 0, ps, map[protocol.ID]int{}, WithSubnetDiversity(1))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	conns := []network.Conn{
+		addrConn(t, "/ip6/2001:db8:1:1::1/tcp/1"),
+		addrConn(t, "/ip6/2001:db8:1:2::1/tcp/1"),
+		addrConn(t, "/ip6/2001:db8:1:3::1/tcp/1"),
+		addrConn(t, "/ip6/2001:db8:2::1/tcp/1"),
+		addrConn(t, "/ip4/10.0.0.1/tcp/1"),
+	}
+	for i, c := range conns {
+		not.Connected(nil, c)
+		cm.TagPeer(c.RemotePeer(), "score", i)
+	}
+
+	// one peer over the watermark, but the /48 holds two too many.
+	cm.TrimOpenConns(context.Background())
+
+	for i, c := range conns {
+		if shouldClose := i < 2; c.(*tconn).closed != shouldClose {
+			t.Errorf("peer %d: expected closed=%v", i, shouldClose)
+		}
+	}
+}
+
+type prefixGeo map[string]string
+
+func (g prefixGeo) Country(ip net.IP) (string, bool) {
+	for prefix, country := range g {
+		if strings.HasPrefix(ip.String(), prefix) {
+			return country, true
+		}
+	}
+	return "", false
+}
+
+func TestGeoDiversity(t *testing.T) {
+	geo := prefixGeo{"10.1.": "AA", "10.2.": "BB"}
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(5, 5, 0, ps, map[protocol.ID]int{}, WithGeoDiversity(geo, 0.5))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	conns := []network.Conn{
+		addrConn(t, "/ip4/10.1.0.1/tcp/1"),
+		addrConn(t, "/ip4/10.1.0.2/tcp/1"),
+		addrConn(t, "/ip4/10.1.0.3/tcp/1"),
+		addrConn(t, "/ip4/10.1.0.4/tcp/1"),
+		addrConn(t, "/ip4/10.2.0.1/tcp/1"),
+		addrConn(t, "/ip4/10.3.0.1/tcp/1"),
+	}
+	for i, c := range conns {
+		not.Connected(nil, c)
+		cm.TagPeer(c.RemotePeer(), "score", 10*(i+1))
+	}
+
+	// five survivors would allow two peers per country, so the two lowest scoring
+	// peers of AA go although the target is a single peer.
+	cm.TrimOpenConns(context.Background())
+
+	for i, c := range conns {
+		if shouldClose := i < 2; c.(*tconn).closed != shouldClose {
+			t.Errorf("peer %d: expected closed=%v", i, shouldClose)
+		}
+	}
+}
+
+func TestSubnetCapBeyondTarget(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(29, 30, 0, ps, map[protocol.ID]int{}, WithSubnetDiversity(1))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// the surplus of the /24 is far larger than the target of two peers.
+	var conns []network.Conn
+	for i := 1; i <= 31; i++ {
+		c := addrConn(t, fmt.Sprintf("/ip4/10.0.0.%d/tcp/1", i))
+		not.Connected(nil, c)
+		cm.TagPeer(c.RemotePeer(), "score", i)
+		conns = append(conns, c)
+	}
+
+	cm.TrimOpenConns(context.Background())
+
+	for i, c := range conns {
+		if shouldClose := i < 30; c.(*tconn).closed != shouldClose {
+			t.Errorf("peer %d: expected closed=%v", i, shouldClose)
+		}
+	}
+}
+
+func TestCrowdedFirstOrder(t *testing.T) {
+	groups := map[peer.ID]string{"p0": "a", "p1": "b", "p2": "c", "p3": "a", "p4": "a", "p5": "b"}
+	var candidates []PeerSnapshot
+	for _, id := range []peer.ID{"p0", "p1", "p2", "p3", "p4", "p5"} {
+		candidates = append(candidates, PeerSnapshot{ID: id})
+	}
+
+	ordered := crowdedFirst(candidates, func(p PeerSnapshot) (string, bool) {
+		return groups[p.ID], true
+	})
+
+	var got []peer.ID
+	for _, p := range ordered {
+		got = append(got, p.ID)
+	}
+	if want := []peer.ID{"p0", "p1", "p3", "p2", "p4", "p5"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected order %v, got %v", want, got)
+	}
+}
+
+func TestProportionalOrder(t *testing.T) {
+	groups := map[peer.ID]string{"p0": "a", "p1": "a", "p2": "a", "p3": "a", "p4": "b", "p5": "b"}
+	var candidates []PeerSnapshot
+	for _, id := range []peer.ID{"p0", "p1", "p2", "p3", "p4", "p5"} {
+		candidates = append(candidates, PeerSnapshot{ID: id})
+	}
+
+	ordered := proportionalOrder(candidates, func(p PeerSnapshot) (string, bool) {
+		return groups[p.ID], true
+	})
+
+	var got []peer.ID
+	for _, p := range ordered {
+		got = append(got, p.ID)
+	}
+	if want := []peer.ID{"p0", "p4", "p1", "p2", "p5", "p3"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected order %v, got %v", want, got)
+	}
+}
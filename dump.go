@@ -0,0 +1,112 @@
+package connmgr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// dumpRow is a line of the table written by DumpString.
+type dumpRow struct {
+	id        peer.ID
+	value     int
+	tags      map[string]int
+	conns     int
+	protected string
+	grace     time.Duration
+}
+
+// DumpString formats the tracked peers as a table for debug consoles, by descending
+// value: their tags, number of connections, protection, and the grace period they have
+// left.
+func (cm *PhoreConnMgr) DumpString() string {
+	low, hi := cm.watermarks()
+	grace, _ := cm.timing()
+	rows := cm.dumpRows(grace)
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].value != rows[j].value {
+			return rows[i].value > rows[j].value
+		}
+		return rows[i].id < rows[j].id
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "watermarks: %d/%d, connections: %d, peers: %d\n", low, hi, atomic.LoadInt32(&cm.connCount), len(rows))
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PEER\tVALUE\tCONNS\tPROTECTED\tGRACE\tTAGS")
+	for _, r := range rows {
+		remaining := "-"
+		if r.grace > 0 {
+			remaining = r.grace.Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", r.id.Pretty(), r.value, r.conns, r.protected, remaining, formatTags(r.tags))
+	}
+	w.Flush()
+	return b.String()
+}
+
+// dumpRows collects the rows of the tracked peers, given the grace period.
+func (cm *PhoreConnMgr) dumpRows(grace time.Duration) []dumpRow {
+	now := cm.clock.Now()
+
+	cm.plk.RLock()
+	defer cm.plk.RUnlock()
+
+	var rows []dumpRow
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+		for id, pi := range s.peers {
+			cm.expireTags(pi, now)
+			r := dumpRow{
+				id:    id,
+				value: pi.value,
+				tags:  make(map[string]int, len(pi.tags)),
+				conns: len(pi.conns),
+				grace: grace - now.Sub(pi.firstSeen),
+			}
+			for t, v := range pi.tags {
+				r.tags[t] = v
+			}
+			r.protected = cm.protectionOf(id, pi)
+			rows = append(rows, r)
+		}
+		s.Unlock()
+	}
+	return rows
+}
+
+// protectionOf names what exempts the peer from trims, or returns "no". cm.plk must be
+// held by the caller.
+func (cm *PhoreConnMgr) protectionOf(id peer.ID, pi *peerInfo) string {
+	if _, ok := cm.protected[id]; ok {
+		return "tag"
+	}
+	if pi.allowed > 0 {
+		return "allowlist"
+	}
+	if cm.protectedByProtocol(id) {
+		return "protocol"
+	}
+	if cm.protectedAddrs.matchesAny(pi) {
+		return "addr"
+	}
+	return "no"
+}
+
+// formatTags formats tags as comma separated tag=value pairs, sorted by tag.
+func formatTags(tags map[string]int) string {
+	if len(tags) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(tags))
+	for t, v := range tags {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", t, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,53 @@
+package connmgr
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestDumpString(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, time.Minute, ps, map[protocol.ID]int{}, WithClock(clock))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	old := randConn(t, nil)
+	not.Connected(nil, old)
+	cm.TagPeer(old.RemotePeer(), "foo", 1)
+	cm.TagPeer(old.RemotePeer(), "bar", 2)
+	clock.Add(2 * time.Minute)
+
+	young := randConn(t, nil)
+	not.Connected(nil, young)
+	cm.TagPeer(young.RemotePeer(), "foo", 10)
+	cm.Protect(young.RemotePeer(), "keep")
+	clock.Add(20 * time.Second)
+
+	lines := strings.Split(strings.TrimSpace(cm.DumpString()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected a summary, a header and 2 rows, got:\n%s", strings.Join(lines, "\n"))
+	}
+	if lines[0] != "watermarks: 10/20, connections: 2, peers: 2" {
+		t.Errorf("unexpected summary: %q", lines[0])
+	}
+	if fields := strings.Fields(lines[1]); strings.Join(fields, " ") != "PEER VALUE CONNS PROTECTED GRACE TAGS" {
+		t.Errorf("unexpected header: %q", lines[1])
+	}
+
+	// the most valuable peer comes first.
+	want := [][]string{
+		{young.RemotePeer().Pretty(), "10", "1", "tag", "40s", "foo=10"},
+		{old.RemotePeer().Pretty(), "3", "1", "no", "-", "bar=2,foo=1"},
+	}
+	for i, w := range want {
+		if got := strings.Fields(lines[i+2]); strings.Join(got, " ") != strings.Join(w, " ") {
+			t.Errorf("row %d: expected %v, got %v", i, w, got)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package connmgr
+
+import (
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// EvtPeerTrimmed is emitted, when an event bus is configured through WithEventBus,
+// for every peer whose connections were closed by a trim.
+type EvtPeerTrimmed struct {
+	PrunedPeer
+}
+
+// EvtWatermarkExceeded is emitted by the background loop whenever it finds the
+// connection count above the high watermark.
+type EvtWatermarkExceeded struct {
+	// Count is the connection or peer count, depending on the WatermarkBasis.
+	Count int
+
+	// HighWater is the high watermark in effect.
+	HighWater int
+}
+
+// EvtProtocolMinimumAtRisk is emitted after a trim for every protocol whose retained
+// peers fall short of its configured minimum.
+type EvtProtocolMinimumAtRisk struct {
+	Protocol protocol.ID
+
+	// Minimum is the configured minimum number of peers for Protocol.
+	Minimum int
+
+	// Retained is the number of peers supporting Protocol left by the trim.
+	Retained int
+}
+
+// EvtNeedPeers is emitted whenever a PeerDemand is signalled, see WithMinPeers.
+type EvtNeedPeers struct {
+	PeerDemand
+}
+
+// emitters are the event bus emitters of the events above.
+type emitters struct {
+	trimmed  event.Emitter
+	exceeded event.Emitter
+	atRisk   event.Emitter
+	need     event.Emitter
+}
+
+// newEmitters creates the emitters of the connection manager events on bus.
+func newEmitters(bus event.Bus) (*emitters, error) {
+	var (
+		em  emitters
+		err error
+	)
+	if em.trimmed, err = bus.Emitter(new(EvtPeerTrimmed)); err != nil {
+		return nil, err
+	}
+	if em.exceeded, err = bus.Emitter(new(EvtWatermarkExceeded)); err != nil {
+		em.close()
+		return nil, err
+	}
+	if em.atRisk, err = bus.Emitter(new(EvtProtocolMinimumAtRisk)); err != nil {
+		em.close()
+		return nil, err
+	}
+	if em.need, err = bus.Emitter(new(EvtNeedPeers)); err != nil {
+		em.close()
+		return nil, err
+	}
+	return &em, nil
+}
+
+// close closes the emitters created so far.
+func (em *emitters) close() {
+	for _, e := range []event.Emitter{em.trimmed, em.exceeded, em.atRisk, em.need} {
+		if e != nil {
+			e.Close()
+		}
+	}
+}
+
+// emitTrimmed emits the events following a completed trim.
+func (cm *PhoreConnMgr) emitTrimmed(plan trimPlan) {
+	if cm.events == nil {
+		return
+	}
+	for _, p := range plan.prunedPeers() {
+		cm.events.trimmed.Emit(EvtPeerTrimmed{PrunedPeer: p})
+	}
+	for _, h := range plan.hints {
+		if h.Retained < h.Minimum {
+			cm.events.atRisk.Emit(EvtProtocolMinimumAtRisk{
+				Protocol: h.Protocol,
+				Minimum:  h.Minimum,
+				Retained: h.Retained,
+			})
+		}
+	}
+}
+
+// emitWatermarkExceeded emits EvtWatermarkExceeded if the count is above hi.
+func (cm *PhoreConnMgr) emitWatermarkExceeded(hi int) {
+	if cm.events == nil {
+		return
+	}
+	if count := cm.count(); hi > 0 && count > hi {
+		cm.events.exceeded.Emit(EvtWatermarkExceeded{Count: count, HighWater: hi})
+	}
+}
@@ -0,0 +1,131 @@
+package connmgr
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+// recordingBus hands out emitters recording the events emitted.
+type recordingBus struct {
+	mu     sync.Mutex
+	events []interface{}
+	closed int
+}
+
+func (b *recordingBus) Subscribe(interface{}, ...event.SubscriptionOpt) (event.Subscription, error) {
+	panic("not implemented")
+}
+
+func (b *recordingBus) Emitter(interface{}, ...event.EmitterOpt) (event.Emitter, error) {
+	return recordingEmitter{b}, nil
+}
+
+func (b *recordingBus) emitted() []interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]interface{}(nil), b.events...)
+}
+
+type recordingEmitter struct {
+	bus *recordingBus
+}
+
+func (e recordingEmitter) Emit(evt interface{}) {
+	e.bus.mu.Lock()
+	defer e.bus.mu.Unlock()
+
+	e.bus.events = append(e.bus.events, evt)
+}
+
+func (e recordingEmitter) Close() error {
+	e.bus.mu.Lock()
+	defer e.bus.mu.Unlock()
+
+	e.bus.closed++
+	return nil
+}
+
+func TestEventBus(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	bus := new(recordingBus)
+	cm := NewConnManager(1, 2, 0, ps, map[protocol.ID]int{
+		"/phore/1.0.0": 2,
+	}, WithEventBus(bus))
+	not := cm.Notifee()
+
+	rc := randConn(t, nil)
+	not.Connected(nil, rc)
+	if err := ps.AddProtocols(rc.RemotePeer(), "/phore/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		not.Connected(nil, randConn(t, nil))
+	}
+
+	cm.TrimOpenConns(context.Background())
+
+	var trimmed int
+	var atRisk []EvtProtocolMinimumAtRisk
+	for _, evt := range bus.emitted() {
+		switch evt := evt.(type) {
+		case EvtPeerTrimmed:
+			if evt.Peer == rc.RemotePeer() || evt.Reason != ReasonLowScore {
+				t.Errorf("unexpected trimmed peer event: %+v", evt)
+			}
+			trimmed++
+		case EvtProtocolMinimumAtRisk:
+			atRisk = append(atRisk, evt)
+		default:
+			t.Errorf("unexpected event %T", evt)
+		}
+	}
+	if trimmed != 2 {
+		t.Errorf("expected 2 trimmed peer events, got %d", trimmed)
+	}
+	if len(atRisk) != 1 || atRisk[0] != (EvtProtocolMinimumAtRisk{Protocol: "/phore/1.0.0", Minimum: 2, Retained: 1}) {
+		t.Errorf("unexpected protocol minimum events: %+v", atRisk)
+	}
+
+	if err := cm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if bus.closed != 4 {
+		t.Errorf("expected the 4 emitters to be closed, got %d", bus.closed)
+	}
+}
+
+func TestEventBusWatermarkExceeded(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	bus := new(recordingBus)
+	cm := NewConnManager(1, 2, 0, ps, map[protocol.ID]int{}, WithEventBus(bus), WithTrimInterval(10*time.Millisecond))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 3; i++ {
+		not.Connected(nil, randConn(t, nil))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, evt := range bus.emitted() {
+			if evt, ok := evt.(EvtWatermarkExceeded); ok {
+				if evt.Count != 3 || evt.HighWater != 2 {
+					t.Fatalf("unexpected event: %+v", evt)
+				}
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the watermark to be reported exceeded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,35 @@
+package connmgr
+
+import (
+	"expvar"
+)
+
+// publishExpvars publishes the stats of the connection manager as expvar variables
+// named prefix followed by a dot and the name of the stat. Variables already published
+// under one of those names are left alone, as expvar cannot replace them.
+func (cm *PhoreConnMgr) publishExpvars(prefix string) {
+	vars := map[string]func() interface{}{
+		"connCount": func() interface{} { return cm.count() },
+		"lastTrim":  func() interface{} { return cm.getLastTrim() },
+		"lowWater": func() interface{} {
+			low, _ := cm.watermarks()
+			return low
+		},
+		"highWater": func() interface{} {
+			_, hi := cm.watermarks()
+			return hi
+		},
+		"trims": func() interface{} {
+			trims, _, _ := cm.trimStats()
+			return trims
+		},
+	}
+	for name, f := range vars {
+		name = prefix + "." + name
+		if expvar.Get(name) != nil {
+			log.Errorf("expvar %s is already published", name)
+			continue
+		}
+		expvar.Publish(name, expvar.Func(f))
+	}
+}
@@ -0,0 +1,49 @@
+package connmgr
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestExpvar(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 2, 0, ps, map[protocol.ID]int{}, WithExpvar("connmgr-test"))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 3; i++ {
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+	get := func(name string) (v interface{}) {
+		t.Helper()
+		ev := expvar.Get("connmgr-test." + name)
+		if ev == nil {
+			t.Fatalf("expected %s to be published", name)
+		}
+		if err := json.Unmarshal([]byte(ev.String()), &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	if get("connCount") != 3.0 || get("lowWater") != 1.0 || get("highWater") != 2.0 || get("trims") != 0.0 {
+		t.Fatal("unexpected stats before trimming")
+	}
+
+	cm.TrimOpenConns(context.Background())
+	if get("connCount") != 1.0 || get("trims") != 1.0 || get("lastTrim") == "0001-01-01T00:00:00Z" {
+		t.Fatal("unexpected stats after trimming")
+	}
+
+	// a second connection manager cannot take over the variables.
+	other := NewConnManager(0, 0, 0, ps, nil, WithExpvar("connmgr-test"))
+	other.Close()
+	if get("highWater") != 2.0 {
+		t.Fatal("expected the variables to keep reporting the first connection manager")
+	}
+}
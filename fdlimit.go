@@ -0,0 +1,74 @@
+package connmgr
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// refreshFDWatermarks recomputes the watermarks from the process file descriptor limit
+// when watermarks are derived from it (see WithFDLimitWatermarks), and the limit has
+// changed since the last call.
+func (cm *PhoreConnMgr) refreshFDWatermarks() {
+	if cm.fdHighFraction <= 0 {
+		return
+	}
+
+	limit, err := fdLimit()
+	if err != nil {
+		log.Warning("cannot derive watermarks from the file descriptor limit: ", err)
+		return
+	}
+	if limit > math.MaxInt32 {
+		// effectively unlimited.
+		limit = math.MaxInt32
+	}
+	if limit == cm.fdLimit {
+		return
+	}
+	cm.fdLimit = limit
+
+	low := int(float64(limit) * cm.fdLowFraction)
+	hi := int(float64(limit) * cm.fdHighFraction)
+	log.Infof("file descriptor limit is %d, setting watermarks to %d/%d", limit, low, hi)
+	if err := cm.SetWatermarks(low, hi); err != nil {
+		log.Warning("cannot derive watermarks from the file descriptor limit: ", err)
+	}
+}
+
+// checkFDs compares the number of open file descriptors to the process limit when
+// configured to through WithFDExhaustionTrim, and records whether the fraction of the
+// limit in use is exceeded.
+func (cm *PhoreConnMgr) checkFDs() {
+	if cm.fdExhaustionFraction <= 0 {
+		return
+	}
+
+	limit, err := fdLimit()
+	if err == nil {
+		var open int
+		if open, err = openFDs(); err == nil {
+			exhausted := float64(open) > cm.fdExhaustionFraction*float64(limit)
+			if exhausted && atomic.LoadInt32(&cm.fdExhausted) == 0 {
+				log.Warningf("%d file descriptors open out of a limit of %d", open, limit)
+			}
+			atomic.StoreInt32(&cm.fdExhausted, boolToInt32(exhausted))
+			return
+		}
+	}
+	log.Warning("cannot monitor file descriptor usage: ", err)
+	// only warn once.
+	cm.fdExhaustionFraction = 0
+}
+
+// fdsExhausted reports whether the open file descriptors exceeded the fraction of the
+// limit set through WithFDExhaustionTrim when last sampled.
+func (cm *PhoreConnMgr) fdsExhausted() bool {
+	return atomic.LoadInt32(&cm.fdExhausted) == 1
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
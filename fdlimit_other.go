@@ -0,0 +1,16 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package connmgr
+
+import "errors"
+
+// fdLimit is not supported on this platform.
+func fdLimit() (uint64, error) {
+	return 0, errors.New("file descriptor limits are not supported on this platform")
+}
+
+// openFDs is not supported on this platform.
+func openFDs() (int, error) {
+	return 0, errors.New("counting open file descriptors is not supported on this platform")
+}
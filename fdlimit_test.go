@@ -0,0 +1,57 @@
+package connmgr
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestFDLimitWatermarks(t *testing.T) {
+	limit, err := fdLimit()
+	if err != nil {
+		t.Skip(err)
+	}
+	if limit > math.MaxInt32 {
+		limit = math.MaxInt32
+	}
+
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 2, 0, ps, map[protocol.ID]int{}, WithFDLimitWatermarks(0.5, 0.4))
+	defer cm.Close()
+
+	info := cm.GetInfo()
+	if info.HighWater != int(float64(limit)*0.5) || info.LowWater != int(float64(limit)*0.4) {
+		t.Fatalf("unexpected watermarks %d/%d for a limit of %d", info.LowWater, info.HighWater, limit)
+	}
+}
+
+func TestFDExhaustionTrim(t *testing.T) {
+	if _, err := openFDs(); err != nil {
+		t.Skip(err)
+	}
+
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	// any open descriptor exceeds such a fraction of the limit.
+	cm := NewConnManager(5, 20, time.Hour, ps, map[protocol.ID]int{},
+		WithTrimInterval(10*time.Millisecond),
+		WithFDExhaustionTrim(1e-12),
+	)
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 10; i++ {
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cm.GetInfo().ConnCount != 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a trim down to 5 connections below the high watermark, got %d", cm.GetInfo().ConnCount)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
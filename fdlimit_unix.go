@@ -0,0 +1,40 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package connmgr
+
+import (
+	"os"
+	"syscall"
+)
+
+// fdLimit returns the soft RLIMIT_NOFILE of the process.
+func fdLimit() (uint64, error) {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return 0, err
+	}
+	return uint64(rlimit.Cur), nil
+}
+
+// openFDs returns the number of file descriptors open in the process, as listed in
+// /proc/self/fd or, failing that, /dev/fd.
+func openFDs() (int, error) {
+	var lastErr error
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		f, err := os.Open(dir)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// do not count the descriptor used to list the directory.
+		return len(names) - 1, nil
+	}
+	return 0, lastErr
+}
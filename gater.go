@@ -0,0 +1,127 @@
+package connmgr
+
+import (
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// DisconnectReason mirrors the control.DisconnectReason of later libp2p releases,
+// returned by Gater.InterceptUpgraded along with refusals.
+type DisconnectReason int
+
+// Gater refuses connections the connection manager would close or trim right away,
+// before they are established: connections of banned peers and of peers in a rejecting
+// reconnect cooldown, inbound connections beyond the high watermark, and inbound
+// connections from IP addresses over the limit set with WithMaxConnsPerIP.
+// Allowlisted peers and addresses are never refused on watermark or IP grounds.
+//
+// Gater implements the methods of the connmgr.ConnectionGater interface of later libp2p
+// releases, which this release predates, so that it can be wired into such a gater.
+type Gater struct {
+	cm *PhoreConnMgr
+}
+
+// Gater returns a connection gater consulting this connection manager.
+func (cm *PhoreConnMgr) Gater() *Gater {
+	return &Gater{cm: cm}
+}
+
+// InterceptPeerDial refuses to dial banned peers, and peers in a rejecting reconnect
+// cooldown.
+func (g *Gater) InterceptPeerDial(p peer.ID) (allow bool) {
+	return !g.cm.IsBanned(p) && g.cm.InterceptReconnect(p)
+}
+
+// InterceptAddrDial refuses to dial banned peers, whatever the address.
+func (g *Gater) InterceptAddrDial(p peer.ID, addr ma.Multiaddr) (allow bool) {
+	return !g.cm.IsBanned(p)
+}
+
+// InterceptAccept refuses inbound connections from IP addresses already holding as
+// many connections as allowed by WithMaxConnsPerIP.
+func (g *Gater) InterceptAccept(addrs network.ConnMultiaddrs) (allow bool) {
+	addr := addrs.RemoteMultiaddr()
+	if g.cm.allowAddrs.matches(addr) {
+		return true
+	}
+	if g.cm.overIPLimit(addr) {
+		log.Debug("refusing inbound connection over the per-IP limit: ", addr)
+		return false
+	}
+	return true
+}
+
+// InterceptSecured refuses connections with banned peers and peers in a rejecting
+// reconnect cooldown, as well as inbound connections while the count is at or above
+// the high watermark.
+func (g *Gater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) (allow bool) {
+	cm := g.cm
+	if cm.IsBanned(p) || !cm.InterceptReconnect(p) {
+		return false
+	}
+	if dir != network.DirInbound {
+		return true
+	}
+	if _, ok := cm.allowPeers[p]; ok || cm.allowAddrs.matches(addrs.RemoteMultiaddr()) {
+		return true
+	}
+	if _, hi := cm.watermarks(); hi > 0 && cm.count() >= hi {
+		log.Debug("refusing inbound connection at the high watermark: ", p)
+		return false
+	}
+	return true
+}
+
+// InterceptUpgraded allows all upgraded connections, the decision having been made at
+// earlier stages.
+func (g *Gater) InterceptUpgraded(c network.Conn) (allow bool, reason DisconnectReason) {
+	return true, 0
+}
+
+// trackIP accounts for a new connection from addr, if connections per IP are limited.
+func (cm *PhoreConnMgr) trackIP(addr ma.Multiaddr) {
+	if cm.maxConnsPerIP <= 0 {
+		return
+	}
+	ip, ok := ipOf(addr)
+	if !ok {
+		return
+	}
+	cm.ipMu.Lock()
+	cm.ipConns[ip.String()]++
+	cm.ipMu.Unlock()
+}
+
+// untrackIP reverts trackIP.
+func (cm *PhoreConnMgr) untrackIP(addr ma.Multiaddr) {
+	if cm.maxConnsPerIP <= 0 {
+		return
+	}
+	ip, ok := ipOf(addr)
+	if !ok {
+		return
+	}
+	key := ip.String()
+	cm.ipMu.Lock()
+	if cm.ipConns[key]--; cm.ipConns[key] <= 0 {
+		delete(cm.ipConns, key)
+	}
+	cm.ipMu.Unlock()
+}
+
+// overIPLimit reports whether the IP address of addr already holds as many
+// connections as allowed by WithMaxConnsPerIP.
+func (cm *PhoreConnMgr) overIPLimit(addr ma.Multiaddr) bool {
+	if cm.maxConnsPerIP <= 0 {
+		return false
+	}
+	ip, ok := ipOf(addr)
+	if !ok {
+		return false
+	}
+	cm.ipMu.Lock()
+	defer cm.ipMu.Unlock()
+	return cm.ipConns[ip.String()] >= cm.maxConnsPerIP
+}
@@ -0,0 +1,66 @@
+package connmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	tu "github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestGater(t *testing.T) {
+	friend := tu.RandPeerIDFatal(t)
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 3, 0, ps, map[protocol.ID]int{}, WithMaxConnsPerIP(2),
+		WithAllowlist([]peer.ID{friend}, nil, nil))
+	defer cm.Close()
+	not := cm.Notifee()
+	g := cm.Gater()
+
+	// two connections from the same host, the limit.
+	crowded := addrConn(t, "/ip4/10.0.0.1/tcp/1")
+	not.Connected(nil, crowded)
+	not.Connected(nil, &tconn{peer: tu.RandPeerIDFatal(t), addr: ma.StringCast("/ip4/10.0.0.1/tcp/2")})
+	if g.InterceptAccept(addrConn(t, "/ip4/10.0.0.1/tcp/3")) {
+		t.Fatal("expected connections over the per-IP limit to be refused")
+	}
+	if !g.InterceptAccept(addrConn(t, "/ip4/10.0.0.2/tcp/1")) {
+		t.Fatal("expected connections from other hosts to be accepted")
+	}
+	not.Disconnected(nil, crowded)
+	if !g.InterceptAccept(addrConn(t, "/ip4/10.0.0.1/tcp/3")) {
+		t.Fatal("expected the host to be accepted again once under the limit")
+	}
+
+	// at the high watermark, inbound connections are refused, but not outbound ones.
+	not.Connected(nil, addrConn(t, "/ip4/10.0.0.3/tcp/1"))
+	not.Connected(nil, addrConn(t, "/ip4/10.0.0.4/tcp/1"))
+	newcomer := addrConn(t, "/ip4/10.0.0.5/tcp/1")
+	if g.InterceptSecured(network.DirInbound, newcomer.RemotePeer(), newcomer) {
+		t.Fatal("expected inbound connections to be refused at the high watermark")
+	}
+	if !g.InterceptSecured(network.DirOutbound, newcomer.RemotePeer(), newcomer) {
+		t.Fatal("expected outbound connections to be allowed")
+	}
+	if !g.InterceptSecured(network.DirInbound, friend, newcomer) {
+		t.Fatal("expected allowlisted peers to be allowed")
+	}
+
+	// banned peers are refused altogether.
+	banned := tu.RandPeerIDFatal(t)
+	cm.BanPeer(banned, time.Hour, "test")
+	if g.InterceptPeerDial(banned) || g.InterceptAddrDial(banned, newcomer.RemoteMultiaddr()) || g.InterceptSecured(network.DirOutbound, banned, newcomer) {
+		t.Fatal("expected banned peers to be refused")
+	}
+	if !g.InterceptPeerDial(newcomer.RemotePeer()) {
+		t.Fatal("expected other peers to be dialed")
+	}
+	if allow, _ := g.InterceptUpgraded(newcomer); !allow {
+		t.Fatal("expected upgraded connections to be allowed")
+	}
+}
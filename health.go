@@ -0,0 +1,87 @@
+package connmgr
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// DefaultOverloadedAfter is how long the count must stay above the high watermark for
+// Health to report an overload, unless configured otherwise through
+// WithOverloadedAfter.
+const DefaultOverloadedAfter = 5 * time.Minute
+
+// Health is the status reported by Health, for node health checks.
+type Health struct {
+	// Background is set while the background loop trimming connections is running.
+	Background bool
+
+	// BackgroundDisabled is set when the background loop was disabled through
+	// WithTrimInterval, leaving trims to explicit calls to TrimOpenConns.
+	BackgroundDisabled bool
+
+	// LastTrim is when the last trim completed, zero if none did.
+	LastTrim time.Time
+
+	// SinceLastTrim is the time elapsed since LastTrim, zero if no trim completed.
+	SinceLastTrim time.Duration
+
+	// OverHighWaterSince is when the background loop first found the count above the
+	// high watermark, zero if it is not above it.
+	OverHighWaterSince time.Time
+
+	// Overloaded is set once the count has stayed above the high watermark for longer
+	// than configured with WithOverloadedAfter, meaning trims fail to bring it down.
+	Overloaded bool
+
+	// UnmetMinimums are the protocols whose minimum number of peers is not met.
+	UnmetMinimums []protocol.ID
+}
+
+// Healthy reports whether the background loop is running, unless it was disabled, the
+// connection manager is not overloaded, and all protocol minimums are met.
+func (h Health) Healthy() bool {
+	return (h.Background || h.BackgroundDisabled) && !h.Overloaded && len(h.UnmetMinimums) == 0
+}
+
+// Health reports the status of the connection manager.
+func (cm *PhoreConnMgr) Health() Health {
+	now := cm.clock.Now()
+	h := Health{
+		Background:         atomic.LoadInt32(&cm.backgroundRunning) == 1,
+		BackgroundDisabled: cm.trimInterval <= 0,
+		LastTrim:           cm.getLastTrim(),
+	}
+	if !h.LastTrim.IsZero() {
+		h.SinceLastTrim = now.Sub(h.LastTrim)
+	}
+	if since := atomic.LoadInt64(&cm.overloadedSince); since != 0 {
+		h.OverHighWaterSince = time.Unix(0, since)
+		after := cm.overloadedAfter
+		if after <= 0 {
+			after = DefaultOverloadedAfter
+		}
+		h.Overloaded = now.Sub(h.OverHighWaterSince) > after
+	}
+	for proto, pc := range cm.protocolCounts() {
+		if !pc.Met() {
+			h.UnmetMinimums = append(h.UnmetMinimums, proto)
+		}
+	}
+	sort.Slice(h.UnmetMinimums, func(i, j int) bool {
+		return h.UnmetMinimums[i] < h.UnmetMinimums[j]
+	})
+	return h
+}
+
+// trackOverload records when the count went above hi, or clears the record if it is no
+// longer above it. It is called by the background loop.
+func (cm *PhoreConnMgr) trackOverload(hi int) {
+	if hi <= 0 || cm.count() <= hi {
+		atomic.StoreInt64(&cm.overloadedSince, 0)
+		return
+	}
+	atomic.CompareAndSwapInt64(&cm.overloadedSince, 0, cm.clock.Now().UnixNano())
+}
@@ -0,0 +1,84 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestHealth(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	// the background loop does not tick within the test, its checks are run by hand.
+	cm := NewConnManager(1, 2, time.Hour, ps, map[protocol.ID]int{
+		"/phore/1.0.0": 1,
+	}, WithClock(clock), WithTrimInterval(24*time.Hour), WithOverloadedAfter(5*time.Minute))
+	not := cm.Notifee()
+
+	h := cm.Health()
+	if !h.Background || !h.LastTrim.IsZero() || h.SinceLastTrim != 0 || h.Overloaded {
+		t.Fatalf("unexpected initial health: %+v", h)
+	}
+	if len(h.UnmetMinimums) != 1 || h.UnmetMinimums[0] != "/phore/1.0.0" || h.Healthy() {
+		t.Fatalf("expected the protocol minimum to be unmet: %+v", h)
+	}
+
+	for i := 0; i < 3; i++ {
+		rc := randConn(t, nil)
+		not.Connected(nil, rc)
+		if err := ps.AddProtocols(rc.RemotePeer(), "/phore/1.0.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := clock.Now()
+	cm.trackOverload(2)
+	cm.TrimOpenConns(context.Background())
+	clock.Add(time.Minute)
+
+	h = cm.Health()
+	if !h.LastTrim.Equal(start) || h.SinceLastTrim != time.Minute {
+		t.Errorf("unexpected last trim: %+v", h)
+	}
+	if !h.OverHighWaterSince.Equal(start) || h.Overloaded || !h.Healthy() {
+		t.Errorf("expected to be above the high watermark, but not yet overloaded: %+v", h)
+	}
+
+	clock.Add(5 * time.Minute)
+	cm.trackOverload(2)
+	if h = cm.Health(); !h.Overloaded || !h.OverHighWaterSince.Equal(start) || h.Healthy() {
+		t.Errorf("expected to be overloaded: %+v", h)
+	}
+
+	cm.SetWatermarks(5, 10)
+	cm.trackOverload(10)
+	if h = cm.Health(); !h.OverHighWaterSince.IsZero() || h.Overloaded {
+		t.Errorf("expected the overload to be over: %+v", h)
+	}
+
+	cm.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for cm.Health().Background {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the background loop to stop")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHealthWithoutBackgroundLoop(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 2, time.Hour, ps, map[protocol.ID]int{}, WithTrimInterval(0))
+	defer cm.Close()
+
+	h := cm.Health()
+	if h.Background || !h.BackgroundDisabled || !h.Healthy() {
+		t.Fatalf("expected a manager without background loop to be healthy: %+v", h)
+	}
+	if !cm.Stats().Healthy {
+		t.Fatal("expected the stats to report the manager as healthy")
+	}
+}
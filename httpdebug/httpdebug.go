@@ -0,0 +1,197 @@
+// Package httpdebug serves the state of a connmgr.PhoreConnMgr over HTTP, for mounting
+// on the debug mux of a node.
+//
+// The handler serves, relative to where it is mounted:
+//
+//	GET  /state       the State document of the connection manager
+//	GET  /stats       the Stats document of the connection manager
+//	GET  /peers       the tracked peers, with their scores and pruning rank
+//	GET  /protected   the protected peers and protocols
+//	POST /trim        a trim, or with ?dry=1 the connections a trim would close
+//
+// Mount it under a prefix with http.StripPrefix, such as:
+//
+//	mux.Handle("/debug/connmgr/", http.StripPrefix("/debug/connmgr", httpdebug.New(cm)))
+package httpdebug
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	connmgr "github.com/phoreproject/go-phore-connmgr"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// Peer is an entry of the peer list served at /peers.
+type Peer struct {
+	connmgr.PeerState
+
+	// Score is the score trims order the peer by, and Rank its position in the pruning
+	// order, starting at 1. Both are only set for peers trims may prune.
+	Score float64 `json:"score,omitempty"`
+	Rank  int     `json:"rank,omitempty"`
+
+	// Protected are the tags the peer is protected under.
+	Protected []string `json:"protected,omitempty"`
+}
+
+// Protected is the document served at /protected.
+type Protected struct {
+	Peers     map[peer.ID][]string `json:"peers"`
+	Protocols []protocol.ID        `json:"protocols"`
+}
+
+// Candidate is a connection a dry run of /trim found a trim would close.
+type Candidate struct {
+	Peer   peer.ID             `json:"peer"`
+	Addr   string              `json:"addr"`
+	Value  int                 `json:"value"`
+	Score  float64             `json:"score"`
+	Reason connmgr.CloseReason `json:"reason"`
+}
+
+// TrimResult is the document served by /trim.
+type TrimResult struct {
+	// DryRun is set when nothing was closed.
+	DryRun bool `json:"dryRun"`
+
+	// Closed is the number of connections the trim closed, and Peers the peers they
+	// belonged to. They are not set for dry runs.
+	Closed int       `json:"closed"`
+	Peers  []peer.ID `json:"peers,omitempty"`
+
+	// Candidates are the connections a trim would close, in order. They are only set
+	// for dry runs.
+	Candidates []Candidate `json:"candidates,omitempty"`
+
+	// Error tells why the trim did not run, or was cut short.
+	Error string `json:"error,omitempty"`
+}
+
+// Handler is the http.Handler returned by New.
+type Handler struct {
+	cm  *connmgr.PhoreConnMgr
+	mux *http.ServeMux
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+// New returns a handler serving the state of cm.
+func New(cm *connmgr.PhoreConnMgr) *Handler {
+	h := &Handler{cm: cm, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/state", h.get(h.state))
+	h.mux.HandleFunc("/stats", h.get(h.stats))
+	h.mux.HandleFunc("/peers", h.get(h.peers))
+	h.mux.HandleFunc("/protected", h.get(h.protected))
+	h.mux.HandleFunc("/trim", h.trim)
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// get serves the document returned by f to GET requests.
+func (h *Handler) get(f func() interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, f())
+	}
+}
+
+func (h *Handler) state() interface{} {
+	return h.cm.State()
+}
+
+func (h *Handler) stats() interface{} {
+	return h.cm.Stats()
+}
+
+func (h *Handler) peers() interface{} {
+	st := h.cm.State()
+	ranked := h.cm.PrunableCandidates(len(st.Peers))
+	ranks := make(map[peer.ID]int, len(ranked))
+	scores := make(map[peer.ID]float64, len(ranked))
+	for _, c := range ranked {
+		if _, ok := ranks[c.Peer]; !ok {
+			ranks[c.Peer] = len(ranks) + 1
+			scores[c.Peer] = c.Score
+		}
+	}
+
+	peers := make([]Peer, 0, len(st.Peers))
+	for _, ps := range st.Peers {
+		peers = append(peers, Peer{
+			PeerState: ps,
+			Score:     scores[ps.ID],
+			Rank:      ranks[ps.ID],
+			Protected: st.Protected[ps.ID],
+		})
+	}
+	return peers
+}
+
+func (h *Handler) protected() interface{} {
+	st := h.cm.State()
+	p := Protected{Peers: st.Protected, Protocols: st.ProtectedProtocols}
+	if p.Peers == nil {
+		p.Peers = map[peer.ID][]string{}
+	}
+	if p.Protocols == nil {
+		p.Protocols = []protocol.ID{}
+	}
+	return p
+}
+
+// trim runs a trim for POST requests, or only previews it when the dry parameter is
+// set to a true value.
+func (h *Handler) trim(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var res TrimResult
+	switch r.URL.Query().Get("dry") {
+	case "", "0", "false":
+		tr, err := h.cm.TrimOpenConnsResult(r.Context())
+		if err != nil {
+			res.Error = err.Error()
+		}
+		res.Closed, res.Peers = tr.Closed, tr.Peers
+	default:
+		res.DryRun = true
+		res.Candidates = []Candidate{}
+		for _, c := range h.cm.PreviewTrim(r.Context()) {
+			cand := Candidate{Peer: c.Peer, Value: c.Value, Score: c.Score, Reason: c.Reason}
+			if addr := c.Conn.RemoteMultiaddr(); addr != nil {
+				cand.Addr = addr.String()
+			}
+			res.Candidates = append(res.Candidates, cand)
+		}
+	}
+	writeJSON(w, res)
+}
+
+// writeJSON encodes v in full before writing anything, so that encoding errors can
+// still be reported with an error status.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	buf.WriteTo(w)
+}
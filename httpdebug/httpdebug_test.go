@@ -0,0 +1,146 @@
+package httpdebug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	connmgr "github.com/phoreproject/go-phore-connmgr"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	tu "github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+type tconn struct {
+	network.Conn
+
+	peer   peer.ID
+	closed bool
+	notify network.Notifiee
+}
+
+func (c *tconn) Stat() network.Stat {
+	return network.Stat{Direction: network.DirInbound}
+}
+
+func (c *tconn) Close() error {
+	c.closed = true
+	c.notify.Disconnected(nil, c)
+	return nil
+}
+
+func (c *tconn) RemotePeer() peer.ID {
+	return c.peer
+}
+
+func (c *tconn) RemoteMultiaddr() ma.Multiaddr {
+	return ma.StringCast("/ip4/127.0.0.1/tcp/4001")
+}
+
+func do(t *testing.T, h http.Handler, method, target string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %s", method, target, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestHandler(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := connmgr.NewConnManager(1, 2, 0, ps, map[protocol.ID]int{}, connmgr.WithSilencePeriod(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var conns []*tconn
+	for i := 0; i < 3; i++ {
+		c := &tconn{peer: tu.RandPeerIDFatal(t), notify: not}
+		conns = append(conns, c)
+		not.Connected(nil, c)
+		cm.TagPeer(c.peer, "score", i+1)
+	}
+	cm.Protect(conns[2].peer, "keep")
+	cm.ProtectProtocol("/sync/1.0.0")
+	h := New(cm)
+
+	var st connmgr.State
+	if code := do(t, h, http.MethodGet, "/state", &st); code != http.StatusOK || len(st.Peers) != 3 {
+		t.Fatalf("unexpected state: %d %+v", code, st)
+	}
+
+	var stats connmgr.Stats
+	if code := do(t, h, http.MethodGet, "/stats", &stats); code != http.StatusOK || stats.Connections != 3 {
+		t.Fatalf("unexpected stats: %d %+v", code, stats)
+	}
+
+	var peers []Peer
+	if code := do(t, h, http.MethodGet, "/peers", &peers); code != http.StatusOK || len(peers) != 3 {
+		t.Fatalf("unexpected peers: %d %+v", code, peers)
+	}
+	for _, p := range peers {
+		switch p.ID {
+		case conns[0].peer:
+			if p.Rank != 1 || p.Score != 1 {
+				t.Errorf("expected the lowest peer to rank first, got %+v", p)
+			}
+		case conns[1].peer:
+			if p.Rank != 2 || p.Score != 2 {
+				t.Errorf("expected the second peer to rank second, got %+v", p)
+			}
+		case conns[2].peer:
+			if p.Rank != 0 || len(p.Protected) != 1 || p.Protected[0] != "keep" {
+				t.Errorf("expected the protected peer to be unranked, got %+v", p)
+			}
+		}
+	}
+
+	var prot Protected
+	if code := do(t, h, http.MethodGet, "/protected", &prot); code != http.StatusOK || len(prot.Peers) != 1 || len(prot.Protocols) != 1 {
+		t.Fatalf("unexpected protections: %d %+v", code, prot)
+	}
+
+	if code := do(t, h, http.MethodGet, "/trim", nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected trims to require POST, got %d", code)
+	}
+	var res TrimResult
+	if code := do(t, h, http.MethodPost, "/trim?dry=1", &res); code != http.StatusOK || !res.DryRun || len(res.Candidates) != 2 {
+		t.Fatalf("unexpected dry run: %d %+v", code, res)
+	}
+	if res.Candidates[0].Peer != conns[0].peer || conns[0].closed {
+		t.Fatalf("unexpected dry run candidates %+v", res.Candidates)
+	}
+
+	res = TrimResult{}
+	if code := do(t, h, http.MethodPost, "/trim", &res); code != http.StatusOK || res.DryRun || res.Closed != 2 {
+		t.Fatalf("unexpected trim: %d %+v", code, res)
+	}
+	if !conns[0].closed || !conns[1].closed || conns[2].closed {
+		t.Fatal("expected the trim to close the unprotected connections")
+	}
+}
+
+func TestWriteJSONError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	// channels cannot be encoded.
+	writeJSON(rec, map[string]interface{}{"fine": 1, "broken": make(chan int)})
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected an internal server error, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct == "application/json" {
+		t.Fatal("expected the error not to be served as JSON")
+	}
+	if body := rec.Body.String(); strings.Contains(body, "fine") {
+		t.Fatalf("expected no partial document in the response, got %q", body)
+	}
+}
@@ -0,0 +1,74 @@
+// Package leveldbstore implements a connmgr.ReputationStore backed by LevelDB.
+package leveldbstore
+
+import (
+	"encoding/json"
+
+	connmgr "github.com/phoreproject/go-phore-connmgr"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Store is a connmgr.ReputationStore keeping one LevelDB record per peer, keyed by
+// peer ID and holding the JSON encoded connmgr.PeerRecord.
+type Store struct {
+	db *leveldb.DB
+}
+
+var _ connmgr.ReputationStore = (*Store)(nil)
+
+// New opens, or creates, the LevelDB database at path.
+func New(path string) (*Store, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Load returns the records saved last.
+func (s *Store) Load() (map[peer.ID]connmgr.PeerRecord, error) {
+	records := make(map[peer.ID]connmgr.PeerRecord)
+
+	it := s.db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		var rec connmgr.PeerRecord
+		if err := json.Unmarshal(it.Value(), &rec); err != nil {
+			return nil, err
+		}
+		records[peer.ID(it.Key())] = rec
+	}
+	return records, it.Error()
+}
+
+// Save atomically replaces the saved records with records.
+func (s *Store) Save(records map[peer.ID]connmgr.PeerRecord) error {
+	batch := new(leveldb.Batch)
+
+	it := s.db.NewIterator(nil, nil)
+	for it.Next() {
+		if _, ok := records[peer.ID(it.Key())]; !ok {
+			batch.Delete(append([]byte(nil), it.Key()...))
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	for p, rec := range records {
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		batch.Put([]byte(p), b)
+	}
+	return s.db.Write(batch, nil)
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
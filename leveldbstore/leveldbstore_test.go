@@ -0,0 +1,54 @@
+package leveldbstore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	connmgr "github.com/phoreproject/go-phore-connmgr"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldbstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := time.Unix(1500000000, 0).UTC()
+	if err := s.Save(map[peer.ID]connmgr.PeerRecord{
+		"a": {Tags: map[string]int{"score": 3}, FirstSeen: seen},
+		"b": {Tags: map[string]int{"score": 5}, FirstSeen: seen},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// saving replaces the previous records.
+	if err := s.Save(map[peer.ID]connmgr.PeerRecord{
+		"a": {Tags: map[string]int{"score": 4}, FirstSeen: seen},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	records, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records["a"].Tags["score"] != 4 || !records["a"].FirstSeen.Equal(seen) {
+		t.Fatalf("unexpected records %+v", records)
+	}
+}
@@ -0,0 +1,32 @@
+package connmgr
+
+import "sync/atomic"
+
+// lowPeersThresholdNow returns the threshold below which the low peer callback fires.
+func (cm *PhoreConnMgr) lowPeersThresholdNow() int {
+	if cm.lowPeersThreshold > 0 {
+		return cm.lowPeersThreshold
+	}
+	low, _ := cm.watermarks()
+	return low
+}
+
+// checkLowPeers runs the low peer callback if the count just dropped below its
+// threshold. It must be called without holding any locks.
+func (cm *PhoreConnMgr) checkLowPeers() {
+	if cm.lowPeers == nil {
+		return
+	}
+	threshold := cm.lowPeersThresholdNow()
+	if count := cm.count(); count < threshold && atomic.CompareAndSwapInt32(&cm.lowPeersFired, 0, 1) {
+		cm.lowPeers(count, threshold)
+	}
+}
+
+// rearmLowPeers lets the low peer callback fire again once the count is back at or
+// above its threshold.
+func (cm *PhoreConnMgr) rearmLowPeers() {
+	if cm.lowPeers != nil && cm.count() >= cm.lowPeersThresholdNow() {
+		atomic.StoreInt32(&cm.lowPeersFired, 0)
+	}
+}
@@ -0,0 +1,99 @@
+package connmgr
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestLowPeerCallback(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	var (
+		cm    *PhoreConnMgr
+		calls [][2]int
+	)
+	cm = NewConnManager(3, 10, 0, ps, map[protocol.ID]int{}, WithLowPeerCallback(0, func(count, threshold int) {
+		// the callback runs without locks held, so calling back in must not deadlock.
+		if n := len(cm.Peers()); n != count {
+			t.Errorf("expected %d tracked peers, got %d", count, n)
+		}
+		calls = append(calls, [2]int{count, threshold})
+	}))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var conns []network.Conn
+	for i := 0; i < 4; i++ {
+		rc := randConn(t, nil)
+		conns = append(conns, rc)
+		not.Connected(nil, rc)
+	}
+
+	not.Disconnected(nil, conns[0])
+	if len(calls) != 0 {
+		t.Fatalf("expected no call at the low watermark, got %v", calls)
+	}
+	not.Disconnected(nil, conns[1])
+	not.Disconnected(nil, conns[2])
+	if len(calls) != 1 || calls[0] != [2]int{2, 3} {
+		t.Fatalf("expected a single call when dropping below the low watermark, got %v", calls)
+	}
+
+	for i := 0; i < 2; i++ {
+		not.Connected(nil, randConn(t, nil))
+	}
+	not.Disconnected(nil, conns[3])
+	if len(calls) != 2 || calls[1] != [2]int{2, 3} {
+		t.Fatalf("expected another call after recovering, got %v", calls)
+	}
+}
+
+func TestMinPeers(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	bus := new(recordingBus)
+	cm := NewConnManager(5, 10, 0, ps, map[protocol.ID]int{}, WithClock(newMockClock()), WithMinPeers(3), WithEventBus(bus))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var conns []network.Conn
+	for i := 0; i < 3; i++ {
+		rc := randConn(t, nil)
+		conns = append(conns, rc)
+		not.Connected(nil, rc)
+	}
+	select {
+	case d := <-cm.NeedPeers():
+		t.Fatalf("expected no demand at the minimum, got %+v", d)
+	default:
+	}
+
+	not.Disconnected(nil, conns[0])
+	not.Disconnected(nil, conns[1])
+	select {
+	case d := <-cm.NeedPeers():
+		if d != (PeerDemand{Connected: 1, Wanted: 2}) {
+			t.Fatalf("expected the latest demand only, got %+v", d)
+		}
+	default:
+		t.Fatal("expected a demand for peers")
+	}
+
+	var demands []PeerDemand
+	for _, evt := range bus.emitted() {
+		if evt, ok := evt.(EvtNeedPeers); ok {
+			demands = append(demands, evt.PeerDemand)
+		}
+	}
+	if len(demands) != 2 || demands[0] != (PeerDemand{Connected: 2, Wanted: 1}) {
+		t.Fatalf("expected every demand to be emitted, got %+v", demands)
+	}
+
+	other := NewConnManager(5, 10, 0, ps, map[protocol.ID]int{})
+	defer other.Close()
+	if other.NeedPeers() != nil {
+		t.Error("expected no channel without a minimum")
+	}
+}
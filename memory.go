@@ -0,0 +1,69 @@
+package connmgr
+
+import (
+	"errors"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// MemoryProbe reports the memory used by the process, in bytes.
+type MemoryProbe func() (uint64, error)
+
+// HeapInUse is a MemoryProbe reporting the bytes in in-use heap spans, as reported by
+// runtime.ReadMemStats.
+func HeapInUse() (uint64, error) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapInuse, nil
+}
+
+// cgroupUsageFiles lists the files reporting the memory charged to the cgroup of the
+// process, under cgroup v2 and v1 respectively.
+var cgroupUsageFiles = []string{
+	"/sys/fs/cgroup/memory.current",
+	"/sys/fs/cgroup/memory/memory.usage_in_bytes",
+}
+
+// CgroupMemoryUsage is a MemoryProbe reporting the memory charged to the cgroup of the
+// process, which includes the page cache and is what the cgroup memory limit is
+// enforced against. It fails outside of a cgroup with memory accounting.
+func CgroupMemoryUsage() (uint64, error) {
+	for _, path := range cgroupUsageFiles {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	}
+	return 0, errors.New("no cgroup memory accounting available")
+}
+
+// checkMemory samples the memory probe set through WithMemoryPressure, and enters or
+// leaves the memory pressure state accordingly.
+func (cm *PhoreConnMgr) checkMemory() {
+	if cm.memProbe == nil {
+		return
+	}
+
+	used, err := cm.memProbe()
+	if err != nil {
+		log.Warning("cannot read memory usage: ", err)
+		return
+	}
+	if used > cm.memThreshold {
+		if atomic.CompareAndSwapInt32(&cm.memPressure, 0, 1) {
+			log.Warningf("memory usage of %d bytes above %d, lowering watermarks", used, cm.memThreshold)
+		}
+	} else if atomic.CompareAndSwapInt32(&cm.memPressure, 1, 0) {
+		log.Infof("memory usage of %d bytes back below %d, restoring watermarks", used, cm.memThreshold)
+	}
+}
+
+// underMemoryPressure reports whether memory usage was above the threshold set through
+// WithMemoryPressure when last sampled.
+func (cm *PhoreConnMgr) underMemoryPressure() bool {
+	return atomic.LoadInt32(&cm.memPressure) == 1
+}
@@ -0,0 +1,58 @@
+package connmgr
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestMemoryPressure(t *testing.T) {
+	var used uint64
+	probe := func() (uint64, error) { return atomic.LoadUint64(&used), nil }
+
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{},
+		WithTrimInterval(10*time.Millisecond),
+		WithSilencePeriod(0),
+		WithMemoryPressure(probe, 1000, 0.5),
+	)
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 15; i++ {
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+
+	waitFor := func(cond func() bool, msg string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal(msg)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// below the high watermark and the memory threshold, nothing happens.
+	time.Sleep(50 * time.Millisecond)
+	if n := cm.GetInfo().ConnCount; n != 15 {
+		t.Fatalf("expected no trim, got %d connections", n)
+	}
+
+	atomic.StoreUint64(&used, 2000)
+	waitFor(func() bool { return cm.GetInfo().ConnCount == 5 }, "expected a trim down to the lowered low watermark")
+	if info := cm.GetInfo(); info.LowWater != 5 || info.HighWater != 10 {
+		t.Fatalf("expected the watermarks to be halved, got %d/%d", info.LowWater, info.HighWater)
+	}
+
+	atomic.StoreUint64(&used, 500)
+	waitFor(func() bool { return cm.GetInfo().HighWater == 20 }, "expected the watermarks to be restored")
+	if info := cm.GetInfo(); info.LowWater != 10 {
+		t.Fatalf("expected the low watermark to be restored, got %d", info.LowWater)
+	}
+}
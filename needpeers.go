@@ -0,0 +1,49 @@
+package connmgr
+
+import "sync/atomic"
+
+// PeerDemand is signalled while fewer peers than the minimum set with WithMinPeers are
+// connected.
+type PeerDemand struct {
+	// Connected is the number of connected peers.
+	Connected int
+
+	// Wanted is the number of additional peers needed to reach the minimum.
+	Wanted int
+}
+
+// NeedPeers returns the channel on which the demand for peers is signalled, see
+// WithMinPeers. The channel only holds the latest demand: a stale one is replaced
+// rather than queued. It returns nil if no minimum is configured.
+func (cm *PhoreConnMgr) NeedPeers() <-chan PeerDemand {
+	if cm.needPeers == nil {
+		return nil
+	}
+	return cm.needPeers
+}
+
+// checkNeedPeers signals the demand for peers if fewer than the minimum are connected.
+// It must be called without holding any locks.
+func (cm *PhoreConnMgr) checkNeedPeers() {
+	if cm.minPeers <= 0 {
+		return
+	}
+	connected := int(atomic.LoadInt32(&cm.peerCount))
+	if connected >= cm.minPeers {
+		return
+	}
+	demand := PeerDemand{Connected: connected, Wanted: cm.minPeers - connected}
+
+	// replace the demand not consumed yet, if any.
+	select {
+	case <-cm.needPeers:
+	default:
+	}
+	select {
+	case cm.needPeers <- demand:
+	default:
+	}
+	if cm.events != nil {
+		cm.events.need.Emit(EvtNeedPeers{PeerDemand: demand})
+	}
+}
@@ -0,0 +1,755 @@
+package connmgr
+
+import (
+	"container/list"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option tunes optional behaviour of a PhoreConnMgr. Options are passed to
+// NewConnManager and applied before the background goroutine is started.
+type Option func(cm *PhoreConnMgr)
+
+// ProtocolAccounting selects which tracked peers are counted toward the per-protocol
+// minimums passed to NewConnManager.
+type ProtocolAccounting int
+
+const (
+	// CountConnected counts only peers holding at least one live connection. Temporary
+	// entries created by early tags are never counted. This is the default.
+	CountConnected ProtocolAccounting = iota
+
+	// CountEstablished counts only connected peers that are past their grace period.
+	// Peers still in their grace period are kept by the trim anyway, so this reserves
+	// established peers on top of them.
+	CountEstablished
+
+	// CountAllTracked counts every tracked peer advertising the protocol, including
+	// temporary entries with no connections. This was the behaviour of earlier
+	// versions of this package.
+	CountAllTracked
+)
+
+// WithProtocolAccounting selects which peers count toward protocol minimums.
+func WithProtocolAccounting(a ProtocolAccounting) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.protocolAccounting = a
+	}
+}
+
+// WithProtocolMaximums caps the number of connected peers supporting each of the given
+// protocols. Trims evict the lowest scoring peers of a protocol over its maximum, even
+// when the connection count is below the watermarks, and the background loop runs a
+// trim on every tick to do so. Protected peers, peers in their grace period and peers
+// reserved for protocol minimums count toward the maximums but are never evicted.
+func WithProtocolMaximums(maximums map[protocol.ID]int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.maximumPeersForProtocol = maximums
+	}
+}
+
+// WithDialHints registers a handler that receives, after each trim, the protocols
+// whose retained peers fall short of the configured minimum, or whose best retained
+// peer has a value below threshold. The handler is not called when there are no hints.
+func WithDialHints(threshold int, handler func([]DialHint)) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.dialHintThreshold = threshold
+		cm.dialHints = handler
+	}
+}
+
+// WithTrimInterval sets how often the background loop checks the connection count
+// against the high watermark, one minute by default. An interval of zero or less
+// disables the background loop altogether, leaving trims to explicit calls to
+// TrimOpenConns.
+func WithTrimInterval(interval time.Duration) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.trimInterval = interval
+	}
+}
+
+// WithTrimHysteresis keeps the background loop from trimming as soon as the count
+// exceeds the high watermark, which makes the count oscillate when it hovers around it.
+// A trim is only triggered once the count has stayed above the high watermark for at
+// least delay, or exceeds it by more than margin. Non-positive values disable the
+// respective condition; when both are disabled, the default, the loop trims as soon as
+// the count exceeds the high watermark. Explicit calls to TrimOpenConns and emergency
+// trims are not affected.
+func WithTrimHysteresis(delay time.Duration, margin int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.hysteresisDelay = delay
+		cm.hysteresisMargin = margin
+	}
+}
+
+// WithReservedOutboundSlots keeps n slots below the high watermark free for the
+// outbound dials the node initiates: whenever the inbound connections (or inbound
+// peers, with PeerBasis) exceed the high watermark minus n, trims evict the lowest
+// scoring inbound peers, even when the count is below the watermarks, and the
+// background loop runs a trim on every tick to do so. Protected peers and peers in
+// their grace period count toward the inbound connections but are never evicted.
+func WithReservedOutboundSlots(n int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.reservedOutbound = n
+	}
+}
+
+// WithSegments shards tracked peers into count segments, each guarded by its own lock,
+// addressing them through hash. More segments reduce lock contention on nodes with
+// many peers, fewer segments reduce memory use on small nodes. A count of zero or
+// less selects DefaultSegmentCount, and a nil hash picks LastByteHash for up to 256
+// segments and FNVHash beyond that.
+func WithSegments(count int, hash SegmentHash) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.segments = newSegments(count, hash)
+	}
+}
+
+// WithSilencePeriod sets the minimum time between two trims, overriding the
+// package-level SilencePeriod.
+func WithSilencePeriod(period time.Duration) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.silencePeriod = period
+	}
+}
+
+// WithFDLimitWatermarks derives the watermarks from the soft RLIMIT_NOFILE of the
+// process instead of the values passed to NewConnManager: the high watermark is set to
+// highFraction of the limit, and the low watermark to lowFraction of it (e.g. 0.5 and
+// 0.4). The limit is read at startup and re-read by the background loop, so the
+// watermarks follow changes to the limit. On platforms without file descriptor limits
+// the watermarks passed to NewConnManager are kept.
+func WithFDLimitWatermarks(highFraction, lowFraction float64) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.fdHighFraction = highFraction
+		cm.fdLowFraction = lowFraction
+	}
+}
+
+// WithFDExhaustionTrim makes the background loop count the file descriptors open in the
+// process, and trim down to the low watermark as soon as they exceed fraction (e.g.
+// 0.9) of the soft RLIMIT_NOFILE, even below the high watermark, ignoring the silence
+// and grace periods. This keeps descriptors available for the rest of the node, which
+// may hold many besides connections. Trims never go below the low watermark: if the
+// descriptors run out with fewer connections than that, the option does nothing, and
+// the watermarks (or WithFDLimitWatermarks) are what must be lowered. On platforms
+// where open descriptors cannot be counted the option has no effect.
+func WithFDExhaustionTrim(fraction float64) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.fdExhaustionFraction = fraction
+	}
+}
+
+// WithMemoryPressure samples probe (e.g. HeapInUse or CgroupMemoryUsage) on every
+// tick of the background loop. While the reported usage exceeds threshold bytes, both
+// watermarks are scaled by factor (between 0 and 1), and the background loop trims down
+// to the lowered low watermark as soon as the count exceeds it. The watermarks are
+// restored once usage falls back to the threshold.
+func WithMemoryPressure(probe MemoryProbe, threshold uint64, factor float64) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.memProbe = probe
+		cm.memThreshold = threshold
+		cm.memFactor = factor
+	}
+}
+
+// WithCriticalWater sets a critical watermark, which should lie above the high
+// watermark. As soon as the connection count exceeds it, the connection manager trims
+// down to the low watermark without waiting for the background loop, ignoring both the
+// silence period and the grace period of new connections. Crossing only the high
+// watermark keeps triggering regular trims. Zero, the default, disables the critical
+// watermark.
+func WithCriticalWater(critical int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.criticalWater = critical
+	}
+}
+
+// WithOverloadTrim triggers an emergency trim, like the critical watermark does, as soon
+// as the count exceeds the high watermark multiplied by ratio, e.g. 2 for twice the
+// high watermark. Emergency trims bypass the silence period, so a connection flood
+// cannot exhaust file descriptors in between regular trims; they also bypass the
+// grace period of new connections if ignoreGrace is set. A ratio of zero, the
+// default, disables the overload threshold.
+func WithOverloadTrim(ratio float64, ignoreGrace bool) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.overloadRatio = ratio
+		cm.overloadIgnoresGrace = ignoreGrace
+	}
+}
+
+// WithClock replaces the wall clock used for grace periods, silence periods and the
+// background loop. It is meant for tests that need to control the passing of time.
+func WithClock(clock Clock) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.clock = clock
+	}
+}
+
+// WatermarkBasis selects what the watermarks are compared against.
+type WatermarkBasis int
+
+const (
+	// ConnectionBasis compares the watermarks against the number of open connections,
+	// and trims close connections until the connection count reaches the low
+	// watermark. This is the default.
+	ConnectionBasis WatermarkBasis = iota
+
+	// PeerBasis compares the watermarks against the number of connected peers, and
+	// trims disconnect peers until the peer count reaches the low watermark,
+	// regardless of how many connections each of them holds.
+	PeerBasis
+)
+
+// WithWatermarkBasis selects whether the watermarks apply to connections or to peers.
+func WithWatermarkBasis(basis WatermarkBasis) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.basis = basis
+	}
+}
+
+// WithMaxClosesPerTrim limits the number of connections closed by a single trim, to
+// spread the cost of large trims over several cycles. Once the budget is spent, the
+// remaining excess is left to the next trim. The connections of a peer are always
+// closed together, so a trim may close more connections than budgeted if the first
+// peer selected holds more of them. Zero, the default, means no limit.
+func WithMaxClosesPerTrim(n int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.maxClosesPerTrim = n
+	}
+}
+
+// WithMaxTempEntries bounds the number of temporary entries, which buffer tags set on
+// peers before their first connection is reported. Tagging an unknown peer once the
+// limit is reached is a no-op, so that tagging random peer IDs cannot bloat memory. A
+// limit of zero disables temporary entries altogether, and a negative limit, the
+// default, leaves them unbounded.
+func WithMaxTempEntries(n int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.maxTempEntries = n
+	}
+}
+
+// WithTrimPolicy replaces the built-in heuristic selecting the connections to close
+// during trims. See TrimPolicy.
+func WithTrimPolicy(policy TrimPolicy) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.policy = policy
+	}
+}
+
+// WithDuplicateConnPruning makes the built-in trim policy close the redundant extra
+// connections of peers holding several before evicting any peer, which lowers the
+// connection count with far less impact on the peer set. Each peer keeps its connection
+// with the most active streams. It has no effect with PeerBasis, where duplicates do
+// not count toward the watermarks.
+func WithDuplicateConnPruning() Option {
+	return func(cm *PhoreConnMgr) {
+		cm.pruneDuplicates = true
+	}
+}
+
+// WithComparator replaces the order in which the built-in trim policy considers
+// candidates: less reports whether a must be pruned before b. By default, peers are
+// ordered by ascending score, ties being broken by stream activity, the configured
+// DirectionPreference, first seen time, connection count and peer ID. The passes
+// configured through other options, such as idle detection or diversity, still apply
+// on top of this order.
+func WithComparator(less func(a, b PeerSnapshot) bool) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.comparator = less
+	}
+}
+
+// WithWeightedRandomPruning makes the built-in trim policy pick the peers to prune at
+// random instead of strictly by lowest value, so that nodes sharing a view of the
+// network don't all drop the same peers at once. The probability of a peer being
+// pruned first follows a softmax over its negated value divided by temperature: low
+// temperatures stay close to lowest-value-first, while high temperatures approach a
+// uniform choice. Zero, the default, keeps the deterministic order.
+func WithWeightedRandomPruning(temperature float64) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.pruneTemperature = temperature
+	}
+}
+
+// WithAgeBonus adds weight * curve(age) to the score of every peer during trims,
+// where age is the time since we began tracking the peer, so that long-lived peers are
+// pruned last. See LinearAgeCurve and LogAgeCurve for ready-made curves.
+func WithAgeBonus(weight float64, curve AgeCurve) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.ageWeight = weight
+		cm.ageCurve = curve
+	}
+}
+
+// GraceBasis selects what the grace period passed to NewConnManager applies to.
+type GraceBasis int
+
+const (
+	// GracePerPeer starts the grace period when we begin tracking a peer, and protects
+	// all of its connections until it ends. This is the default.
+	GracePerPeer GraceBasis = iota
+
+	// GracePerConnection starts a grace period for every connection when it is opened:
+	// a long-known peer's brand new extra connection still gets its grace period,
+	// while the older connections of the peer may be closed. With PeerBasis, peers
+	// holding any connection in its grace period are not pruned.
+	GracePerConnection
+)
+
+// WithGraceBasis selects whether the grace period applies per peer or per connection.
+func WithGraceBasis(basis GraceBasis) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.graceBasis = basis
+	}
+}
+
+// WithGraceExemptTags removes the grace period of peers carrying any of the given tags,
+// whatever their value, so that peers identified as bad actors, e.g. tagged
+// "suspicious", can be pruned right away even if they connected seconds ago.
+func WithGraceExemptTags(tags ...string) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.graceExemptTags = tags
+	}
+}
+
+// WithAllowlist exempts from trimming the peers of the given IDs, as well as the peers
+// connected through a remote address starting with one of prefixes or within one of
+// nets: they are never pruned, and count neither toward the watermarks nor toward the
+// inbound slots. A peer only partly connected through allowlisted addresses is exempt
+// as a whole, but its other connections still count toward the watermarks under
+// ConnectionBasis.
+func WithAllowlist(peers []peer.ID, prefixes []ma.Multiaddr, nets []*net.IPNet) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.allowPeers = allowlistOf(peers)
+		cm.allowAddrs = addrMatcher{prefixes: prefixes, nets: nets}
+	}
+}
+
+// WithMaxConnsPerIP limits the connections from any single remote IP address: the
+// gater returned by Gater refuses inbound connections from addresses already holding
+// max connections. Zero, the default, disables the limit.
+func WithMaxConnsPerIP(max int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.maxConnsPerIP = max
+		cm.ipConns = make(map[string]int)
+	}
+}
+
+// WithReconnectCooldown keeps a record of the peers pruned by trims for window, so
+// that those redialing right away do not get another grace period: until the window
+// ends, they are subject to pruning as soon as they reconnect and penalty is
+// subtracted from their score. With CooldownReject, InterceptReconnect also refuses
+// them, so that gaters can reject their connections altogether.
+func WithReconnectCooldown(window time.Duration, mode CooldownMode, penalty float64) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.cooldown = window
+		cm.cooldownMode = mode
+		cm.cooldownPenalty = penalty
+		cm.pruned = make(map[peer.ID]time.Time)
+	}
+}
+
+// WithTagRetention keeps the tags of a peer for window after its last connection
+// closes, instead of dropping them right away, and restores them if the peer
+// reconnects before the window ends. This preserves the reputation of flapping peers.
+// Tags set with a TTL keep expiring while the peer is disconnected.
+func WithTagRetention(window time.Duration) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.retention = window
+		cm.retained = make(map[peer.ID]*retainedPeer)
+	}
+}
+
+// WithSessionStats records the durations of the sessions of peers, from their first
+// connection opening to their last connection closing, for SessionStats and
+// PeerSessionStats. The statistics of the maxPeers peers that ended a session most
+// recently are kept individually; zero or less keeps global statistics only.
+func WithSessionStats(maxPeers int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.sessions = &sessionRecorder{
+			maxPeers: maxPeers,
+			peers:    make(map[peer.ID]*list.Element),
+			lru:      list.New(),
+		}
+	}
+}
+
+// WithReputationStore persists the tags and first seen timestamp of peers across
+// restarts: the records of store are loaded by NewConnManager and restored as the peers
+// connect, and a snapshot of the connected peers, together with the records of the
+// peers that are not connected, is saved every interval by the background loop and on
+// Close. Tags set with a TTL are not persisted. See the leveldbstore package for a
+// LevelDB backed store.
+func WithReputationStore(store ReputationStore, interval time.Duration) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.store = store
+		cm.saveInterval = interval
+	}
+}
+
+// WithSurvivorBonus adds bonus to the score of a peer during trims for every trim it
+// survived, i.e. every trim that closed connections while the peer was subject to
+// pruning but left it connected. This biases retention toward long-standing, proven
+// connections and reduces churn.
+func WithSurvivorBonus(bonus float64) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.survivorBonus = bonus
+	}
+}
+
+// WithProtocolWeights adds to the score of every peer during trims the weights of the
+// protocols it supports, so that peers of high priority protocols are pruned after
+// generic peers of equal value. Unlike protocol minimums, weights do not reserve any
+// peer; they only shift the eviction order.
+func WithProtocolWeights(weights map[protocol.ID]float64) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.protocolWeights = weights
+	}
+}
+
+// WithLatencyPenalty subtracts weight * latency/unit from the score of every peer during
+// trims, where latency is the moving average recorded in the peerstore, so that the
+// lower latency peers are kept when capacity is tight. Peers without a recorded latency
+// are not penalized. A weight small enough not to outweigh a tag value difference makes
+// latency a mere tiebreaker.
+func WithLatencyPenalty(weight float64, unit time.Duration) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.latencyWeight = weight
+		cm.latencyUnit = unit
+	}
+}
+
+// DirectionPreference selects which connection direction the built-in trim policy
+// prunes first among peers of equal score.
+type DirectionPreference int
+
+const (
+	// DirectionNeutral ignores connection directions. This is the default.
+	DirectionNeutral DirectionPreference = iota
+
+	// PreferPruningInbound prunes peers that dialed us before peers we dialed
+	// ourselves.
+	PreferPruningInbound
+
+	// PreferPruningOutbound prunes peers we dialed before peers that dialed us.
+	PreferPruningOutbound
+)
+
+// prunesFirst reports whether a peer in direction left goes before a peer in direction
+// right.
+func (dp DirectionPreference) prunesFirst(left, right network.Direction) bool {
+	switch dp {
+	case PreferPruningInbound:
+		return left == network.DirInbound && right != network.DirInbound
+	case PreferPruningOutbound:
+		return left == network.DirOutbound && right != network.DirOutbound
+	default:
+		return false
+	}
+}
+
+// WithDirectionPreference breaks ties between peers of equal score by the direction
+// of their connections. A peer counts as outbound if we dialed any of its connections.
+func WithDirectionPreference(pref DirectionPreference) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.directionPreference = pref
+	}
+}
+
+// WithTagBounds clamps the values set through TagPeer and UpsertTag for the given tags
+// to their bounds, so that a single runaway subsystem cannot make a peer unprunable.
+// Bounds apply as values are set: an upsert function sees the clamped value.
+func WithTagBounds(bounds map[string]TagBounds) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.tagBounds = bounds
+	}
+}
+
+// WithNegativeTagFloor sets a floor, which must be negative, below which no tag value
+// goes. Misbehavior penalties accumulated through UpsertTag then saturate at the floor,
+// instead of growing without bound or wrapping around, so a peer that behaves again
+// can recover in reasonable time. Per-tag bounds set through WithTagBounds apply first.
+func WithNegativeTagFloor(floor int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.negativeTagFloor = floor
+	}
+}
+
+// WithMaxPeerValue normalizes the value of peers during trims: values above max are
+// treated as max, so that peers past that value are ordered by the other criteria,
+// such as the bonuses configured through options. GetTagInfo still reports the sum of
+// the tag values.
+func WithMaxPeerValue(max int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.maxPeerValue = max
+	}
+}
+
+// WithNamespaceWeights multiplies the values of namespaced tags, such as "pubsub/mesh"
+// or "sync/serving", by the weight of their namespace when computing the value of a
+// peer, so operators can tune how much each subsystem influences eviction without
+// changing subsystem code. Tags of other namespaces, and tags without any, keep a
+// weight of one. Weighted values are rounded to the nearest integer; GetTagInfo reports
+// the raw tag values along with the weighted peer value.
+func WithNamespaceWeights(weights map[string]float64) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.namespaceWeights = weights
+	}
+}
+
+// WithFairEviction makes the built-in trim policy evict proportionally across tag
+// classes, so a trim cannot wipe out all the peers of one subsystem. Each class is
+// identified by a tag prefix such as "dht" or "sync", and each peer belongs to the class
+// contributing the most to its value; peers without any tag of a class form a class of
+// their own. Trims then prune the same fraction of the candidates of every class, lowest
+// scoring first within a class.
+func WithFairEviction(classes ...string) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.tagClasses = classes
+	}
+}
+
+// TransientHandling is a set of flags selecting how transient connections, such as the
+// limited connections some transports open for hole punching, are treated.
+type TransientHandling int
+
+const (
+	// ExcludeTransientFromMinimums keeps peers connected through transient connections
+	// only from counting toward, and being reserved for, protocol minimums.
+	ExcludeTransientFromMinimums TransientHandling = 1 << iota
+
+	// PruneTransientFirst makes the built-in trim policy prune peers connected through
+	// transient connections only before any other peer, idle peers aside.
+	PruneTransientFirst
+)
+
+// WithTransientConns flags the connections for which detect returns true as transient
+// when they are reported, and treats them according to handling. The version of
+// libp2p this package targets does not mark transient connections itself, so detect
+// typically inspects the transport or the multiaddr of the connection.
+func WithTransientConns(detect func(network.Conn) bool, handling TransientHandling) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.isTransient = detect
+		cm.transientHandling = handling
+	}
+}
+
+// RelayPolicy selects how the built-in trim policy treats peers reached through circuit
+// relays, which cost relay bandwidth but are sometimes the only path to NATed peers.
+type RelayPolicy int
+
+const (
+	// RelayNeutral orders relayed peers by score like any other peer. This is the
+	// default.
+	RelayNeutral RelayPolicy = iota
+
+	// PruneRelayedFirst prunes relayed peers before any directly connected peer.
+	PruneRelayedFirst
+
+	// PruneRelayedLast prunes relayed peers only once no directly connected peer is
+	// left to prune.
+	PruneRelayedLast
+)
+
+// WithRelayPolicy selects how trims treat peers whose connections all go through
+// circuit relays. When maxRelayed is positive, trims also prune the lowest scoring
+// relayed peers in excess of maxRelayed, even past the target.
+func WithRelayPolicy(policy RelayPolicy, maxRelayed int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.relayPolicy = policy
+		cm.maxRelayed = maxRelayed
+	}
+}
+
+// WithSubnetDiversity makes the built-in trim policy resist eclipse attacks by evicting
+// first the peers of the most represented /24 (IPv4) or /48 (IPv6) subnets, in score
+// order within a subnet. When maxPerSubnet is positive, trims also prune the lowest
+// scoring peers of every subnet holding more than maxPerSubnet candidates, even past
+// the target. Only peers that may be pruned are taken into account: protected peers and
+// peers in their grace period are neither counted nor evicted.
+func WithSubnetDiversity(maxPerSubnet int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.subnetDiversity = true
+		cm.maxPerSubnet = maxPerSubnet
+	}
+}
+
+// WithGeoDiversity makes the built-in trim policy keep a geographically diverse set of
+// peers: the peers that may be pruned are located with geo, and those of any country
+// holding more than maxShare (between 0 and 1) of the candidates surviving the trim are
+// pruned, lowest scoring first, even past the target. Peers of unknown location are
+// never pruned on that ground.
+func WithGeoDiversity(geo GeoIPProvider, maxShare float64) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.geoIP = geo
+		cm.maxCountryShare = maxShare
+	}
+}
+
+// WithIdleTimeout marks as idle the peers that have no active stream, and have neither
+// opened or closed a connection or stream nor had their tags updated for the given
+// duration, counting from when we began tracking them (see PeerSnapshot.LastActivity).
+// The built-in trim policy prunes idle peers before any other, whatever their score.
+// Zero, the default, disables idle detection.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.idleTimeout = timeout
+	}
+}
+
+// WithEventBus emits EvtPeerTrimmed, EvtWatermarkExceeded, EvtProtocolMinimumAtRisk and
+// EvtNeedPeers on bus, for other components of the host to subscribe to. The emitters
+// are closed along with the connection manager. If they cannot be created, the error is
+// logged and no events are emitted.
+func WithEventBus(bus event.Bus) Option {
+	return func(cm *PhoreConnMgr) {
+		em, err := newEmitters(bus)
+		if err != nil {
+			log.Errorf("failed to create event emitters: %s", err)
+			return
+		}
+		cm.events = em
+	}
+}
+
+// WithOverloadedAfter sets how long the count must stay above the high watermark for
+// Health to report the connection manager as overloaded, DefaultOverloadedAfter by
+// default.
+func WithOverloadedAfter(d time.Duration) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.overloadedAfter = d
+	}
+}
+
+// WithCloseConnsOnShutdown makes Close close every tracked connection, once the
+// background loop and the trims in progress are done.
+func WithCloseConnsOnShutdown() Option {
+	return func(cm *PhoreConnMgr) {
+		cm.closeConns = true
+	}
+}
+
+// WithLowPeerCallback registers f to be called when the count of connections or peers,
+// depending on the WatermarkBasis, drops below threshold, e.g. to have the dialer find
+// more peers. A threshold of zero or less stands for the low watermark. The callback
+// fires once per drop: it is called again only after the count has gone back to the
+// threshold. It runs on the goroutine reporting the disconnection, without holding any
+// locks, and must not block.
+func WithLowPeerCallback(threshold int, f func(count, threshold int)) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.lowPeers = f
+		cm.lowPeersThreshold = threshold
+	}
+}
+
+// WithMinPeers makes the connection manager ask for peers while fewer than min are
+// connected: a PeerDemand is signalled through NeedPeers, and as EvtNeedPeers on the
+// event bus if one is configured, whenever a peer disconnects and on every tick of the
+// background loop, until the minimum is reached. Dialers consuming the demand turn the
+// connection manager into a controller keeping the count between min and the high
+// watermark.
+func WithMinPeers(min int) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.minPeers = min
+		cm.needPeers = make(chan PeerDemand, 1)
+	}
+}
+
+// WithDialer has d dial the peers added with AddStaticPeer whenever they are not
+// connected: right after they disconnect, and otherwise every interval, one minute if
+// zero or less. The delay between failed dials of a peer starts at interval, and
+// doubles up to 32 intervals.
+func WithDialer(d Dialer, interval time.Duration) Option {
+	return func(cm *PhoreConnMgr) {
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		cm.dialer = d
+		cm.dialInterval = interval
+		cm.staticWake = make(chan struct{}, 1)
+	}
+}
+
+// WithTracerProvider has trims recorded as spans of a tracer of tp, instead of the
+// global one. A span, named connmgr.trim, records the number of candidates considered
+// and of connections selected, and an event for every connection closed, with the peer
+// and the reason it was selected. A nil tp keeps the global provider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(cm *PhoreConnMgr) {
+		if tp == nil {
+			cm.tracer = nil
+			return
+		}
+		cm.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithExpvar publishes the connection count, the time of the last trim, the watermarks
+// and the number of trims performed as expvar variables, under prefix.connCount,
+// prefix.lastTrim, prefix.lowWater, prefix.highWater and prefix.trims. As expvar
+// variables cannot be removed, they keep reporting the connection manager after it is
+// closed, and a prefix can only be used by a single connection manager per process.
+func WithExpvar(prefix string) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.publishExpvars(prefix)
+	}
+}
+
+// WithTrimReporter has f receive the report of every trim that ran, once it completed,
+// on the goroutine of the trim and with no lock of the connection manager held.
+func WithTrimReporter(f func(TrimDetails)) Option {
+	return func(cm *PhoreConnMgr) {
+		cm.trimReporter = f
+	}
+}
+
+// WithChurnWindow sets the window over which the churn reported by Churn and GetInfo
+// is computed, DefaultChurnWindow by default. Windows shorter than a second are
+// ignored.
+func WithChurnWindow(window time.Duration) Option {
+	return func(cm *PhoreConnMgr) {
+		if window >= time.Second {
+			cm.churn.window = window
+		}
+	}
+}
+
+// WithProtocolGauges keeps count of the connected peers supporting each of protos,
+// reported by ProtocolPeers, GetInfo and the metrics packages. The counts are updated
+// from the peerstore as peers connect and disconnect, on the EvtPeerProtocolsUpdated
+// events received through SubscribeProtocolUpdates, and on every tick of the
+// background loop.
+func WithProtocolGauges(protos ...protocol.ID) Option {
+	return func(cm *PhoreConnMgr) {
+		g := &protocolGauges{peers: make(map[protocol.ID]map[peer.ID]struct{}, len(protos))}
+		for _, proto := range protos {
+			if _, ok := g.peers[proto]; !ok {
+				g.protos = append(g.protos, string(proto))
+				g.peers[proto] = make(map[peer.ID]struct{})
+			}
+		}
+		cm.protoGauges = g
+	}
+}
+
+// WithAuditLog keeps the size most recent events in an audit log, retrieved with
+// AuditLog and AuditLogFor: connections opening and closing, tags changing by at least
+// tagThreshold, protections, and connections closed by trims along with the reason,
+// so that disconnects can be explained after the fact without debug logging.
+func WithAuditLog(size, tagThreshold int) Option {
+	return func(cm *PhoreConnMgr) {
+		if size > 0 {
+			cm.audit = &auditLog{events: make([]AuditEvent, size), tagThreshold: tagThreshold}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	tu "github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestTrimInterval(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithTrimInterval(10*time.Millisecond))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 30; i++ {
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cm.GetInfo().ConnCount != 10 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the background loop to trim down to 10 connections, got %d", cm.GetInfo().ConnCount)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSegmentConfiguration(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	for _, count := range []int{1, 16, 1024} {
+		cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithSegments(count, nil))
+		if len(cm.segments.buckets) != count {
+			t.Fatalf("expected %d segments, got %d", count, len(cm.segments.buckets))
+		}
+
+		not := cm.Notifee()
+		for i := 0; i < 50; i++ {
+			rc := randConn(t, nil)
+			not.Connected(nil, rc)
+			cm.TagPeer(rc.RemotePeer(), "tag", 1)
+		}
+		if n := cm.segments.countPeers(); n != 50 {
+			t.Fatalf("expected 50 tracked peers over %d segments, got %d", count, n)
+		}
+		cm.Close()
+	}
+}
+
+func TestPeerWatermarkBasis(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(5, 10, 0, ps, map[protocol.ID]int{}, WithWatermarkBasis(PeerBasis))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// 12 peers holding 3 connections each.
+	for i := 0; i < 12; i++ {
+		rc := randConn(t, not.Disconnected)
+		not.Connected(nil, rc)
+		for j := 0; j < 2; j++ {
+			not.Connected(nil, &tconn{peer: rc.RemotePeer(), disconnectNotify: not.Disconnected})
+		}
+	}
+	if n := cm.count(); n != 12 {
+		t.Fatalf("expected 12 connected peers, got %d", n)
+	}
+
+	cm.TrimOpenConns(context.Background())
+	if n := cm.count(); n != 5 {
+		t.Fatalf("expected a trim down to 5 peers, got %d", n)
+	}
+	if n := cm.GetInfo().ConnCount; n != 15 {
+		t.Fatalf("expected the remaining peers to keep all their connections, got %d", n)
+	}
+}
+
+func TestMaxClosesPerTrim(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithMaxClosesPerTrim(5), WithSilencePeriod(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 30; i++ {
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+
+	for _, expected := range []int{25, 20, 15, 10, 10} {
+		cm.TrimOpenConns(context.Background())
+		if n := cm.GetInfo().ConnCount; n != expected {
+			t.Fatalf("expected %d connections after trimming, got %d", expected, n)
+		}
+	}
+}
+
+func TestMaxTempEntries(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithMaxTempEntries(2))
+	defer cm.Close()
+
+	var ids []peer.ID
+	for i := 0; i < 3; i++ {
+		id := tu.RandPeerIDFatal(t)
+		ids = append(ids, id)
+		cm.TagPeer(id, "early", 1)
+	}
+	if cm.GetTagInfo(ids[2]) != nil {
+		t.Fatal("expected the tag beyond the temporary entry limit to be dropped")
+	}
+
+	// converting a temporary entry into a real one frees up a slot.
+	not := cm.Notifee()
+	not.Connected(nil, &tconn{peer: ids[0]})
+	cm.UpsertTag(ids[2], "early", func(int) int { return 1 })
+	if cm.GetTagInfo(ids[2]) == nil {
+		t.Fatal("expected a temporary entry once a slot was freed")
+	}
+
+	cm = NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithMaxTempEntries(0))
+	defer cm.Close()
+	cm.TagPeer(ids[1], "early", 1)
+	if cm.GetTagInfo(ids[1]) != nil {
+		t.Fatal("expected temporary entries to be disabled")
+	}
+}
+
+func TestTrimHysteresis(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(5, 10, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithTrimInterval(0), WithTrimHysteresis(time.Minute, 5))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 12; i++ {
+		not.Connected(nil, randConn(t, nil))
+	}
+	if cm.overHighWater(10) {
+		t.Fatal("should not trim as soon as the high watermark is exceeded")
+	}
+	clock.Add(30 * time.Second)
+	if cm.overHighWater(10) {
+		t.Fatal("should not trim before the delay has elapsed")
+	}
+	clock.Add(30 * time.Second)
+	if !cm.overHighWater(10) {
+		t.Fatal("should trim once the count stayed above the high watermark for the delay")
+	}
+
+	// dropping below the watermark restarts the delay.
+	if cm.overHighWater(20) {
+		t.Fatal("should not trim below the high watermark")
+	}
+	if cm.overHighWater(8) {
+		t.Fatal("should not trim within the margin right after exceeding the watermark")
+	}
+	if !cm.overHighWater(6) {
+		t.Fatal("should trim as soon as the count exceeds the margin")
+	}
+}
+
+func TestReservedOutboundSlots(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(9, 10, 0, ps, map[protocol.ID]int{}, WithReservedOutboundSlots(4))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var inbound []*tconn
+	for i := 0; i < 8; i++ {
+		c := &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirInbound}
+		inbound = append(inbound, c)
+		not.Connected(nil, c)
+		cm.TagPeer(c.peer, "score", i+1)
+	}
+	outbound := &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirOutbound}
+	not.Connected(nil, outbound)
+
+	// at the low watermark, but two inbound connections over the six allowed.
+	cm.TrimOpenConns(context.Background())
+
+	for i, c := range inbound {
+		if shouldClose := i < 2; c.closed != shouldClose {
+			t.Errorf("inbound peer with value %d: expected closed=%v", i+1, shouldClose)
+		}
+	}
+	if outbound.closed {
+		t.Fatal("expected the outbound connection to be kept")
+	}
+}
+
+func TestSurplusOnlyTrimLeavesWatermarksAlone(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(2, 10, 0, ps, map[protocol.ID]int{}, WithReservedOutboundSlots(4))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 7; i++ {
+		not.Connected(nil, &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirInbound})
+	}
+
+	// the background loop only evicts the inbound surplus.
+	plan, err := cm.trim(context.Background(), trimOpts{surplusOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.conns) != 1 {
+		t.Fatalf("expected a single connection to be closed, got %d", len(plan.conns))
+	}
+}
+
+func TestGraceExemptTags(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, time.Hour, ps, map[protocol.ID]int{}, WithGraceExemptTags("suspicious"))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	bad, fresh := randConn(t, nil), randConn(t, nil)
+	not.Connected(nil, bad)
+	not.Connected(nil, fresh)
+	cm.TagPeer(bad.RemotePeer(), "suspicious", 0)
+
+	cm.TrimOpenConns(context.Background())
+
+	if !bad.(*tconn).closed {
+		t.Fatal("expected the suspicious peer to lose its grace period")
+	}
+	if fresh.(*tconn).closed {
+		t.Fatal("expected the other peer to keep its grace period")
+	}
+}
+
+func TestGracePerConnection(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, time.Minute, ps, map[protocol.ID]int{}, WithClock(clock), WithGraceBasis(GracePerConnection))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	known := tu.RandPeerIDFatal(t)
+	old := &tconn{peer: known}
+	not.Connected(nil, old)
+	cm.TagPeer(known, "score", 10)
+	other := randConn(t, nil)
+	not.Connected(nil, other)
+
+	// a long-known peer opens a new connection.
+	clock.Add(2 * time.Minute)
+	fresh := &tconn{peer: known}
+	not.Connected(nil, fresh)
+
+	cm.TrimOpenConns(context.Background())
+
+	if fresh.closed {
+		t.Fatal("expected the new connection to get its own grace period")
+	}
+	if !old.closed || !other.(*tconn).closed {
+		t.Fatal("expected the connections past their grace period to be closed")
+	}
+}
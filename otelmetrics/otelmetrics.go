@@ -0,0 +1,168 @@
+// Package otelmetrics reports the state of a connmgr.PhoreConnMgr through the
+// OpenTelemetry metrics API, with the same instruments as package prommetrics.
+package otelmetrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	connmgr "github.com/phoreproject/go-phore-connmgr"
+
+	logging "github.com/ipfs/go-log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var log = logging.Logger("connmgr/otelmetrics")
+
+// ScopeName is the instrumentation scope of the meter used by WithMeterProvider.
+const ScopeName = "github.com/phoreproject/go-phore-connmgr"
+
+// Instrument creates observable instruments on meter, which read the state of cm on
+// every collection, until the returned registration is unregistered.
+func Instrument(cm *connmgr.PhoreConnMgr, meter metric.Meter) (metric.Registration, error) {
+	conns, err := meter.Int64ObservableGauge("connmgr.connections",
+		metric.WithDescription("Number of connections tracked."))
+	if err != nil {
+		return nil, err
+	}
+	peers, err := meter.Int64ObservableGauge("connmgr.peers",
+		metric.WithDescription("Number of peers tracked, including peers tagged but not connected."))
+	if err != nil {
+		return nil, err
+	}
+	protected, err := meter.Int64ObservableGauge("connmgr.protected_peers",
+		metric.WithDescription("Number of peers protected under at least one tag."))
+	if err != nil {
+		return nil, err
+	}
+	trims, err := meter.Int64ObservableCounter("connmgr.trims",
+		metric.WithDescription("Number of trims performed."))
+	if err != nil {
+		return nil, err
+	}
+	closed, err := meter.Int64ObservableCounter("connmgr.closed_connections",
+		metric.WithDescription("Number of connections closed by trims."))
+	if err != nil {
+		return nil, err
+	}
+	sinceLastTrim, err := meter.Float64ObservableGauge("connmgr.since_last_trim",
+		metric.WithDescription("Time elapsed since the last trim completed, absent if none did."),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	protocolPeers, err := meter.Int64ObservableGauge("connmgr.protocol.peers",
+		metric.WithDescription("Number of peers counted toward the minimum of a protocol."))
+	if err != nil {
+		return nil, err
+	}
+	protocolMinima, err := meter.Int64ObservableGauge("connmgr.protocol.minimum_peers",
+		metric.WithDescription("Minimum number of peers trims must leave connected for a protocol."))
+	if err != nil {
+		return nil, err
+	}
+
+	protocolConns, err := meter.Int64ObservableGauge("connmgr.protocol.connected_peers",
+		metric.WithDescription("Number of connected peers supporting a protocol gauged with WithProtocolGauges."))
+	if err != nil {
+		return nil, err
+	}
+	churnRate, err := meter.Float64ObservableGauge("connmgr.churn.rate",
+		metric.WithDescription("Number of peers connecting and disconnecting per second within the churn window."),
+		metric.WithUnit("1/s"))
+	if err != nil {
+		return nil, err
+	}
+	connects, err := meter.Int64ObservableCounter("connmgr.peer.connects",
+		metric.WithDescription("Number of peers that connected."))
+	if err != nil {
+		return nil, err
+	}
+	disconnects, err := meter.Int64ObservableCounter("connmgr.peer.disconnects",
+		metric.WithDescription("Number of peers that disconnected their last connection."))
+	if err != nil {
+		return nil, err
+	}
+	// asynchronous histograms do not exist in OpenTelemetry, so the session lengths are
+	// reported as cumulative counts by upper bound, as in a Prometheus histogram.
+	sessions, err := meter.Int64ObservableCounter("connmgr.sessions",
+		metric.WithDescription("Number of sessions of peers no longer than the bound in le, in seconds."))
+	if err != nil {
+		return nil, err
+	}
+	planSeconds, err := meter.Float64ObservableCounter("connmgr.trim.plan_time",
+		metric.WithDescription("Time spent by trims selecting the connections to close."),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	closeSeconds, err := meter.Float64ObservableCounter("connmgr.trim.close_time",
+		metric.WithDescription("Time spent by trims closing connections."),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := meter.Int64ObservableCounter("connmgr.trim.candidates",
+		metric.WithDescription("Number of peers considered for pruning by trims."))
+	if err != nil {
+		return nil, err
+	}
+	lookups, err := meter.Int64ObservableCounter("connmgr.trim.peerstore_lookups",
+		metric.WithDescription("Number of peerstore lookups made by trims."))
+	if err != nil {
+		return nil, err
+	}
+
+	return meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		info := cm.GetInfo()
+		o.ObserveInt64(conns, int64(info.ConnCount))
+		o.ObserveInt64(peers, int64(info.TrackedPeers))
+		o.ObserveInt64(protected, int64(info.ProtectedPeers))
+		o.ObserveInt64(trims, int64(info.TotalTrims))
+		o.ObserveInt64(closed, int64(info.TotalClosed))
+		if h := cm.Health(); !h.LastTrim.IsZero() {
+			o.ObserveFloat64(sinceLastTrim, h.SinceLastTrim.Seconds())
+		}
+		for proto, pc := range info.ProtocolCounts {
+			attrs := metric.WithAttributes(attribute.String("protocol", string(proto)))
+			o.ObserveInt64(protocolPeers, int64(pc.Connected), attrs)
+			o.ObserveInt64(protocolMinima, int64(pc.Minimum), attrs)
+		}
+		for proto, n := range info.ProtocolPeers {
+			o.ObserveInt64(protocolConns, int64(n), metric.WithAttributes(attribute.String("protocol", string(proto))))
+		}
+
+		churn := info.Churn
+		o.ObserveFloat64(churnRate, churn.Rate)
+		o.ObserveInt64(connects, int64(churn.TotalConnects))
+		o.ObserveInt64(disconnects, int64(churn.TotalDisconnects))
+		var cumulative int64
+		for i, bound := range connmgr.SessionBuckets {
+			cumulative += int64(churn.Sessions.Counts[i])
+			o.ObserveInt64(sessions, cumulative, metric.WithAttributes(attribute.String("le", strconv.FormatFloat(bound.Seconds(), 'g', -1, 64))))
+		}
+		o.ObserveInt64(sessions, int64(churn.Sessions.Count), metric.WithAttributes(attribute.String("le", "+Inf")))
+
+		cost := info.TotalTrimCost
+		o.ObserveFloat64(planSeconds, time.Duration(cost.Plan).Seconds())
+		o.ObserveFloat64(closeSeconds, time.Duration(cost.Close).Seconds())
+		o.ObserveInt64(candidates, int64(cost.Candidates))
+		o.ObserveInt64(lookups, int64(cost.PeerstoreLookups))
+		return nil
+	}, conns, peers, protected, trims, closed, sinceLastTrim, protocolPeers, protocolMinima,
+		protocolConns, churnRate, connects, disconnects, sessions,
+		planSeconds, closeSeconds, candidates, lookups)
+}
+
+// WithMeterProvider reports the metrics of the connection manager through a meter of
+// mp, named ScopeName, for as long as the provider lives. A failure to create the
+// instruments is logged; use Instrument directly to handle it, or to stop reporting.
+func WithMeterProvider(mp metric.MeterProvider) connmgr.Option {
+	return func(cm *connmgr.PhoreConnMgr) {
+		if _, err := Instrument(cm, mp.Meter(ScopeName)); err != nil {
+			log.Errorf("failed to instrument connection manager: %s", err)
+		}
+	}
+}
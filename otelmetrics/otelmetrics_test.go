@@ -0,0 +1,78 @@
+package otelmetrics
+
+import (
+	"context"
+	"testing"
+
+	connmgr "github.com/phoreproject/go-phore-connmgr"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// collect returns the data points of the int64 instruments, keyed by instrument name
+// and then by protocol attribute, empty for the instruments without one.
+func collect(t *testing.T, r sdkmetric.Reader) map[string]map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := r.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			var points []metricdata.DataPoint[int64]
+			switch data := m.Data.(type) {
+			case metricdata.Gauge[int64]:
+				points = data.DataPoints
+			case metricdata.Sum[int64]:
+				points = data.DataPoints
+			default:
+				continue
+			}
+			values[m.Name] = make(map[string]int64)
+			for _, dp := range points {
+				proto, _ := dp.Attributes.Value("protocol")
+				values[m.Name][proto.AsString()] = dp.Value
+			}
+		}
+	}
+	return values
+}
+
+func TestInstrument(t *testing.T) {
+	r := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(r))
+	defer mp.Shutdown(context.Background())
+
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := connmgr.NewConnManager(1, 2, 0, ps, map[protocol.ID]int{"/sync/1.0.0": 1}, WithMeterProvider(mp))
+	defer cm.Close()
+
+	cm.TagPeer(peer.ID("a"), "score", 1)
+	cm.Protect(peer.ID("b"), "keep")
+	cm.TrimOpenConns(context.Background())
+
+	values := collect(t, r)
+	for name, want := range map[string]int64{
+		"connmgr.connections":        0,
+		"connmgr.peers":              1,
+		"connmgr.protected_peers":    1,
+		"connmgr.trims":              1,
+		"connmgr.closed_connections": 0,
+	} {
+		if got, ok := values[name][""]; !ok || got != want {
+			t.Errorf("expected %s to be %d, got %d", name, want, got)
+		}
+	}
+	if got := values["connmgr.protocol.minimum_peers"]["/sync/1.0.0"]; got != 1 {
+		t.Errorf("expected a minimum of 1 sync peer, got %d", got)
+	}
+	if got, ok := values["connmgr.protocol.peers"]["/sync/1.0.0"]; !ok || got != 0 {
+		t.Errorf("expected no sync peer, got %d", got)
+	}
+}
@@ -0,0 +1,186 @@
+package connmgr
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// StaticPeerTag is the protection tag of the peers added with AddStaticPeer.
+const StaticPeerTag = "static-peer"
+
+// maxDialBackoff bounds the delay between two failed dials of a static peer, in dial
+// intervals.
+const maxDialBackoff = 32
+
+// Dialer connects to peers on behalf of the connection manager, typically the host.
+type Dialer interface {
+	// DialPeer connects to id, returning once connected or on failure.
+	DialPeer(ctx context.Context, id peer.ID) error
+}
+
+// staticPeer is the dial state of a peer added with AddStaticPeer.
+type staticPeer struct {
+	dialing bool
+	backoff time.Duration // delay after the last failed dial, zero after a success
+	next    time.Time     // earliest time for the next dial
+}
+
+// AddStaticPeer keeps id connected: the peer is protected from trims under
+// StaticPeerTag, and, if a Dialer is configured with WithDialer, dialed whenever it is
+// not connected, unless it is banned or InterceptReconnect refuses it. Failed dials
+// are retried with an exponential backoff.
+func (cm *PhoreConnMgr) AddStaticPeer(id peer.ID) {
+	cm.Protect(id, StaticPeerTag)
+
+	cm.staticMu.Lock()
+	if cm.static == nil {
+		cm.static = make(map[peer.ID]*staticPeer)
+	}
+	if _, ok := cm.static[id]; !ok {
+		cm.static[id] = &staticPeer{}
+	}
+	cm.staticMu.Unlock()
+	cm.wakePeering()
+}
+
+// RemoveStaticPeer reverts AddStaticPeer. The peer is left connected, but may be pruned
+// by future trims, unless protected under another tag.
+func (cm *PhoreConnMgr) RemoveStaticPeer(id peer.ID) {
+	cm.staticMu.Lock()
+	delete(cm.static, id)
+	cm.staticMu.Unlock()
+
+	cm.Unprotect(id, StaticPeerTag)
+}
+
+// StaticPeers returns the peers added with AddStaticPeer, by ascending ID.
+func (cm *PhoreConnMgr) StaticPeers() []peer.ID {
+	cm.staticMu.Lock()
+	defer cm.staticMu.Unlock()
+
+	ids := make([]peer.ID, 0, len(cm.static))
+	for id := range cm.static {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// wakePeering has the static peers checked right away, e.g. because a peer
+// disconnected.
+func (cm *PhoreConnMgr) wakePeering() {
+	if cm.staticWake == nil {
+		return
+	}
+	select {
+	case cm.staticWake <- struct{}{}:
+	default:
+	}
+}
+
+// peeringLoop dials the static peers that are not connected, every dial interval and
+// whenever woken.
+func (cm *PhoreConnMgr) peeringLoop() {
+	defer cm.running.Done()
+	ticker := cm.clock.NewTicker(cm.dialInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-cm.staticWake:
+		case <-cm.ctx.Done():
+			return
+		}
+		cm.dialStaticPeers()
+	}
+}
+
+// dialStaticPeers dials the static peers that are neither connected, nor being dialed,
+// nor backing off, nor banned or refused by InterceptReconnect. The most valuable
+// peers, as tagged while not connected, have their dials started first.
+func (cm *PhoreConnMgr) dialStaticPeers() {
+	now := cm.clock.Now()
+	var due []peer.ID
+	values := make(map[peer.ID]int)
+	for _, id := range cm.StaticPeers() {
+		if cm.IsBanned(id) || !cm.InterceptReconnect(id) {
+			continue
+		}
+		if !cm.isConnected(id) {
+			due = append(due, id)
+			values[id] = cm.peerValue(id)
+		}
+	}
+	// StaticPeers sorted the peers by ID, which breaks ties.
+	sort.SliceStable(due, func(i, j int) bool { return values[due[i]] > values[due[j]] })
+
+	cm.staticMu.Lock()
+	defer cm.staticMu.Unlock()
+	for _, id := range due {
+		sp, ok := cm.static[id]
+		if !ok || sp.dialing || sp.next.After(now) {
+			continue
+		}
+		if !cm.enter() {
+			return
+		}
+		sp.dialing = true
+		id := id
+		cm.goLabelled("dial", func(ctx context.Context) { cm.dialStatic(ctx, id) })
+	}
+}
+
+// dialStatic dials a static peer, and schedules the next attempt on failure.
+func (cm *PhoreConnMgr) dialStatic(ctx context.Context, id peer.ID) {
+	defer cm.running.Done()
+	err := cm.dialer.DialPeer(ctx, id)
+
+	cm.staticMu.Lock()
+	defer cm.staticMu.Unlock()
+	sp, ok := cm.static[id]
+	if !ok {
+		return
+	}
+	sp.dialing = false
+	if err == nil {
+		sp.backoff = 0
+		sp.next = time.Time{}
+		return
+	}
+
+	switch {
+	case sp.backoff == 0:
+		sp.backoff = cm.dialInterval
+	case sp.backoff < maxDialBackoff*cm.dialInterval:
+		sp.backoff *= 2
+	}
+	sp.next = cm.clock.Now().Add(sp.backoff)
+	log.Debugf("failed to dial static peer %s, retrying in %s: %s", id, sp.backoff, err)
+}
+
+// isConnected reports whether id holds at least one tracked connection.
+func (cm *PhoreConnMgr) isConnected(id peer.ID) bool {
+	s := cm.segments.get(id)
+	s.Lock()
+	defer s.Unlock()
+
+	pi, ok := s.peers[id]
+	return ok && len(pi.conns) > 0
+}
+
+// peerValue returns the value of id, including the tags it was given before connecting,
+// or zero if it is unknown.
+func (cm *PhoreConnMgr) peerValue(id peer.ID) int {
+	s := cm.segments.get(id)
+	s.Lock()
+	defer s.Unlock()
+
+	if pi, ok := s.peers[id]; ok {
+		return pi.value
+	}
+	return 0
+}
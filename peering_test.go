@@ -0,0 +1,125 @@
+package connmgr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	tu "github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+// testDialer reports every dial, and connects the peer unless told to fail.
+type testDialer struct {
+	cm    *PhoreConnMgr
+	fail  chan bool
+	dials chan *tconn
+}
+
+func (d *testDialer) DialPeer(ctx context.Context, id peer.ID) error {
+	if <-d.fail {
+		d.dials <- nil
+		return errors.New("unreachable")
+	}
+	c := &tconn{peer: id}
+	d.cm.Notifee().Connected(nil, c)
+	d.dials <- c
+	return nil
+}
+
+// waitDialDone waits for the dial of a static peer to be accounted for.
+func waitDialDone(t *testing.T, cm *PhoreConnMgr, id peer.ID) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cm.staticMu.Lock()
+		dialing := cm.static[id].dialing
+		cm.staticMu.Unlock()
+		if !dialing {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the dial to complete")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStaticPeers(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	d := &testDialer{fail: make(chan bool, 1), dials: make(chan *tconn, 1)}
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithTrimInterval(0), WithDialer(d, time.Minute))
+	defer cm.Close()
+	d.cm = cm
+
+	id := tu.RandPeerIDFatal(t)
+	d.fail <- false
+	cm.AddStaticPeer(id)
+	c := <-d.dials
+	waitDialDone(t, cm, id)
+	if ids := cm.StaticPeers(); len(ids) != 1 || ids[0] != id {
+		t.Fatalf("unexpected static peers: %v", ids)
+	}
+	if !cm.IsProtected(id, StaticPeerTag) {
+		t.Fatal("expected the static peer to be protected")
+	}
+
+	// dropping the peer triggers a redial, which fails and backs off.
+	d.fail <- true
+	cm.Notifee().Disconnected(nil, c)
+	if c := <-d.dials; c != nil {
+		t.Fatal("expected the dial to fail")
+	}
+	waitDialDone(t, cm, id)
+
+	clock.Add(30 * time.Second)
+	cm.wakePeering()
+	select {
+	case <-d.dials:
+		t.Fatal("expected no dial while backing off")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	d.fail <- false
+	clock.Add(30 * time.Second)
+	if c := <-d.dials; c == nil || c.peer != id {
+		t.Fatal("expected the peer to be dialed again once the backoff elapsed")
+	}
+	waitDialDone(t, cm, id)
+
+	cm.RemoveStaticPeer(id)
+	if cm.IsProtected(id, StaticPeerTag) || len(cm.StaticPeers()) != 0 {
+		t.Fatal("expected the peer to no longer be static")
+	}
+}
+
+func TestStaticPeersSkipBanned(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	d := &testDialer{fail: make(chan bool, 1), dials: make(chan *tconn, 1)}
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithTrimInterval(0), WithDialer(d, time.Minute))
+	defer cm.Close()
+	d.cm = cm
+
+	id := tu.RandPeerIDFatal(t)
+	cm.BanPeer(id, time.Hour, "misbehaving")
+	d.fail <- false
+	cm.AddStaticPeer(id)
+	select {
+	case <-d.dials:
+		t.Fatal("expected the banned static peer not to be dialed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cm.UnbanPeer(id)
+	cm.wakePeering()
+	if c := <-d.dials; c == nil || c.peer != id {
+		t.Fatal("expected the peer to be dialed once unbanned")
+	}
+	waitDialDone(t, cm, id)
+}
@@ -0,0 +1,486 @@
+package connmgr
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// TrimPolicy decides which connections a trim closes. The connection manager takes care
+// of tracking peers, of protections and protocol minimums, and of the grace period: the
+// snapshot handed to the policy only holds peers that are eligible for pruning. The
+// policy returns the connections to close, usually enough of them to meet the target
+// of the snapshot.
+//
+// The built-in policy, used unless another one is set through WithTrimPolicy, prunes the
+// candidates with the lowest score first.
+type TrimPolicy interface {
+	SelectConnsToClose(snapshot TrimSnapshot) []network.Conn
+}
+
+// TrimSnapshot describes the state the trim policy decides upon.
+type TrimSnapshot struct {
+	// Now is the time at which the snapshot was taken.
+	Now time.Time
+
+	// Basis tells whether Target counts connections or peers.
+	Basis WatermarkBasis
+
+	// Target is the number of connections (or peers, depending on Basis) that must be
+	// closed to reach the low watermark.
+	Target int
+
+	// Candidates are the peers eligible for pruning, in no particular order. The
+	// policy is free to reorder the slice.
+	Candidates []PeerSnapshot
+}
+
+// Count returns how much closing all connections of p counts toward the target.
+func (snap TrimSnapshot) Count(p PeerSnapshot) int {
+	if snap.Basis == PeerBasis {
+		if len(p.Conns) > 0 {
+			return 1
+		}
+		return 0
+	}
+	return len(p.Conns)
+}
+
+// PeerSnapshot is a point-in-time copy of the state of a tracked peer.
+type PeerSnapshot struct {
+	ID peer.ID
+
+	// Value is the sum of all tag values.
+	Value int
+
+	// Score is the value the built-in policy orders candidates by: Value plus the
+	// bonuses configured through options such as WithAgeBonus. Only snapshots taken
+	// for trims are scored.
+	Score float64
+
+	// Contributed is the sum of the registered score contributors for the peer, see
+	// RegisterScoreContributor. Like Score, it is only set for trims.
+	Contributed int
+
+	// Tags maps tags to their values.
+	Tags map[string]int
+
+	// FirstSeen is the time at which we began tracking the peer.
+	FirstSeen time.Time
+
+	// Temp is set for temporary entries, which hold early tags of peers whose first
+	// connection has not been reported yet.
+	Temp bool
+
+	// Conns are the open connections to the peer.
+	Conns []ConnSnapshot
+
+	// LastSeen is the time at which a connection to the peer was last opened or
+	// closed, zero for temporary entries.
+	LastSeen time.Time
+
+	// LastStreamActivity is the time at which a stream to the peer was last opened or
+	// closed, zero if that never happened.
+	LastStreamActivity time.Time
+
+	// LastTagged is the time at which the tags of the peer were last updated, zero if
+	// that never happened.
+	LastTagged time.Time
+
+	// Cooldown is set for peers that reconnected less than the cooldown window
+	// configured with WithReconnectCooldown after being pruned. Such peers get no
+	// grace period. It is only set for trims.
+	Cooldown bool
+
+	// Survived is the number of trims that closed connections, but left the peer
+	// connected while it was subject to pruning.
+	Survived int
+
+	// Protocols are the protocols the peer supports according to the peerstore. They
+	// are only set for trims when WithProtocolWeights is configured.
+	Protocols []protocol.ID
+
+	// Latency is the moving average of the latency recorded in the peerstore, zero if
+	// unknown. It is only set for trims when WithLatencyPenalty is configured.
+	Latency time.Duration
+
+	// Idle is set for peers that have been inactive for longer than the timeout
+	// configured with WithIdleTimeout. Like Score, it is only set for trims.
+	Idle bool
+}
+
+// LastActivity returns the latest of the times at which the peer was first seen, had a
+// connection or stream opened or closed, or had its tags updated. Policies may use it
+// to prune peers that have been silent for long.
+func (p PeerSnapshot) LastActivity() time.Time {
+	return latest(p.FirstSeen, p.LastSeen, p.LastStreamActivity, p.LastTagged)
+}
+
+// latest returns the latest of times.
+func latest(times ...time.Time) time.Time {
+	var last time.Time
+	for _, t := range times {
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// Streams returns the number of active streams over all connections to the peer.
+func (p PeerSnapshot) Streams() int {
+	n := 0
+	for _, c := range p.Conns {
+		n += c.Streams
+	}
+	return n
+}
+
+// Relayed reports whether all connections to the peer go through circuit relays.
+func (p PeerSnapshot) Relayed() bool {
+	for _, c := range p.Conns {
+		if !c.Relayed {
+			return false
+		}
+	}
+	return len(p.Conns) > 0
+}
+
+// Transient reports whether all connections to the peer are transient.
+func (p PeerSnapshot) Transient() bool {
+	for _, c := range p.Conns {
+		if !c.Transient {
+			return false
+		}
+	}
+	return len(p.Conns) > 0
+}
+
+// Direction summarizes the directions of the connections to the peer: it's outbound as
+// soon as one connection was dialed by us, inbound if all connections were initiated
+// by the remote peer, and unknown otherwise.
+func (p PeerSnapshot) Direction() network.Direction {
+	dir := network.DirUnknown
+	for i, c := range p.Conns {
+		switch {
+		case c.Direction == network.DirOutbound:
+			return network.DirOutbound
+		case i == 0:
+			dir = c.Direction
+		case c.Direction != dir:
+			dir = network.DirUnknown
+		}
+	}
+	return dir
+}
+
+// ConnSnapshot is a point-in-time copy of the state of a tracked connection.
+type ConnSnapshot struct {
+	Conn network.Conn
+
+	// Opened is the time at which the connection was reported.
+	Opened time.Time
+
+	// Direction tells which side initiated the connection.
+	Direction network.Direction
+
+	// Streams is the number of active streams over the connection.
+	Streams int
+
+	// Relayed is set for connections going through a circuit relay.
+	Relayed bool
+
+	// Transient is set for connections flagged by the detector configured with
+	// WithTransientConns.
+	Transient bool
+}
+
+// snapshot copies the state of the peer. The segment of the peer must be locked.
+func (pi *peerInfo) snapshot() PeerSnapshot {
+	ps := PeerSnapshot{
+		ID:        pi.id,
+		Value:     pi.value,
+		Tags:      make(map[string]int, len(pi.tags)),
+		FirstSeen: pi.firstSeen,
+		Temp:      pi.temp,
+		Conns:     make([]ConnSnapshot, 0, len(pi.conns)),
+
+		LastSeen:           pi.lastSeen,
+		LastStreamActivity: pi.lastStream,
+		LastTagged:         pi.lastTagged,
+		Survived:           pi.survived,
+	}
+	for t, v := range pi.tags {
+		ps.Tags[t] = v
+	}
+	for c, ci := range pi.conns {
+		ps.Conns = append(ps.Conns, ConnSnapshot{Conn: c, Opened: ci.opened, Direction: ci.dir, Streams: len(ci.streams), Relayed: ci.relayed, Transient: ci.transient})
+	}
+	return ps
+}
+
+// builtinPolicy is the TrimPolicy used unless another one is configured.
+type builtinPolicy struct {
+	cm *PhoreConnMgr
+
+	// reasons, if not nil, records why each connection was selected.
+	reasons map[network.Conn]CloseReason
+}
+
+func (bp builtinPolicy) SelectConnsToClose(snap TrimSnapshot) []network.Conn {
+	candidates := snap.Candidates
+
+	// Sort peers according to their score, or the configured comparator.
+	less := bp.cm.comparator
+	if less == nil {
+		less = bp.prunesFirst
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return less(candidates[i], candidates[j])
+	})
+
+	if bp.cm.pruneTemperature > 0 {
+		shuffleByScore(candidates, bp.cm.pruneTemperature)
+	}
+
+	// the surplus of crowded groups is pruned whatever the target.
+	var selected []network.Conn
+	pruneSurplus := func(surplus []PeerSnapshot, reason CloseReason) {
+		bp.record(surplus, reason)
+		for _, p := range surplus {
+			for _, c := range p.Conns {
+				selected = append(selected, c.Conn)
+			}
+			snap.Target -= snap.Count(p)
+		}
+	}
+	if bp.cm.maxRelayed > 0 {
+		var surplus []PeerSnapshot
+		surplus, candidates = capGroups(candidates, relayedGroup, bp.cm.maxRelayed)
+		pruneSurplus(surplus, ReasonRelayCap)
+	}
+	switch bp.cm.relayPolicy {
+	case PruneRelayedFirst:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Relayed() && !candidates[j].Relayed()
+		})
+	case PruneRelayedLast:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return !candidates[i].Relayed() && candidates[j].Relayed()
+		})
+	}
+	if bp.cm.subnetDiversity {
+		if bp.cm.maxPerSubnet > 0 {
+			var surplus []PeerSnapshot
+			surplus, candidates = capGroups(candidates, subnetOf, bp.cm.maxPerSubnet)
+			pruneSurplus(surplus, ReasonSubnetCap)
+		}
+		candidates = crowdedFirst(candidates, subnetOf)
+	}
+	if bp.cm.geoIP != nil {
+		var surplus []PeerSnapshot
+		ceiling := shareCeiling(snap, candidates, bp.cm.maxCountryShare)
+		surplus, candidates = capGroups(candidates, countryOf(bp.cm.geoIP), ceiling)
+		pruneSurplus(surplus, ReasonCountryCap)
+	}
+
+	if len(bp.cm.tagClasses) > 0 {
+		candidates = proportionalOrder(candidates, tagClassOf(bp.cm.tagClasses))
+	}
+
+	if bp.cm.transientHandling&PruneTransientFirst != 0 {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Transient() && !candidates[j].Transient()
+		})
+	}
+
+	// idle peers go first, in the order established above.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Idle && !candidates[j].Idle
+	})
+
+	if bp.cm.pruneDuplicates && snap.Basis == ConnectionBasis {
+		duplicates := closeDuplicates(&snap, candidates)
+		if bp.reasons != nil {
+			for _, c := range duplicates {
+				bp.reasons[c] = ReasonDuplicate
+			}
+		}
+		selected = append(selected, duplicates...)
+	}
+
+	rest := takeUntilTarget(snap, candidates)
+	if bp.reasons != nil {
+		for _, p := range candidates {
+			for _, c := range p.Conns {
+				if _, ok := bp.reasons[c.Conn]; !ok {
+					bp.reasons[c.Conn] = bp.orderedReason(p)
+				}
+			}
+		}
+	}
+	return append(selected, rest...)
+}
+
+// closeDuplicates selects, in order, the redundant connections of the candidates with
+// more than one, until the target of the snapshot is met. Each peer keeps its
+// connection with the most active streams, the oldest one among equals. The selected
+// connections are removed from the candidates, and the target is lowered accordingly.
+func closeDuplicates(snap *TrimSnapshot, candidates []PeerSnapshot) []network.Conn {
+	var selected []network.Conn
+	for i := range candidates {
+		if snap.Target <= 0 {
+			break
+		}
+		p := &candidates[i]
+		if len(p.Conns) < 2 {
+			continue
+		}
+
+		conns := make([]ConnSnapshot, len(p.Conns))
+		copy(conns, p.Conns)
+		sort.SliceStable(conns, func(i, j int) bool {
+			if conns[i].Streams != conns[j].Streams {
+				return conns[i].Streams > conns[j].Streams
+			}
+			return conns[i].Opened.Before(conns[j].Opened)
+		})
+
+		keep := len(conns) - snap.Target
+		if keep < 1 {
+			keep = 1
+		}
+		for _, c := range conns[keep:] {
+			selected = append(selected, c.Conn)
+		}
+		snap.Target -= len(conns) - keep
+		p.Conns = conns[:keep]
+	}
+	return selected
+}
+
+// prunesFirst is the default candidate order of the built-in policy. Peers are pruned
+// by ascending score, then:
+//  1. fewest active streams, then least recent stream activity;
+//  2. the configured DirectionPreference;
+//  3. most recently seen first, so established peers are kept;
+//  4. fewest connections;
+//  5. ascending peer ID, so trims are reproducible.
+func (bp builtinPolicy) prunesFirst(left, right PeerSnapshot) bool {
+	if left.Score != right.Score {
+		return left.Score < right.Score
+	}
+	if ls, rs := left.Streams(), right.Streams(); ls != rs {
+		return ls < rs
+	}
+	if !left.LastStreamActivity.Equal(right.LastStreamActivity) {
+		return left.LastStreamActivity.Before(right.LastStreamActivity)
+	}
+	pref, ld, rd := bp.cm.directionPreference, left.Direction(), right.Direction()
+	if pref.prunesFirst(ld, rd) {
+		return true
+	}
+	if pref.prunesFirst(rd, ld) {
+		return false
+	}
+	if !left.FirstSeen.Equal(right.FirstSeen) {
+		return left.FirstSeen.After(right.FirstSeen)
+	}
+	if lc, rc := len(left.Conns), len(right.Conns); lc != rc {
+		return lc < rc
+	}
+	return left.ID < right.ID
+}
+
+// evictInbound picks, lowest score first, the inbound candidates of the snapshot to
+// prune so that excess inbound connections or peers are closed, and returns them along
+// with the remaining candidates.
+func evictInbound(snap TrimSnapshot, excess int) (evicted, rest []PeerSnapshot) {
+	var inbound []PeerSnapshot
+	for _, p := range snap.Candidates {
+		if p.Direction() == network.DirInbound {
+			inbound = append(inbound, p)
+		}
+	}
+	sort.SliceStable(inbound, func(i, j int) bool {
+		if inbound[i].Score != inbound[j].Score {
+			return inbound[i].Score < inbound[j].Score
+		}
+		return inbound[i].ID < inbound[j].ID
+	})
+
+	picked := make(map[peer.ID]struct{})
+	for _, p := range inbound {
+		if excess <= 0 {
+			break
+		}
+		evicted = append(evicted, p)
+		picked[p.ID] = struct{}{}
+		excess -= snap.Count(p)
+	}
+
+	for _, p := range snap.Candidates {
+		if _, ok := picked[p.ID]; !ok {
+			rest = append(rest, p)
+		}
+	}
+	return evicted, rest
+}
+
+// shuffleByScore randomly reorders candidates, so that the probability of a peer
+// coming first is given by a softmax over its negated score divided by temperature:
+// lower scoring peers are more likely to come first, all the more so at low
+// temperatures. It uses the weighted sampling scheme of Efraimidis and Spirakis, where
+// each peer draws a key u^(1/w) for a uniform u and its weight w; peers are then
+// ordered by descending key.
+func shuffleByScore(candidates []PeerSnapshot, temperature float64) {
+	if len(candidates) == 0 {
+		return
+	}
+
+	min := candidates[0].Score
+	for _, c := range candidates {
+		if c.Score < min {
+			min = c.Score
+		}
+	}
+
+	keys := make(map[peer.ID]float64, len(candidates))
+	for _, c := range candidates {
+		// shifting by the minimum keeps the weights in (0, 1] and avoids overflows.
+		weight := math.Exp(-(c.Score - min) / temperature)
+		// compare logarithms of the keys, which keeps tiny weights apart.
+		keys[c.ID] = math.Log(rand.Float64()) / weight
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return keys[candidates[i].ID] > keys[candidates[j].ID]
+	})
+}
+
+// takeUntilTarget returns the connections of the given peers, in order, until the
+// target of the snapshot is met.
+func takeUntilTarget(snap TrimSnapshot, ordered []PeerSnapshot) []network.Conn {
+	target := snap.Target
+	if target <= 0 {
+		// the caps of the policy may have pruned past the target already.
+		return nil
+	}
+
+	// slightly overallocate because we may have more than one conns per peer
+	selected := make([]network.Conn, 0, target+10)
+	for _, p := range ordered {
+		if target <= 0 {
+			break
+		}
+		for _, c := range p.Conns {
+			selected = append(selected, c.Conn)
+		}
+		target -= snap.Count(p)
+	}
+	return selected
+}
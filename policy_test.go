@@ -0,0 +1,392 @@
+package connmgr
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	tu "github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+type highestValuePolicy struct {
+	snapshot TrimSnapshot
+}
+
+func (p *highestValuePolicy) SelectConnsToClose(snap TrimSnapshot) []network.Conn {
+	p.snapshot = snap
+	best := snap.Candidates[0]
+	for _, c := range snap.Candidates {
+		if c.Value > best.Value {
+			best = c
+		}
+	}
+	return []network.Conn{best.Conns[0].Conn}
+}
+
+func TestCustomTrimPolicy(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	policy := &highestValuePolicy{}
+	cm := NewConnManager(5, 10, 0, ps, map[protocol.ID]int{}, WithTrimPolicy(policy))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var conns []network.Conn
+	for i := 0; i < 12; i++ {
+		rc := randConn(t, not.Disconnected)
+		conns = append(conns, rc)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "score", i)
+	}
+	cm.Protect(conns[11].RemotePeer(), "test")
+
+	cm.TrimOpenConns(context.Background())
+
+	if len(policy.snapshot.Candidates) != 11 {
+		t.Fatalf("expected the protected peer to be excluded from the candidates, got %d candidates", len(policy.snapshot.Candidates))
+	}
+	if policy.snapshot.Target != 7 {
+		t.Fatalf("expected a target of 7, got %d", policy.snapshot.Target)
+	}
+	for i, c := range conns {
+		if shouldClose := i == 10; c.(*tconn).closed != shouldClose {
+			t.Errorf("connection %d: expected closed=%v", i, shouldClose)
+		}
+	}
+}
+
+func TestWeightedRandomPruning(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+
+	// with a very low temperature lowest value first is (almost surely) kept, with a
+	// very high one every peer has a fair shot at being pruned.
+	for _, tc := range []struct {
+		temperature float64
+		randomized  bool
+	}{
+		{0.001, false},
+		{1e9, true},
+	} {
+		lowestPruned := 0
+		for run := 0; run < 50; run++ {
+			cm := NewConnManager(9, 9, 0, ps, map[protocol.ID]int{}, WithWeightedRandomPruning(tc.temperature))
+			not := cm.Notifee()
+
+			var conns []network.Conn
+			for i := 0; i < 10; i++ {
+				rc := randConn(t, nil)
+				conns = append(conns, rc)
+				not.Connected(nil, rc)
+				cm.TagPeer(rc.RemotePeer(), "score", i*10)
+			}
+			cm.TrimOpenConns(context.Background())
+			if conns[0].(*tconn).closed {
+				lowestPruned++
+			}
+			cm.Close()
+		}
+
+		if !tc.randomized && lowestPruned != 50 {
+			t.Errorf("temperature %g: expected the lowest valued peer to always be pruned, was pruned %d times", tc.temperature, lowestPruned)
+		}
+		if tc.randomized && lowestPruned == 50 {
+			t.Errorf("temperature %g: expected other peers to be pruned as well", tc.temperature)
+		}
+	}
+}
+
+func TestDirectionPreference(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+
+	for _, tc := range []struct {
+		pref   DirectionPreference
+		pruned network.Direction
+	}{
+		{PreferPruningInbound, network.DirInbound},
+		{PreferPruningOutbound, network.DirOutbound},
+	} {
+		cm := NewConnManager(5, 5, 0, ps, map[protocol.ID]int{}, WithDirectionPreference(tc.pref))
+		not := cm.Notifee()
+
+		var conns []*tconn
+		for i := 0; i < 10; i++ {
+			rc := randConn(t, nil).(*tconn)
+			rc.dir = network.DirInbound
+			if i%2 == 0 {
+				rc.dir = network.DirOutbound
+			}
+			conns = append(conns, rc)
+			not.Connected(nil, rc)
+		}
+
+		cm.TrimOpenConns(context.Background())
+		for _, c := range conns {
+			if c.closed != (c.dir == tc.pruned) {
+				t.Errorf("preference %d: unexpected state for %d connection, closed=%v", tc.pref, c.dir, c.closed)
+			}
+		}
+		cm.Close()
+	}
+}
+
+func TestFairEviction(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(4, 4, 0, ps, map[protocol.ID]int{}, WithFairEviction("dht", "sync"))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// all dht peers are worth less than the sync peers.
+	var dht, sync []network.Conn
+	for i := 0; i < 4; i++ {
+		dc := randConn(t, nil)
+		not.Connected(nil, dc)
+		cm.TagPeer(dc.RemotePeer(), "dht-kbucket", i+1)
+		dht = append(dht, dc)
+
+		sc := randConn(t, nil)
+		not.Connected(nil, sc)
+		cm.TagPeer(sc.RemotePeer(), "sync", 10+i)
+		cm.TagPeer(sc.RemotePeer(), "dht-kbucket", 1)
+		sync = append(sync, sc)
+	}
+
+	cm.TrimOpenConns(context.Background())
+
+	for i := range dht {
+		shouldClose := i < 2
+		if dht[i].(*tconn).closed != shouldClose {
+			t.Errorf("dht peer %d: expected closed=%v", i, shouldClose)
+		}
+		if sync[i].(*tconn).closed != shouldClose {
+			t.Errorf("sync peer %d: expected closed=%v", i, shouldClose)
+		}
+	}
+}
+
+func TestComparator(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	// prune the peers with the most connections first, whatever their value.
+	cm := NewConnManager(2, 2, 0, ps, map[protocol.ID]int{}, WithComparator(func(a, b PeerSnapshot) bool {
+		return len(a.Conns) > len(b.Conns)
+	}))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	busy := randConn(t, nil)
+	not.Connected(nil, busy)
+	second := &tconn{peer: busy.RemotePeer()}
+	not.Connected(nil, second)
+	cm.TagPeer(busy.RemotePeer(), "score", 100)
+
+	single := randConn(t, nil)
+	not.Connected(nil, single)
+
+	cm.TrimOpenConns(context.Background())
+
+	if !busy.(*tconn).closed || !second.closed {
+		t.Fatal("expected the peer with two connections to be pruned")
+	}
+	if single.(*tconn).closed {
+		t.Fatal("expected the peer with a single connection to be kept")
+	}
+}
+
+func TestTiebreakOrder(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(4, 4, 0, ps, map[protocol.ID]int{}, WithClock(clock))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var conns []network.Conn
+	for i := 0; i < 6; i++ {
+		rc := randConn(t, nil)
+		conns = append(conns, rc)
+		not.Connected(nil, rc)
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].RemotePeer() < conns[j].RemotePeer()
+	})
+	// a newcomer goes before everyone else, whatever its peer ID.
+	clock.Add(time.Second)
+	newcomer := randConn(t, nil)
+	not.Connected(nil, newcomer)
+
+	cm.TrimOpenConns(context.Background())
+
+	if !newcomer.(*tconn).closed {
+		t.Fatal("expected the most recently seen peer to be pruned first")
+	}
+	for i, c := range conns {
+		if shouldClose := i < 2; c.(*tconn).closed != shouldClose {
+			t.Errorf("peer %d by ID: expected closed=%v", i, shouldClose)
+		}
+	}
+}
+
+func TestDuplicateConnPruning(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(2, 2, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithDuplicateConnPruning())
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// a peer with three connections, one of which carries a stream.
+	multi := tu.RandPeerIDFatal(t)
+	var dups []*tconn
+	for i := 0; i < 3; i++ {
+		c := &tconn{peer: multi}
+		dups = append(dups, c)
+		not.Connected(nil, c)
+		clock.Add(time.Second)
+	}
+	not.OpenedStream(nil, &tstream{conn: dups[2]})
+	single := randConn(t, nil)
+	not.Connected(nil, single)
+
+	cm.TrimOpenConns(context.Background())
+
+	if !dups[0].closed || !dups[1].closed || dups[2].closed {
+		t.Fatal("expected the duplicates without streams to be closed")
+	}
+	if single.(*tconn).closed {
+		t.Fatal("expected no peer to be evicted")
+	}
+}
+
+func TestPreviewTrim(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(3, 3, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithIdleTimeout(time.Minute),
+		WithReservedOutboundSlots(1))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// four inbound connections where two are allowed, one idle peer and a kept one.
+	var inbound []*tconn
+	for i := 0; i < 4; i++ {
+		c := &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirInbound}
+		inbound = append(inbound, c)
+		not.Connected(nil, c)
+	}
+	idle := &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirOutbound}
+	not.Connected(nil, idle)
+	cm.TagPeer(idle.peer, "score", 100)
+	clock.Add(2 * time.Minute)
+	// tagging the inbound peers keeps them from being idle.
+	for i, c := range inbound {
+		cm.TagPeer(c.peer, "score", 10+i)
+	}
+	kept := &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirOutbound}
+	not.Connected(nil, kept)
+	cm.TagPeer(kept.peer, "score", 50)
+
+	preview := cm.PreviewTrim(context.Background())
+	expected := []struct {
+		conn   *tconn
+		reason CloseReason
+	}{
+		{inbound[0], ReasonInboundSurplus},
+		{inbound[1], ReasonInboundSurplus},
+		{idle, ReasonIdle},
+	}
+	if len(preview) != len(expected) {
+		t.Fatalf("expected %d candidates, got %+v", len(expected), preview)
+	}
+	for i, e := range expected {
+		if preview[i].Conn != e.conn || preview[i].Peer != e.conn.peer || preview[i].Reason != e.reason {
+			t.Errorf("candidate %d: expected %s for %s, got %+v", i, e.reason, e.conn.peer, preview[i])
+		}
+	}
+	if preview[2].Value != 100 || preview[2].Score != 100 {
+		t.Errorf("expected the value and score of the idle peer, got %+v", preview[2])
+	}
+
+	for _, c := range append(inbound, idle, kept) {
+		if c.closed {
+			t.Fatal("expected the preview to close nothing")
+		}
+	}
+}
+
+func TestPreviewTrimExpiresTags(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithClock(clock))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	fleeting := &tconn{peer: tu.RandPeerIDFatal(t)}
+	not.Connected(nil, fleeting)
+	cm.TagPeerWithTTL(fleeting.peer, "fleeting", 100, time.Minute)
+	kept := &tconn{peer: tu.RandPeerIDFatal(t)}
+	not.Connected(nil, kept)
+	cm.TagPeer(kept.peer, "score", 10)
+	clock.Add(2 * time.Minute)
+
+	preview := cm.PreviewTrim(context.Background())
+	if len(preview) != 1 || preview[0].Conn != fleeting || preview[0].Value != 0 {
+		t.Fatalf("expected the peer whose tag expired to be listed, got %+v", preview)
+	}
+}
+
+func TestPrunableCandidates(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithIdleTimeout(time.Minute))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	idle := &tconn{peer: tu.RandPeerIDFatal(t)}
+	not.Connected(nil, idle)
+	cm.TagPeer(idle.peer, "score", 100)
+	clock.Add(2 * time.Minute)
+
+	// the low scoring peer holds two connections, listed together.
+	low := []*tconn{{peer: tu.RandPeerIDFatal(t)}}
+	low = append(low, &tconn{peer: low[0].peer})
+	for _, c := range low {
+		not.Connected(nil, c)
+	}
+	cm.TagPeer(low[0].peer, "score", 1)
+	high := &tconn{peer: tu.RandPeerIDFatal(t)}
+	not.Connected(nil, high)
+	cm.TagPeer(high.peer, "score", 50)
+	protected := &tconn{peer: tu.RandPeerIDFatal(t)}
+	not.Connected(nil, protected)
+	cm.Protect(protected.peer, "keep")
+
+	if c := cm.PreviewTrim(context.Background()); len(c) != 0 {
+		t.Fatalf("expected no trim to be due, got %+v", c)
+	}
+
+	next := cm.PrunableCandidates(2)
+	if len(next) != 3 {
+		t.Fatalf("expected the 3 connections of 2 peers, got %+v", next)
+	}
+	if next[0].Conn != idle || next[0].Reason != ReasonIdle || next[0].Value != 100 {
+		t.Errorf("expected the idle peer first, got %+v", next[0])
+	}
+	for _, c := range next[1:] {
+		if c.Peer != low[0].peer || c.Reason != ReasonLowScore || c.Value != 1 {
+			t.Errorf("expected the low scoring peer next, got %+v", c)
+		}
+	}
+
+	all := cm.PrunableCandidates(10)
+	if len(all) != 4 || all[3].Conn != high {
+		t.Fatalf("expected every unprotected connection, ending with the high scoring one, got %+v", all)
+	}
+	if cm.PrunableCandidates(0) != nil {
+		t.Error("expected no candidates when asking for none")
+	}
+	for _, c := range []*tconn{idle, low[0], low[1], high, protected} {
+		if c.closed {
+			t.Fatal("expected nothing to be closed")
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package connmgr
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// withLabels runs f with the goroutine labelled component=connmgr and op=op, so that
+// CPU profiles attribute its cost to the connection manager. The labels are carried
+// by the context passed to f, and are restored when f returns.
+func withLabels(ctx context.Context, op string, f func(ctx context.Context)) {
+	pprof.Do(ctx, pprof.Labels("component", "connmgr", "op", op), f)
+}
+
+// goLabelled runs f on a new goroutine labelled as by withLabels, passing it the
+// context of the connection manager.
+func (cm *PhoreConnMgr) goLabelled(op string, f func(ctx context.Context)) {
+	go withLabels(cm.ctx, op, f)
+}
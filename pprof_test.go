@@ -0,0 +1,45 @@
+package connmgr
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	tu "github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+type labelDialer chan map[string]string
+
+func (d labelDialer) DialPeer(ctx context.Context, id peer.ID) error {
+	labels := make(map[string]string)
+	pprof.ForLabels(ctx, func(k, v string) bool {
+		labels[k] = v
+		return true
+	})
+	d <- labels
+	return nil
+}
+
+func TestGoroutineLabels(t *testing.T) {
+	d := make(labelDialer, 1)
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 2, 0, ps, map[protocol.ID]int{}, WithDialer(d, time.Hour))
+	defer cm.Close()
+
+	cm.AddStaticPeer(tu.RandPeerIDFatal(t))
+	labels := <-d
+	if labels["component"] != "connmgr" || labels["op"] != "dial" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+
+	withLabels(context.Background(), "trim", func(ctx context.Context) {
+		if op, _ := pprof.Label(ctx, "op"); op != "trim" {
+			t.Fatalf("expected op=trim, got %q", op)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+// Package prommetrics exposes the state of a connmgr.PhoreConnMgr as Prometheus
+// metrics.
+package prommetrics
+
+import (
+	"time"
+
+	connmgr "github.com/phoreproject/go-phore-connmgr"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var log = logging.Logger("connmgr/prommetrics")
+
+const namespace = "connmgr"
+
+// Collector is a prometheus.Collector reading the state of a connection manager on
+// every scrape.
+type Collector struct {
+	cm *connmgr.PhoreConnMgr
+
+	conns          *prometheus.Desc
+	peers          *prometheus.Desc
+	protected      *prometheus.Desc
+	trims          *prometheus.Desc
+	closed         *prometheus.Desc
+	sinceLastTrim  *prometheus.Desc
+	protocolPeers  *prometheus.Desc
+	protocolMinima *prometheus.Desc
+	protocolConns  *prometheus.Desc
+	churnRate      *prometheus.Desc
+	connects       *prometheus.Desc
+	disconnects    *prometheus.Desc
+	sessions       *prometheus.Desc
+	planSeconds    *prometheus.Desc
+	closeSeconds   *prometheus.Desc
+	candidates     *prometheus.Desc
+	lookups        *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector returns a collector for the metrics of cm.
+func NewCollector(cm *connmgr.PhoreConnMgr) *Collector {
+	return &Collector{
+		cm: cm,
+
+		conns: prometheus.NewDesc(namespace+"_connections",
+			"Number of connections tracked.", nil, nil),
+		peers: prometheus.NewDesc(namespace+"_peers",
+			"Number of peers tracked, including peers tagged but not connected.", nil, nil),
+		protected: prometheus.NewDesc(namespace+"_protected_peers",
+			"Number of peers protected under at least one tag.", nil, nil),
+		trims: prometheus.NewDesc(namespace+"_trims_total",
+			"Number of trims performed.", nil, nil),
+		closed: prometheus.NewDesc(namespace+"_closed_connections_total",
+			"Number of connections closed by trims.", nil, nil),
+		sinceLastTrim: prometheus.NewDesc(namespace+"_seconds_since_last_trim",
+			"Time elapsed since the last trim completed, absent if none did.", nil, nil),
+		protocolPeers: prometheus.NewDesc(namespace+"_protocol_peers",
+			"Number of peers counted toward the minimum of a protocol.", []string{"protocol"}, nil),
+		protocolMinima: prometheus.NewDesc(namespace+"_protocol_minimum_peers",
+			"Minimum number of peers trims must leave connected for a protocol.", []string{"protocol"}, nil),
+		protocolConns: prometheus.NewDesc(namespace+"_protocol_connected_peers",
+			"Number of connected peers supporting a protocol gauged with WithProtocolGauges.", []string{"protocol"}, nil),
+		churnRate: prometheus.NewDesc(namespace+"_churn_rate",
+			"Number of peers connecting and disconnecting per second within the churn window.", nil, nil),
+		connects: prometheus.NewDesc(namespace+"_peer_connects_total",
+			"Number of peers that connected.", nil, nil),
+		disconnects: prometheus.NewDesc(namespace+"_peer_disconnects_total",
+			"Number of peers that disconnected their last connection.", nil, nil),
+		sessions: prometheus.NewDesc(namespace+"_session_seconds",
+			"Length of the sessions of peers, from their first connection to their last.", nil, nil),
+		planSeconds: prometheus.NewDesc(namespace+"_trim_plan_seconds_total",
+			"Time spent by trims selecting the connections to close.", nil, nil),
+		closeSeconds: prometheus.NewDesc(namespace+"_trim_close_seconds_total",
+			"Time spent by trims closing connections.", nil, nil),
+		candidates: prometheus.NewDesc(namespace+"_trim_candidates_total",
+			"Number of peers considered for pruning by trims.", nil, nil),
+		lookups: prometheus.NewDesc(namespace+"_trim_peerstore_lookups_total",
+			"Number of peerstore lookups made by trims.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.conns
+	ch <- c.peers
+	ch <- c.protected
+	ch <- c.trims
+	ch <- c.closed
+	ch <- c.sinceLastTrim
+	ch <- c.protocolPeers
+	ch <- c.protocolMinima
+	ch <- c.protocolConns
+	ch <- c.churnRate
+	ch <- c.connects
+	ch <- c.disconnects
+	ch <- c.sessions
+	ch <- c.planSeconds
+	ch <- c.closeSeconds
+	ch <- c.candidates
+	ch <- c.lookups
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	info := c.cm.GetInfo()
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(info.ConnCount))
+	ch <- prometheus.MustNewConstMetric(c.peers, prometheus.GaugeValue, float64(info.TrackedPeers))
+	ch <- prometheus.MustNewConstMetric(c.protected, prometheus.GaugeValue, float64(info.ProtectedPeers))
+	ch <- prometheus.MustNewConstMetric(c.trims, prometheus.CounterValue, float64(info.TotalTrims))
+	ch <- prometheus.MustNewConstMetric(c.closed, prometheus.CounterValue, float64(info.TotalClosed))
+	if h := c.cm.Health(); !h.LastTrim.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.sinceLastTrim, prometheus.GaugeValue, h.SinceLastTrim.Seconds())
+	}
+	for proto, pc := range info.ProtocolCounts {
+		ch <- prometheus.MustNewConstMetric(c.protocolPeers, prometheus.GaugeValue, float64(pc.Connected), string(proto))
+		ch <- prometheus.MustNewConstMetric(c.protocolMinima, prometheus.GaugeValue, float64(pc.Minimum), string(proto))
+	}
+	for proto, n := range info.ProtocolPeers {
+		ch <- prometheus.MustNewConstMetric(c.protocolConns, prometheus.GaugeValue, float64(n), string(proto))
+	}
+
+	churn := info.Churn
+	ch <- prometheus.MustNewConstMetric(c.churnRate, prometheus.GaugeValue, churn.Rate)
+	ch <- prometheus.MustNewConstMetric(c.connects, prometheus.CounterValue, float64(churn.TotalConnects))
+	ch <- prometheus.MustNewConstMetric(c.disconnects, prometheus.CounterValue, float64(churn.TotalDisconnects))
+	buckets := make(map[float64]uint64, len(connmgr.SessionBuckets))
+	var cumulative uint64
+	for i, bound := range connmgr.SessionBuckets {
+		cumulative += uint64(churn.Sessions.Counts[i])
+		buckets[bound.Seconds()] = cumulative
+	}
+	ch <- prometheus.MustNewConstHistogram(c.sessions, uint64(churn.Sessions.Count), churn.Sessions.Sum.Seconds(), buckets)
+
+	cost := info.TotalTrimCost
+	ch <- prometheus.MustNewConstMetric(c.planSeconds, prometheus.CounterValue, time.Duration(cost.Plan).Seconds())
+	ch <- prometheus.MustNewConstMetric(c.closeSeconds, prometheus.CounterValue, time.Duration(cost.Close).Seconds())
+	ch <- prometheus.MustNewConstMetric(c.candidates, prometheus.CounterValue, float64(cost.Candidates))
+	ch <- prometheus.MustNewConstMetric(c.lookups, prometheus.CounterValue, float64(cost.PeerstoreLookups))
+}
+
+// WithRegisterer registers a Collector for the connection manager with reg. A failure to
+// register, such as when another connection manager already registered its metrics, is
+// logged.
+func WithRegisterer(reg prometheus.Registerer) connmgr.Option {
+	return func(cm *connmgr.PhoreConnMgr) {
+		if err := reg.Register(NewCollector(cm)); err != nil {
+			log.Errorf("failed to register connection manager metrics: %s", err)
+		}
+	}
+}
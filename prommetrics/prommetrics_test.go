@@ -0,0 +1,73 @@
+package prommetrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	connmgr "github.com/phoreproject/go-phore-connmgr"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := connmgr.NewConnManager(1, 2, 0, ps, map[protocol.ID]int{"/sync/1.0.0": 1}, WithRegisterer(reg))
+	defer cm.Close()
+
+	cm.TagPeer(peer.ID("a"), "score", 1)
+	cm.Protect(peer.ID("b"), "keep")
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP connmgr_closed_connections_total Number of connections closed by trims.
+# TYPE connmgr_closed_connections_total counter
+connmgr_closed_connections_total 0
+# HELP connmgr_connections Number of connections tracked.
+# TYPE connmgr_connections gauge
+connmgr_connections 0
+# HELP connmgr_peers Number of peers tracked, including peers tagged but not connected.
+# TYPE connmgr_peers gauge
+connmgr_peers 1
+# HELP connmgr_protected_peers Number of peers protected under at least one tag.
+# TYPE connmgr_protected_peers gauge
+connmgr_protected_peers 1
+# HELP connmgr_protocol_minimum_peers Minimum number of peers trims must leave connected for a protocol.
+# TYPE connmgr_protocol_minimum_peers gauge
+connmgr_protocol_minimum_peers{protocol="/sync/1.0.0"} 1
+# HELP connmgr_protocol_peers Number of peers counted toward the minimum of a protocol.
+# TYPE connmgr_protocol_peers gauge
+connmgr_protocol_peers{protocol="/sync/1.0.0"} 0
+# HELP connmgr_trims_total Number of trims performed.
+# TYPE connmgr_trims_total counter
+connmgr_trims_total 0
+`), "connmgr_closed_connections_total", "connmgr_connections", "connmgr_peers", "connmgr_protected_peers",
+		"connmgr_protocol_minimum_peers", "connmgr_protocol_peers", "connmgr_trims_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	cm.TrimOpenConns(context.Background())
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP connmgr_trims_total Number of trims performed.
+# TYPE connmgr_trims_total counter
+connmgr_trims_total 1
+`), "connmgr_trims_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP connmgr_peer_connects_total Number of peers that connected.
+# TYPE connmgr_peer_connects_total counter
+connmgr_peer_connects_total 0
+`), "connmgr_peer_connects_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second connection manager cannot register the same metrics.
+	other := connmgr.NewConnManager(1, 2, 0, ps, nil, WithRegisterer(reg))
+	other.Close()
+}
@@ -0,0 +1,326 @@
+package connmgr
+
+import (
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// DialHint flags a protocol whose retained peers, after a trim, fall short of its
+// configured minimum or are all below the quality threshold set through WithDialHints.
+// Applications can use hints to proactively dial better peers for the protocol.
+type DialHint struct {
+	// Protocol is the protocol needing better peers.
+	Protocol protocol.ID
+
+	// Minimum is the configured minimum number of peers for Protocol.
+	Minimum int
+
+	// Retained is the number of peers reserved for Protocol by the trim.
+	Retained int
+
+	// BestValue is the highest value among the retained peers, or zero if none were
+	// retained.
+	BestValue int
+}
+
+// ProtocolCount compares, for a protocol with a configured minimum, the connected peers
+// supporting it to the minimum.
+type ProtocolCount struct {
+	// Connected is the number of peers supporting the protocol that count toward its
+	// minimum, according to the configured ProtocolAccounting.
+	Connected int
+
+	// Minimum is the configured minimum number of peers.
+	Minimum int
+}
+
+// Met reports whether the minimum is met.
+func (pc ProtocolCount) Met() bool {
+	return pc.Connected >= pc.Minimum
+}
+
+// protocolCounts counts the peers toward every configured protocol minimum, reading the
+// protocols they support from the peerstore. It returns nil if no minimum is
+// configured.
+func (cm *PhoreConnMgr) protocolCounts() map[protocol.ID]ProtocolCount {
+	cm.plk.RLock()
+	defer cm.plk.RUnlock()
+
+	var counts map[protocol.ID]ProtocolCount
+	for proto, min := range cm.minimumPeersForProtocol {
+		if min > 0 {
+			if counts == nil {
+				counts = make(map[protocol.ID]ProtocolCount)
+			}
+			counts[proto] = ProtocolCount{Minimum: min}
+		}
+	}
+	if counts == nil {
+		return nil
+	}
+
+	now := cm.clock.Now()
+	grace, _ := cm.timing()
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+		for id, inf := range s.peers {
+			if !cm.countsTowardMinimums(inf, now, grace) {
+				continue
+			}
+			for _, proto := range cm.minimumProtocolsOf(id) {
+				pc := counts[proto]
+				pc.Connected++
+				counts[proto] = pc
+			}
+		}
+		s.Unlock()
+	}
+	return counts
+}
+
+// protocolContender is a peer that supports at least one protocol with a configured
+// minimum, competing for one of the slots reserved for those protocols.
+type protocolContender struct {
+	peer   PeerSnapshot
+	protos []protocol.ID // supported protocols that have a minimum
+}
+
+// minimumProtocolsOf returns the protocols supported by p that have a minimum
+// configured. cm.plk must be held by the caller.
+func (cm *PhoreConnMgr) minimumProtocolsOf(p peer.ID) []protocol.ID {
+	supported, err := cm.getProtocols(p)
+	if err != nil {
+		return nil
+	}
+
+	var protos []protocol.ID
+	for _, sp := range supported {
+		id := protocol.ID(sp)
+		if cm.minimumPeersForProtocol[id] > 0 {
+			protos = append(protos, id)
+		}
+	}
+	return protos
+}
+
+// ProtectProtocol exempts from trimming all the peers supporting proto according to the
+// peerstore, like Protect does for individual peers. Such peers still count toward the
+// protocol minimums.
+func (cm *PhoreConnMgr) ProtectProtocol(proto protocol.ID) {
+	cm.plk.Lock()
+	defer cm.plk.Unlock()
+
+	if cm.protectedProtocols == nil {
+		cm.protectedProtocols = make(map[protocol.ID]struct{})
+	}
+	cm.protectedProtocols[proto] = struct{}{}
+}
+
+// UnprotectProtocol reverts ProtectProtocol. Peers may still be protected individually,
+// or through another protocol.
+func (cm *PhoreConnMgr) UnprotectProtocol(proto protocol.ID) {
+	cm.plk.Lock()
+	defer cm.plk.Unlock()
+
+	delete(cm.protectedProtocols, proto)
+}
+
+// SetMinimumPeersForProtocol sets the minimum number of peers supporting proto that
+// trims must leave connected, taking effect from the next trim onwards. A minimum of
+// zero or less reserves no peers.
+func (cm *PhoreConnMgr) SetMinimumPeersForProtocol(proto protocol.ID, n int) {
+	cm.updateMinimums(func(minimums map[protocol.ID]int) {
+		minimums[proto] = n
+	})
+}
+
+// RemoveProtocolMinimum removes the minimum set for proto, if any, so that future trims
+// stop reserving peers for it.
+func (cm *PhoreConnMgr) RemoveProtocolMinimum(proto protocol.ID) {
+	cm.updateMinimums(func(minimums map[protocol.ID]int) {
+		delete(minimums, proto)
+	})
+}
+
+// ProtocolMinimums returns a copy of the protocol minimums in effect.
+func (cm *PhoreConnMgr) ProtocolMinimums() map[protocol.ID]int {
+	cm.plk.RLock()
+	defer cm.plk.RUnlock()
+
+	return copyMinimums(cm.minimumPeersForProtocol)
+}
+
+// updateMinimums replaces the protocol minimums with a copy modified by update. The map
+// may be shared with the caller of NewConnManager, so it is never updated in place.
+func (cm *PhoreConnMgr) updateMinimums(update func(map[protocol.ID]int)) {
+	cm.plk.Lock()
+	defer cm.plk.Unlock()
+
+	minimums := copyMinimums(cm.minimumPeersForProtocol)
+	update(minimums)
+	cm.minimumPeersForProtocol = minimums
+}
+
+func copyMinimums(minimums map[protocol.ID]int) map[protocol.ID]int {
+	cp := make(map[protocol.ID]int, len(minimums))
+	for p, min := range minimums {
+		cp[p] = min
+	}
+	return cp
+}
+
+// protectedByProtocol reports whether p supports a protocol protected through
+// ProtectProtocol. cm.plk must be held by the caller.
+func (cm *PhoreConnMgr) protectedByProtocol(p peer.ID) bool {
+	if len(cm.protectedProtocols) == 0 {
+		return false
+	}
+	supported, err := cm.getProtocols(p)
+	if err != nil {
+		return false
+	}
+	for _, sp := range supported {
+		if _, ok := cm.protectedProtocols[protocol.ID(sp)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reserveForProtocols keeps the highest scoring contenders needed to satisfy every
+// protocol minimum, and appends the remaining contenders to candidates. A peer that is
+// reserved for one protocol also counts toward all other protocols it supports.
+// Contenders of equal score are considered by ascending peer ID, so the outcome does not
+// depend on the order in which peers were collected.
+//
+// Protected peers are never pruned, so they fill their protocols' slots before any
+// contender is considered.
+//
+// It returns the extended candidate list, as well as the dial hints for protocols
+// left short of good peers when a hint handler or an event bus is configured. cm.plk
+// must be held by the caller.
+func (cm *PhoreConnMgr) reserveForProtocols(protected, contenders []protocolContender, candidates []PeerSnapshot) ([]PeerSnapshot, []DialHint) {
+	sort.Slice(contenders, func(i, j int) bool {
+		left, right := contenders[i].peer, contenders[j].peer
+		if left.Score != right.Score {
+			return left.Score > right.Score
+		}
+		return left.ID < right.ID
+	})
+
+	retained := make(map[protocol.ID]int)
+	best := make(map[protocol.ID]int)
+	retain := func(c protocolContender) {
+		for _, p := range c.protos {
+			if retained[p] == 0 || c.peer.Value > best[p] {
+				best[p] = c.peer.Value
+			}
+			retained[p]++
+		}
+	}
+	for _, c := range protected {
+		retain(c)
+	}
+	for _, c := range contenders {
+		keep := false
+		for _, p := range c.protos {
+			if retained[p] < cm.minimumPeersForProtocol[p] {
+				keep = true
+				break
+			}
+		}
+		if !keep {
+			candidates = append(candidates, c.peer)
+			continue
+		}
+		retain(c)
+	}
+
+	if cm.dialHints == nil && cm.events == nil {
+		return candidates, nil
+	}
+
+	var hints []DialHint
+	for p, min := range cm.minimumPeersForProtocol {
+		if min <= 0 {
+			continue
+		}
+		if retained[p] >= min && best[p] >= cm.dialHintThreshold {
+			continue
+		}
+		hints = append(hints, DialHint{
+			Protocol:  p,
+			Minimum:   min,
+			Retained:  retained[p],
+			BestValue: best[p],
+		})
+	}
+	sort.Slice(hints, func(i, j int) bool {
+		return hints[i].Protocol < hints[j].Protocol
+	})
+	return candidates, hints
+}
+
+// cappedProtocolsOf returns the protocols supported by p that have a maximum
+// configured. cm.plk must be held by the caller.
+func (cm *PhoreConnMgr) cappedProtocolsOf(p peer.ID) []protocol.ID {
+	supported, err := cm.getProtocols(p)
+	if err != nil {
+		return nil
+	}
+
+	var protos []protocol.ID
+	for _, sp := range supported {
+		id := protocol.ID(sp)
+		if _, ok := cm.maximumPeersForProtocol[id]; ok {
+			protos = append(protos, id)
+		}
+	}
+	return protos
+}
+
+// evictOverMaximums removes from candidates, lowest score first, the peers supporting
+// a protocol that has more peers than its configured maximum, until no protocol does
+// or no such candidate is left. capped holds the capped protocols of each candidate,
+// and counts the number of connected peers supporting each capped protocol; counts is
+// updated as peers are evicted. cm.plk must be held by the caller.
+func (cm *PhoreConnMgr) evictOverMaximums(candidates []PeerSnapshot, capped map[peer.ID][]protocol.ID, counts map[protocol.ID]int) (evicted, rest []PeerSnapshot) {
+	ordered := make([]PeerSnapshot, len(candidates))
+	copy(ordered, candidates)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Score < ordered[j].Score
+	})
+
+	evictedSet := make(map[peer.ID]struct{})
+	for _, c := range ordered {
+		over := false
+		for _, p := range capped[c.ID] {
+			if counts[p] > cm.maximumPeersForProtocol[p] {
+				over = true
+				break
+			}
+		}
+		if !over {
+			continue
+		}
+
+		for _, p := range capped[c.ID] {
+			counts[p]--
+		}
+		evicted = append(evicted, c)
+		evictedSet[c.ID] = struct{}{}
+	}
+	if len(evicted) == 0 {
+		return nil, candidates
+	}
+
+	rest = make([]PeerSnapshot, 0, len(candidates)-len(evicted))
+	for _, c := range candidates {
+		if _, ok := evictedSet[c.ID]; !ok {
+			rest = append(rest, c)
+		}
+	}
+	return evicted, rest
+}
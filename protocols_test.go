@@ -0,0 +1,377 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestProtocolReservationKeepsBestPeersAndHints(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+
+	var hints []DialHint
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{
+		"/phore/1.0.0": 2,
+	}, WithDialHints(10, func(h []DialHint) { hints = h }))
+	not := cm.Notifee()
+
+	var phoreConns []network.Conn
+	for i := 0; i < 5; i++ {
+		rc := randConn(t, nil)
+		phoreConns = append(phoreConns, rc)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "score", i+1)
+		if err := ps.AddProtocols(rc.RemotePeer(), "/phore/1.0.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		rc := randConn(t, nil)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "score", 100)
+	}
+
+	cm.TrimOpenConns(context.Background())
+
+	for i, c := range phoreConns {
+		if shouldClose := i < 3; c.(*tconn).closed != shouldClose {
+			t.Errorf("phore peer with value %d: expected closed=%v", i+1, shouldClose)
+		}
+	}
+
+	if len(hints) != 1 {
+		t.Fatalf("expected one dial hint, got %d", len(hints))
+	}
+	if h := hints[0]; h.Protocol != "/phore/1.0.0" || h.Retained != 2 || h.BestValue != 5 || h.Minimum != 2 {
+		t.Fatalf("unexpected dial hint: %+v", h)
+	}
+}
+
+func TestProtocolMaximumsEvictBelowWatermarks(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithProtocolMaximums(map[protocol.ID]int{
+		"/phore/1.0.0": 2,
+	}))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var phoreConns []network.Conn
+	for i := 0; i < 4; i++ {
+		rc := randConn(t, nil)
+		phoreConns = append(phoreConns, rc)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "score", i+1)
+		if err := ps.AddProtocols(rc.RemotePeer(), "/phore/1.0.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the worst phore peer is protected, it counts toward the maximum all the same.
+	cm.Protect(phoreConns[0].RemotePeer(), "test")
+
+	other := randConn(t, nil)
+	not.Connected(nil, other)
+
+	cm.TrimOpenConns(context.Background())
+
+	for i, c := range phoreConns {
+		if shouldClose := i == 1 || i == 2; c.(*tconn).closed != shouldClose {
+			t.Errorf("phore peer with value %d: expected closed=%v", i+1, shouldClose)
+		}
+	}
+	if other.(*tconn).closed {
+		t.Fatal("expected the peer without capped protocols to be kept")
+	}
+}
+
+func TestTransientConns(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	transient := make(map[network.Conn]bool)
+	cm := NewConnManager(2, 2, 0, ps, map[protocol.ID]int{
+		"/phore/1.0.0": 1,
+	}, WithTransientConns(func(c network.Conn) bool {
+		return transient[c]
+	}, ExcludeTransientFromMinimums|PruneTransientFirst))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// a valuable phore peer over a transient connection, and a cheaper full one.
+	limited, full := randConn(t, nil), randConn(t, nil)
+	transient[limited] = true
+	for _, c := range []network.Conn{limited, full} {
+		not.Connected(nil, c)
+		if err := ps.AddProtocols(c.RemotePeer(), "/phore/1.0.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cm.TagPeer(limited.RemotePeer(), "score", 100)
+	cm.TagPeer(full.RemotePeer(), "score", 1)
+
+	var others []network.Conn
+	for i := 0; i < 2; i++ {
+		rc := randConn(t, nil)
+		others = append(others, rc)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "score", 50)
+	}
+
+	cm.TrimOpenConns(context.Background())
+
+	if !limited.(*tconn).closed {
+		t.Fatal("expected the transient peer to be pruned first")
+	}
+	if full.(*tconn).closed {
+		t.Fatal("expected the full connection to be reserved for the protocol minimum")
+	}
+	if others[0].(*tconn).closed == others[1].(*tconn).closed {
+		t.Fatal("expected exactly one of the other peers to be pruned")
+	}
+}
+
+func TestProtocolReservationIsDeterministic(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+
+	var conns []network.Conn
+	for i := 0; i < 10; i++ {
+		rc := randConn(t, nil)
+		conns = append(conns, rc)
+		if err := ps.AddProtocols(rc.RemotePeer(), "/phore/1.0.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// peers of equal value compete for the reserved slots over several managers, which
+	// visit them in different orders.
+	var retained map[peer.ID]bool
+	for round := 0; round < 5; round++ {
+		cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{
+			"/phore/1.0.0": 3,
+		}, WithSegments(1, nil))
+		not := cm.Notifee()
+		for _, c := range conns {
+			c.(*tconn).closed = false
+			not.Connected(nil, c)
+			cm.TagPeer(c.RemotePeer(), "score", 1)
+		}
+		cm.TrimOpenConns(context.Background())
+
+		kept := make(map[peer.ID]bool)
+		for _, c := range conns {
+			if !c.(*tconn).closed {
+				kept[c.RemotePeer()] = true
+			}
+		}
+		if len(kept) != 3 {
+			t.Fatalf("expected 3 reserved peers, got %d", len(kept))
+		}
+		if retained != nil {
+			for p := range kept {
+				if !retained[p] {
+					t.Fatalf("round %d retained a different set of peers", round)
+				}
+			}
+		}
+		retained = kept
+		cm.Close()
+	}
+}
+
+func TestProtectedPeersCountTowardMinimums(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{
+		"/phore/1.0.0": 2,
+	})
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var phoreConns []network.Conn
+	for i := 0; i < 4; i++ {
+		rc := randConn(t, nil)
+		phoreConns = append(phoreConns, rc)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "score", i)
+		if err := ps.AddProtocols(rc.RemotePeer(), "/phore/1.0.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cm.Protect(phoreConns[0].RemotePeer(), "test")
+	for i := 0; i < 5; i++ {
+		rc := randConn(t, nil)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "score", 100)
+	}
+
+	cm.TrimOpenConns(context.Background())
+
+	// the protected peer takes one of the two slots, leaving one for the best of the
+	// others.
+	for i, c := range phoreConns {
+		if shouldClose := i == 1 || i == 2; c.(*tconn).closed != shouldClose {
+			t.Errorf("phore peer with value %d: expected closed=%v", i, shouldClose)
+		}
+	}
+}
+
+func TestProtectProtocol(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithSilencePeriod(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var quorum []network.Conn
+	for i := 0; i < 3; i++ {
+		rc := randConn(t, not.Disconnected)
+		quorum = append(quorum, rc)
+		not.Connected(nil, rc)
+		if err := ps.AddProtocols(rc.RemotePeer(), "/phore/quorum/1.0.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := randConn(t, not.Disconnected)
+	not.Connected(nil, other)
+	cm.TagPeer(other.RemotePeer(), "score", 100)
+
+	cm.ProtectProtocol("/phore/quorum/1.0.0")
+	cm.TrimOpenConns(context.Background())
+	for _, c := range quorum {
+		if c.(*tconn).closed {
+			t.Fatal("expected the peers of the protected protocol to be kept")
+		}
+	}
+	if !other.(*tconn).closed {
+		t.Fatal("expected the other peer to be pruned")
+	}
+
+	cm.UnprotectProtocol("/phore/quorum/1.0.0")
+	cm.TrimOpenConns(context.Background())
+	if n := cm.GetInfo().ConnCount; n != 1 {
+		t.Fatalf("expected a trim down to one connection once unprotected, got %d", n)
+	}
+}
+
+func TestProtocolCountsInInfo(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{
+		"/phore/1.0.0": 3,
+		"/sync/1.0.0":  1,
+	})
+	not := cm.Notifee()
+
+	for i := 0; i < 2; i++ {
+		rc := randConn(t, nil)
+		not.Connected(nil, rc)
+		if err := ps.AddProtocols(rc.RemotePeer(), "/phore/1.0.0", "/sync/1.0.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	not.Connected(nil, randConn(t, nil))
+
+	counts := cm.GetInfo().ProtocolCounts
+	if len(counts) != 2 {
+		t.Fatalf("expected counts for two protocols, got %v", counts)
+	}
+	if pc := counts["/phore/1.0.0"]; pc.Connected != 2 || pc.Minimum != 3 || pc.Met() {
+		t.Errorf("unexpected count for /phore/1.0.0: %+v", pc)
+	}
+	if pc := counts["/sync/1.0.0"]; pc.Connected != 2 || pc.Minimum != 1 || !pc.Met() {
+		t.Errorf("unexpected count for /sync/1.0.0: %+v", pc)
+	}
+
+	if counts := NewConnManager(10, 20, 0, ps, nil).GetInfo().ProtocolCounts; counts != nil {
+		t.Errorf("expected no counts without minimums, got %v", counts)
+	}
+}
+
+func TestSetMinimumPeersForProtocol(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	minimums := map[protocol.ID]int{}
+	cm := NewConnManager(1, 1, 0, ps, minimums, WithSilencePeriod(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var syncConns []network.Conn
+	for i := 0; i < 4; i++ {
+		rc := randConn(t, nil)
+		not.Connected(nil, rc)
+		if i < 2 {
+			syncConns = append(syncConns, rc)
+			if err := ps.AddProtocols(rc.RemotePeer(), "/sync/1.0.0"); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			cm.TagPeer(rc.RemotePeer(), "score", 10)
+		}
+	}
+
+	cm.SetMinimumPeersForProtocol("/sync/1.0.0", 2)
+	if len(minimums) != 0 {
+		t.Fatal("expected the map passed to NewConnManager to be left alone")
+	}
+	if pc := cm.GetInfo().ProtocolCounts["/sync/1.0.0"]; pc.Minimum != 2 {
+		t.Fatalf("expected the minimum to be 2, got %+v", pc)
+	}
+
+	cm.TrimOpenConns(context.Background())
+	for _, c := range syncConns {
+		if c.(*tconn).closed {
+			t.Fatal("expected the sync peers to be reserved")
+		}
+	}
+
+	cm.SetMinimumPeersForProtocol("/sync/1.0.0", 0)
+	cm.TrimOpenConns(context.Background())
+	for _, c := range syncConns {
+		if !c.(*tconn).closed {
+			t.Fatal("expected the sync peers to be pruned once the minimum is lowered")
+		}
+	}
+}
+
+func TestRemoveProtocolMinimum(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{
+		"/sync/1.0.0":  2,
+		"/phore/1.0.0": 3,
+	})
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var syncConns []network.Conn
+	for i := 0; i < 4; i++ {
+		rc := randConn(t, nil)
+		not.Connected(nil, rc)
+		if i < 2 {
+			syncConns = append(syncConns, rc)
+			if err := ps.AddProtocols(rc.RemotePeer(), "/sync/1.0.0"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	cm.RemoveProtocolMinimum("/sync/1.0.0")
+	minimums := cm.ProtocolMinimums()
+	if len(minimums) != 1 || minimums["/phore/1.0.0"] != 3 {
+		t.Fatalf("unexpected minimums: %v", minimums)
+	}
+	// the returned map is a copy.
+	minimums["/other/1.0.0"] = 1
+	if _, ok := cm.ProtocolMinimums()["/other/1.0.0"]; ok {
+		t.Fatal("expected changes to the returned map not to take effect")
+	}
+
+	cm.TrimOpenConns(context.Background())
+	closed := 0
+	for _, c := range syncConns {
+		if c.(*tconn).closed {
+			closed++
+		}
+	}
+	if closed == 0 {
+		t.Fatal("expected the sync peers to no longer be reserved")
+	}
+}
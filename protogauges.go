@@ -0,0 +1,128 @@
+package connmgr
+
+import (
+	"context"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// protocolGauges keeps, for the protocols configured with WithProtocolGauges, the set
+// of connected peers supporting them.
+type protocolGauges struct {
+	sync.Mutex
+	protos []string
+	peers  map[protocol.ID]map[peer.ID]struct{}
+}
+
+// updateProtocolGauges reads the protocols p supports from the peerstore, and updates
+// the gauges accordingly: p is counted for the gauged protocols it supports while it is
+// connected. It must not be called with the segment of p locked.
+func (cm *PhoreConnMgr) updateProtocolGauges(p peer.ID) {
+	g := cm.protoGauges
+	if g == nil {
+		return
+	}
+
+	var supported []string
+	if cm.isConnected(p) {
+		var err error
+		if supported, err = cm.peerstore.SupportsProtocols(p, g.protos...); err != nil {
+			log.Debugf("failed to read the protocols of %s: %s", p, err)
+			return
+		}
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	for proto, peers := range g.peers {
+		delete(peers, p)
+		for _, s := range supported {
+			if protocol.ID(s) == proto {
+				peers[p] = struct{}{}
+				break
+			}
+		}
+	}
+}
+
+// refreshProtocolGauges updates the gauges for every tracked peer, and drops the peers
+// no longer tracked, catching up with protocol changes no event was received for. It is
+// called by the background loop.
+func (cm *PhoreConnMgr) refreshProtocolGauges() {
+	g := cm.protoGauges
+	if g == nil {
+		return
+	}
+
+	tracked := make(map[peer.ID]struct{})
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+		for id := range s.peers {
+			tracked[id] = struct{}{}
+		}
+		s.Unlock()
+	}
+	g.Lock()
+	for _, peers := range g.peers {
+		for id := range peers {
+			tracked[id] = struct{}{}
+		}
+	}
+	g.Unlock()
+
+	for id := range tracked {
+		cm.updateProtocolGauges(id)
+	}
+}
+
+// ProtocolPeers returns the number of connected peers supporting each protocol
+// configured with WithProtocolGauges, nil if none is.
+func (cm *PhoreConnMgr) ProtocolPeers() map[protocol.ID]int {
+	g := cm.protoGauges
+	if g == nil {
+		return nil
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	counts := make(map[protocol.ID]int, len(g.peers))
+	for proto, peers := range g.peers {
+		counts[proto] = len(peers)
+	}
+	return counts
+}
+
+// SubscribeProtocolUpdates updates the protocol gauges from the EvtPeerProtocolsUpdated
+// events of bus, emitted by identify as peers change their protocols, until the
+// connection manager is closed. Without a subscription, the gauges catch up with
+// protocol changes on every tick of the background loop.
+func (cm *PhoreConnMgr) SubscribeProtocolUpdates(bus event.Bus) error {
+	sub, err := bus.Subscribe(new(event.EvtPeerProtocolsUpdated))
+	if err != nil {
+		return err
+	}
+	if !cm.enter() {
+		sub.Close()
+		return cm.ctx.Err()
+	}
+
+	cm.goLabelled("protocol-updates", func(context.Context) {
+		defer cm.running.Done()
+		defer sub.Close()
+		for {
+			select {
+			case evt, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				cm.updateProtocolGauges(evt.(event.EvtPeerProtocolsUpdated).Peer)
+			case <-cm.ctx.Done():
+				return
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,39 @@
+package connmgr
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestProtocolGauges(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithTrimInterval(0), WithProtocolGauges("/sync/1.0.0", "/tx/1.0.0"))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	syncer := randConn(t, nil)
+	ps.AddProtocols(syncer.RemotePeer(), "/sync/1.0.0", "/tx/1.0.0")
+	not.Connected(nil, syncer)
+	other := randConn(t, nil)
+	not.Connected(nil, other)
+
+	counts := cm.GetInfo().ProtocolPeers
+	if len(counts) != 2 || counts["/sync/1.0.0"] != 1 || counts["/tx/1.0.0"] != 1 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+
+	// identify reports the protocols of the other peer after it connected.
+	ps.AddProtocols(other.RemotePeer(), "/sync/1.0.0")
+	cm.refreshProtocolGauges()
+	if counts := cm.ProtocolPeers(); counts["/sync/1.0.0"] != 2 {
+		t.Fatalf("expected the refresh to count the other peer, got %v", counts)
+	}
+
+	not.Disconnected(nil, syncer)
+	if counts := cm.ProtocolPeers(); counts["/sync/1.0.0"] != 1 || counts["/tx/1.0.0"] != 0 {
+		t.Fatalf("expected the disconnected peer to no longer count, got %v", counts)
+	}
+}
@@ -0,0 +1,163 @@
+package connmgr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// PrunedPeer describes a peer whose connections were closed by a trim.
+type PrunedPeer struct {
+	Peer peer.ID
+
+	// Conns are the connections of the peer closed by the trim.
+	Conns []network.Conn
+
+	// Value is the sum of the tag values of the peer.
+	Value int
+
+	// Score is the score the peer was ordered by.
+	Score float64
+
+	// Reason tells which rule selected the first of the connections.
+	Reason CloseReason
+
+	// TopTags are the tags contributing the most to Value, see TagContribution.
+	TopTags []TagContribution
+}
+
+// topTagCount is the number of tags reported in PrunedPeer.TopTags.
+const topTagCount = 3
+
+// TagContribution is the part of the value of a peer owed to one of its tags: the value
+// of the tag, weighed as configured through WithNamespaceWeights.
+type TagContribution struct {
+	Tag   string `json:"tag"`
+	Value int    `json:"value"`
+}
+
+// topTags returns the topTagCount tags of p contributing the most to its value, in
+// absolute terms, largest first.
+func (cm *PhoreConnMgr) topTags(p PeerSnapshot) []TagContribution {
+	if len(p.Tags) == 0 {
+		return nil
+	}
+	tags := make([]TagContribution, 0, len(p.Tags))
+	for tag, v := range p.Tags {
+		tags = append(tags, TagContribution{Tag: tag, Value: cm.weighTag(tag, v)})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		ai, aj := abs(tags[i].Value), abs(tags[j].Value)
+		if ai != aj {
+			return ai > aj
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	if len(tags) > topTagCount {
+		tags = tags[:topTagCount]
+	}
+	return tags
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// formatTopTags formats tags as tag=value pairs.
+func formatTopTags(tags []TagContribution) string {
+	if len(tags) == 0 {
+		return "none"
+	}
+	parts := make([]string, len(tags))
+	for i, t := range tags {
+		parts[i] = fmt.Sprintf("%s=%d", t.Tag, t.Value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// explainSelection computes the top tags of the peers the plan closes connections of.
+func (cm *PhoreConnMgr) explainSelection(plan *trimPlan) {
+	plan.topTags = make(map[peer.ID][]TagContribution)
+	for _, c := range plan.conns {
+		id := c.RemotePeer()
+		if _, ok := plan.topTags[id]; !ok {
+			plan.topTags[id] = cm.topTags(plan.selected[id])
+		}
+	}
+}
+
+// OnPeerPruned is called after a trim closed the connections of a peer. Callbacks run
+// on the goroutine of the trim, with no lock of the connection manager held, so they
+// may call back into it; slow work (such as dialing a replacement) belongs in a
+// goroutine of its own.
+type OnPeerPruned func(p PrunedPeer)
+
+// RegisterOnPeerPruned adds f to the callbacks notified of pruned peers, replacing any
+// callback previously registered under name.
+func (cm *PhoreConnMgr) RegisterOnPeerPruned(name string, f OnPeerPruned) {
+	cm.prunedHooksMu.Lock()
+	defer cm.prunedHooksMu.Unlock()
+
+	if cm.prunedHooks == nil {
+		cm.prunedHooks = make(map[string]OnPeerPruned)
+	}
+	cm.prunedHooks[name] = f
+}
+
+// UnregisterOnPeerPruned removes the callback registered under name, if any.
+func (cm *PhoreConnMgr) UnregisterOnPeerPruned(name string) {
+	cm.prunedHooksMu.Lock()
+	defer cm.prunedHooksMu.Unlock()
+
+	delete(cm.prunedHooks, name)
+}
+
+// notifyPruned calls the registered callbacks for every peer whose connections the
+// plan closed, in the order the plan closed them.
+func (cm *PhoreConnMgr) notifyPruned(plan trimPlan) {
+	cm.prunedHooksMu.RLock()
+	hooks := make([]OnPeerPruned, 0, len(cm.prunedHooks))
+	for _, f := range cm.prunedHooks {
+		hooks = append(hooks, f)
+	}
+	cm.prunedHooksMu.RUnlock()
+	if len(hooks) == 0 || len(plan.conns) == 0 {
+		return
+	}
+
+	for _, p := range plan.prunedPeers() {
+		for _, f := range hooks {
+			f(p)
+		}
+	}
+}
+
+// prunedPeers groups the connections the plan closes by peer.
+func (plan trimPlan) prunedPeers() []PrunedPeer {
+	var peers []PrunedPeer
+	index := make(map[peer.ID]int)
+	for _, c := range plan.conns {
+		id := c.RemotePeer()
+		if i, ok := index[id]; ok {
+			peers[i].Conns = append(peers[i].Conns, c)
+			continue
+		}
+		snap := plan.selected[id]
+		index[id] = len(peers)
+		peers = append(peers, PrunedPeer{
+			Peer:    id,
+			Conns:   []network.Conn{c},
+			Value:   snap.Value,
+			Score:   snap.Score,
+			Reason:  plan.reasons[c],
+			TopTags: plan.topTags[id],
+		})
+	}
+	return peers
+}
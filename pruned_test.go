@@ -0,0 +1,102 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestOnPeerPruned(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(2, 4, 0, ps, map[protocol.ID]int{}, WithSilencePeriod(0))
+	not := cm.Notifee()
+
+	var conns []network.Conn
+	for i := 0; i < 5; i++ {
+		rc := randConn(t, nil)
+		conns = append(conns, rc)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "score", i+1)
+	}
+
+	var pruned []PrunedPeer
+	cm.RegisterOnPeerPruned("test", func(p PrunedPeer) {
+		// callbacks run outside of the locks, so calling back in must not deadlock.
+		if cm.GetTagInfo(p.Peer) == nil {
+			t.Errorf("expected the pruned peer %s to still be tracked", p.Peer)
+		}
+		pruned = append(pruned, p)
+	})
+
+	cm.TrimOpenConns(context.Background())
+
+	if len(pruned) != 3 {
+		t.Fatalf("expected 3 pruned peers, got %d", len(pruned))
+	}
+	for i, p := range pruned {
+		if p.Peer != conns[i].RemotePeer() {
+			t.Errorf("pruned peer %d: expected %s, got %s", i, conns[i].RemotePeer(), p.Peer)
+		}
+		if len(p.Conns) != 1 || p.Conns[0] != conns[i] {
+			t.Errorf("pruned peer %d: unexpected conns %v", i, p.Conns)
+		}
+		if p.Value != i+1 || p.Reason != ReasonLowScore {
+			t.Errorf("pruned peer %d: unexpected value %d or reason %q", i, p.Value, p.Reason)
+		}
+	}
+
+	cm.UnregisterOnPeerPruned("test")
+	pruned = nil
+	cm.SetWatermarks(1, 1)
+	cm.TrimOpenConns(context.Background())
+	if pruned != nil {
+		t.Fatalf("expected no callbacks after unregistering, got %v", pruned)
+	}
+}
+
+func TestPrunedTopTags(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithTrimInterval(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	weak := randConn(t, nil)
+	not.Connected(nil, weak)
+	cm.TagPeer(weak.RemotePeer(), "a", 1)
+	cm.TagPeer(weak.RemotePeer(), "b", -7)
+	cm.TagPeer(weak.RemotePeer(), "c", 3)
+	cm.TagPeer(weak.RemotePeer(), "d", 3)
+	strong := randConn(t, nil)
+	not.Connected(nil, strong)
+	cm.TagPeer(strong.RemotePeer(), "a", 100)
+
+	var pruned []PrunedPeer
+	cm.RegisterOnPeerPruned("test", func(p PrunedPeer) {
+		pruned = append(pruned, p)
+	})
+	cm.TrimOpenConns(context.Background())
+
+	if len(pruned) != 1 || pruned[0].Peer != weak.RemotePeer() {
+		t.Fatalf("expected the weak peer to be pruned, got %+v", pruned)
+	}
+	want := []TagContribution{{"b", -7}, {"c", 3}, {"d", 3}}
+	got := pruned[0].TopTags
+	if len(got) != len(want) {
+		t.Fatalf("expected top tags %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected top tags %v, got %v", want, got)
+		}
+	}
+	if closed := cm.LastTrimDetails().Closed; len(closed[0].TopTags) != 3 || closed[0].TopTags[0].Tag != "b" {
+		t.Fatalf("expected the report to carry the top tags, got %+v", closed)
+	}
+	if s := formatTopTags(got); s != "b=-7 c=3 d=3" {
+		t.Fatalf("unexpected formatting %q", s)
+	}
+}
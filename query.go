@@ -0,0 +1,85 @@
+package connmgr
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// PeersWithTag returns the tracked peers carrying tag, whatever its value, in no
+// particular order.
+func (cm *PhoreConnMgr) PeersWithTag(tag string) []peer.ID {
+	return cm.peersWithTag(tag, func(int) bool { return true })
+}
+
+// PeersWithTagAbove returns the tracked peers carrying tag with a value of at least
+// min, in no particular order.
+func (cm *PhoreConnMgr) PeersWithTagAbove(tag string, min int) []peer.ID {
+	return cm.peersWithTag(tag, func(v int) bool { return v >= min })
+}
+
+// peersWithTag returns the tracked peers carrying tag with a value accepted by keep.
+func (cm *PhoreConnMgr) peersWithTag(tag string, keep func(int) bool) []peer.ID {
+	now := cm.clock.Now()
+
+	var peers []peer.ID
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+		for id, pi := range s.peers {
+			cm.expireTags(pi, now)
+			if v, ok := pi.tags[tag]; ok && keep(v) {
+				peers = append(peers, id)
+			}
+		}
+		s.Unlock()
+	}
+	return peers
+}
+
+// Peers returns a snapshot of every tracked peer, including the temporary entries
+// holding tags of peers not connected yet, in no particular order. All segments are
+// locked while the snapshot is taken, so that it reflects a single point in time. The
+// snapshots are not scored.
+func (cm *PhoreConnMgr) Peers() []PeerSnapshot {
+	now := cm.clock.Now()
+
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+	}
+	defer func() {
+		for _, s := range cm.segments.buckets {
+			s.Unlock()
+		}
+	}()
+
+	var peers []PeerSnapshot
+	for _, s := range cm.segments.buckets {
+		for _, pi := range s.peers {
+			cm.expireTags(pi, now)
+			peers = append(peers, pi.snapshot())
+		}
+	}
+	return peers
+}
+
+// PeersForProtocol returns the connected peers supporting proto according to the
+// peerstore, in no particular order. Temporary entries are left out.
+func (cm *PhoreConnMgr) PeersForProtocol(proto protocol.ID) []peer.ID {
+	var connected []peer.ID
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+		for id, pi := range s.peers {
+			if len(pi.conns) > 0 {
+				connected = append(connected, id)
+			}
+		}
+		s.Unlock()
+	}
+
+	var peers []peer.ID
+	for _, id := range connected {
+		if supported, err := cm.peerstore.SupportsProtocols(id, string(proto)); err == nil && len(supported) > 0 {
+			peers = append(peers, id)
+		}
+	}
+	return peers
+}
@@ -0,0 +1,128 @@
+package connmgr
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	tu "github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func sortedIDs(ids []peer.ID) []peer.ID {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestPeersWithTag(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{})
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var tagged []peer.ID
+	for i := 0; i < 5; i++ {
+		c := randConn(t, nil)
+		not.Connected(nil, c)
+		if i < 3 {
+			cm.TagPeer(c.RemotePeer(), "sync", i-1)
+			tagged = append(tagged, c.RemotePeer())
+		} else {
+			cm.TagPeer(c.RemotePeer(), "other", 10)
+		}
+	}
+
+	got := sortedIDs(cm.PeersWithTag("sync"))
+	want := sortedIDs(tagged)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d peers, got %d", len(want), len(got))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+
+	above := cm.PeersWithTagAbove("sync", 0)
+	if len(above) != 2 {
+		t.Fatalf("expected 2 peers with a non-negative sync tag, got %d", len(above))
+	}
+	if len(cm.PeersWithTag("missing")) != 0 {
+		t.Fatal("expected no peer carrying an unknown tag")
+	}
+}
+
+func TestPeers(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	c := randConn(t, nil)
+	not.Connected(nil, c)
+	not.Connected(nil, &tconn{peer: c.RemotePeer()})
+	cm.TagPeer(c.RemotePeer(), "score", 3)
+	early := randConn(t, nil).RemotePeer()
+	cm.TagPeer(early, "score", 5)
+
+	peers := cm.Peers()
+	if len(peers) != 2 {
+		t.Fatalf("expected 2 tracked peers, got %d", len(peers))
+	}
+	for _, p := range peers {
+		switch p.ID {
+		case c.RemotePeer():
+			if p.Temp || p.Value != 3 || len(p.Conns) != 2 || !p.FirstSeen.Equal(clock.Now()) {
+				t.Errorf("unexpected snapshot of the connected peer %+v", p)
+			}
+		case early:
+			if !p.Temp || p.Value != 5 || len(p.Conns) != 0 {
+				t.Errorf("unexpected snapshot of the temporary entry %+v", p)
+			}
+		default:
+			t.Errorf("unexpected peer %s", p.ID)
+		}
+	}
+}
+
+func TestPeersForProtocol(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{})
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var supporting []peer.ID
+	for i := 0; i < 4; i++ {
+		c := randConn(t, nil)
+		not.Connected(nil, c)
+		if i%2 == 0 {
+			if err := ps.AddProtocols(c.RemotePeer(), "/phore/1.0.0"); err != nil {
+				t.Fatal(err)
+			}
+			supporting = append(supporting, c.RemotePeer())
+		}
+	}
+	// peers that are not connected are left out, even if tracked.
+	early := tu.RandPeerIDFatal(t)
+	cm.TagPeer(early, "foo", 1)
+	if err := ps.AddProtocols(early, "/phore/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := sortedIDs(cm.PeersForProtocol("/phore/1.0.0"))
+	want := sortedIDs(supporting)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d peers, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %s, got %s", want[i], got[i])
+		}
+	}
+	if peers := cm.PeersForProtocol("/other/1.0.0"); len(peers) != 0 {
+		t.Errorf("expected no peers for an unsupported protocol, got %v", peers)
+	}
+}
@@ -0,0 +1,143 @@
+package connmgr
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// CloseReason tells which rule selected a connection to be closed by a trim.
+type CloseReason string
+
+const (
+	// ReasonLowScore is for connections of the lowest scoring peers, closed to reach
+	// the low watermark.
+	ReasonLowScore CloseReason = "lowest score"
+
+	// ReasonIdle is for connections of idle peers, see WithIdleTimeout.
+	ReasonIdle CloseReason = "idle"
+
+	// ReasonTransient is for transient connections pruned first, see
+	// WithTransientConns.
+	ReasonTransient CloseReason = "transient"
+
+	// ReasonRelayed is for relayed connections pruned first, see WithRelayPolicy.
+	ReasonRelayed CloseReason = "relayed"
+
+	// ReasonDuplicate is for redundant connections to a peer, see
+	// WithDuplicateConnPruning.
+	ReasonDuplicate CloseReason = "duplicate connection"
+
+	// ReasonRelayCap is for relayed connections over the cap set with WithRelayPolicy.
+	ReasonRelayCap CloseReason = "over relayed cap"
+
+	// ReasonSubnetCap is for peers over the cap set with WithSubnetDiversity.
+	ReasonSubnetCap CloseReason = "over subnet cap"
+
+	// ReasonCountryCap is for peers over the share set with WithGeoDiversity.
+	ReasonCountryCap CloseReason = "over country cap"
+
+	// ReasonProtocolMaximum is for peers over a maximum set with WithProtocolMaximums.
+	ReasonProtocolMaximum CloseReason = "over protocol maximum"
+
+	// ReasonInboundSurplus is for inbound connections exceeding the slots left to
+	// them, see WithReservedOutboundSlots.
+	ReasonInboundSurplus CloseReason = "inbound surplus"
+
+	// ReasonPolicy is for connections selected by a custom TrimPolicy.
+	ReasonPolicy CloseReason = "selected by policy"
+)
+
+// CandidateInfo describes a connection selected to be closed by a trim.
+type CandidateInfo struct {
+	Peer peer.ID
+	Conn network.Conn
+
+	// Value is the sum of the tag values of the peer.
+	Value int
+
+	// Score is the score the peer was ordered by.
+	Score float64
+
+	// Reason tells which rule selected the connection.
+	Reason CloseReason
+}
+
+// PreviewTrim runs the heuristics of TrimOpenConns as if a trim was due now, and returns
+// the connections it would close, in order, without closing anything. The silence
+// period, running trims and close interceptor are not taken into account. Expired tags
+// and cooldowns are dropped first, as a trim would.
+func (cm *PhoreConnMgr) PreviewTrim(ctx context.Context) []CandidateInfo {
+	cm.expireAllTags()
+	cm.expireCooldowns()
+	return cm.planTrim(ctx, trimOpts{}).candidates()
+}
+
+// PrunableCandidates returns the connections of the n peers that trims would prune
+// next, in order, whatever the watermarks: the first ones are those a trim would close
+// first once the count goes over the high watermark. It is meant for dashboards, and
+// for moving work off peers ahead of their pruning. Protected peers and peers in their
+// grace period are left out, and nothing is closed.
+func (cm *PhoreConnMgr) PrunableCandidates(n int) []CandidateInfo {
+	if n <= 0 {
+		return nil
+	}
+	cm.expireAllTags()
+	cm.expireCooldowns()
+	all := cm.planTrim(context.Background(), trimOpts{rankAll: true}).candidates()
+
+	peers := 0
+	for i, c := range all {
+		if i == 0 || c.Peer != all[i-1].Peer {
+			if peers == n {
+				return all[:i]
+			}
+			peers++
+		}
+	}
+	return all
+}
+
+// candidates describes the connections the plan closes.
+func (plan trimPlan) candidates() []CandidateInfo {
+	infos := make([]CandidateInfo, 0, len(plan.conns))
+	for _, c := range plan.conns {
+		p := plan.selected[c.RemotePeer()]
+		infos = append(infos, CandidateInfo{
+			Peer:   c.RemotePeer(),
+			Conn:   c,
+			Value:  p.Value,
+			Score:  p.Score,
+			Reason: plan.reasons[c],
+		})
+	}
+	return infos
+}
+
+// record remembers why the connections of the given peers were selected, if the
+// policy records reasons.
+func (bp builtinPolicy) record(peers []PeerSnapshot, reason CloseReason) {
+	if bp.reasons == nil {
+		return
+	}
+	for _, p := range peers {
+		for _, c := range p.Conns {
+			bp.reasons[c.Conn] = reason
+		}
+	}
+}
+
+// orderedReason tells why the built-in policy ordered p toward the front of the trim.
+func (bp builtinPolicy) orderedReason(p PeerSnapshot) CloseReason {
+	switch {
+	case p.Idle:
+		return ReasonIdle
+	case bp.cm.transientHandling&PruneTransientFirst != 0 && p.Transient():
+		return ReasonTransient
+	case bp.cm.relayPolicy == PruneRelayedFirst && p.Relayed():
+		return ReasonRelayed
+	default:
+		return ReasonLowScore
+	}
+}
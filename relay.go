@@ -0,0 +1,28 @@
+package connmgr
+
+import (
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// codeCircuit is the multicodec of the p2p-circuit protocol. The version of
+// go-multiaddr we depend on does not define it; the circuit relay transport registers
+// it when it is loaded.
+const codeCircuit = 0x0122
+
+// isRelayed reports whether addr goes through a circuit relay.
+func isRelayed(addr ma.Multiaddr) bool {
+	if addr == nil {
+		return false
+	}
+	for _, p := range addr.Protocols() {
+		if p.Code == codeCircuit || p.Name == "p2p-circuit" {
+			return true
+		}
+	}
+	return false
+}
+
+// relayedGroup puts every relayed peer into the same group.
+func relayedGroup(p PeerSnapshot) (string, bool) {
+	return "relayed", p.Relayed()
+}
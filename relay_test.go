@@ -0,0 +1,73 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func init() {
+	// normally registered by the circuit relay transport.
+	err := ma.AddProtocol(ma.Protocol{
+		Name:  "p2p-circuit",
+		Code:  codeCircuit,
+		VCode: ma.CodeToVarint(codeCircuit),
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func relayTestConns(t *testing.T, cm *PhoreConnMgr) (direct, relayed []network.Conn) {
+	not := cm.Notifee()
+	for i := 0; i < 3; i++ {
+		dc := addrConn(t, "/ip4/10.0.0.1/tcp/1")
+		not.Connected(nil, dc)
+		cm.TagPeer(dc.RemotePeer(), "score", 10*i)
+		direct = append(direct, dc)
+
+		rc := addrConn(t, "/ip4/10.0.0.2/tcp/1/p2p-circuit")
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "score", 10*i+5)
+		relayed = append(relayed, rc)
+	}
+	return direct, relayed
+}
+
+func TestRelayPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy        RelayPolicy
+		max           int
+		directClosed  []bool
+		relayedClosed []bool
+	}{
+		{RelayNeutral, 0, []bool{true, true, false}, []bool{true, false, false}},
+		{PruneRelayedFirst, 0, []bool{false, false, false}, []bool{true, true, true}},
+		{PruneRelayedLast, 0, []bool{true, true, true}, []bool{false, false, false}},
+		// the cap prunes one relayed peer before any direct one.
+		{PruneRelayedLast, 2, []bool{true, true, false}, []bool{true, false, false}},
+	} {
+		ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+		cm := NewConnManager(3, 3, 0, ps, map[protocol.ID]int{}, WithRelayPolicy(tc.policy, tc.max))
+		direct, relayed := relayTestConns(t, cm)
+
+		cm.TrimOpenConns(context.Background())
+
+		for i, c := range direct {
+			if c.(*tconn).closed != tc.directClosed[i] {
+				t.Errorf("policy %d, max %d: direct peer %d: expected closed=%v", tc.policy, tc.max, i, tc.directClosed[i])
+			}
+		}
+		for i, c := range relayed {
+			if c.(*tconn).closed != tc.relayedClosed[i] {
+				t.Errorf("policy %d, max %d: relayed peer %d: expected closed=%v", tc.policy, tc.max, i, tc.relayedClosed[i])
+			}
+		}
+		cm.Close()
+	}
+}
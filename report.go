@@ -0,0 +1,118 @@
+package connmgr
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// TrimDetails describes a completed trim. It is delivered to the reporter configured
+// through WithTrimReporter, and logged at debug level as JSON.
+type TrimDetails struct {
+	// Time is when the trim completed.
+	Time time.Time `json:"time"`
+
+	// Candidates is the number of peers considered for pruning.
+	Candidates int `json:"candidates"`
+
+	// Closed are the connections closed, in the order they were closed.
+	Closed []ClosedConn `json:"closed"`
+
+	// Cost is the work done by the trim.
+	Cost TrimCost `json:"cost"`
+
+	// Error is set when the trim was interrupted before closing all the connections it
+	// selected.
+	Error string `json:"error,omitempty"`
+}
+
+// ClosedConn describes a connection closed by a trim, with the state of its peer when
+// the trim selected it.
+type ClosedConn struct {
+	Peer      peer.ID        `json:"peer"`
+	Addr      string         `json:"addr"`
+	Direction string         `json:"direction"`
+	Value     int            `json:"value"`
+	Score     float64        `json:"score"`
+	Tags      map[string]int `json:"tags,omitempty"`
+
+	// TopTags are the tags contributing the most to Value.
+	TopTags []TagContribution `json:"topTags,omitempty"`
+
+	// Age is how long the connection had been open.
+	Age Duration `json:"age"`
+
+	// Reason tells which rule selected the connection.
+	Reason CloseReason `json:"reason"`
+}
+
+// newTrimDetails builds the report of the trim that executed plan and completed at now,
+// interrupted by err if not nil.
+func newTrimDetails(plan trimPlan, now time.Time, err error) *TrimDetails {
+	report := &TrimDetails{
+		Time:       now,
+		Candidates: plan.considered,
+		Closed:     make([]ClosedConn, 0, len(plan.conns)),
+		Cost:       plan.cost,
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	for _, c := range plan.conns {
+		p := plan.selected[c.RemotePeer()]
+		cc := ClosedConn{
+			Peer:    c.RemotePeer(),
+			Value:   p.Value,
+			Score:   p.Score,
+			Tags:    p.Tags,
+			TopTags: plan.topTags[c.RemotePeer()],
+			Reason:  plan.reasons[c],
+		}
+		if addr := c.RemoteMultiaddr(); addr != nil {
+			cc.Addr = addr.String()
+		}
+		for _, cs := range p.Conns {
+			if cs.Conn == c {
+				cc.Direction = directionName(cs.Direction)
+				cc.Age = Duration(now.Sub(cs.Opened))
+				break
+			}
+		}
+		report.Closed = append(report.Closed, cc)
+	}
+	return report
+}
+
+// LastTrimDetails returns the report of the last trim, nil if none completed.
+func (cm *PhoreConnMgr) LastTrimDetails() *TrimDetails {
+	cm.lastTrimMu.RLock()
+	defer cm.lastTrimMu.RUnlock()
+
+	return cm.lastReport
+}
+
+// deliverReport logs report, and passes it to the configured reporter.
+func (cm *PhoreConnMgr) deliverReport(report *TrimDetails) {
+	if report == nil {
+		return
+	}
+	log.Debugf("trim report: %s", jsonReport{report})
+	if cm.trimReporter != nil {
+		cm.trimReporter(*report)
+	}
+}
+
+// jsonReport defers the encoding of a report to when it is logged, so that trims do not
+// pay for it while debug logging is off.
+type jsonReport struct {
+	report *TrimDetails
+}
+
+func (r jsonReport) String() string {
+	data, err := json.Marshal(r.report)
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return string(data)
+}
@@ -0,0 +1,67 @@
+package connmgr
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestTrimReporter(t *testing.T) {
+	clock := newMockClock()
+	var reports []TrimDetails
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(2, 4, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithTrimInterval(0), WithTrimReporter(func(r TrimDetails) {
+		reports = append(reports, r)
+	}))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	if cm.LastTrimDetails() != nil {
+		t.Fatal("expected no report before the first trim")
+	}
+	var conns []*tconn
+	for i := 0; i < 5; i++ {
+		rc := randConn(t, not.Disconnected).(*tconn)
+		conns = append(conns, rc)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "score", i+1)
+	}
+	clock.Add(time.Minute)
+	cm.TrimOpenConns(context.Background())
+
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(reports))
+	}
+	r := reports[0]
+	if r.Candidates != 5 || len(r.Closed) != 3 || r.Error != "" {
+		t.Fatalf("unexpected report %+v", r)
+	}
+	for i, c := range r.Closed {
+		if c.Peer != conns[i].RemotePeer() || c.Value != i+1 || c.Tags["score"] != i+1 {
+			t.Errorf("closed conn %d: unexpected peer %s or value %d", i, c.Peer, c.Value)
+		}
+		if c.Reason != ReasonLowScore || time.Duration(c.Age) != time.Minute {
+			t.Errorf("closed conn %d: unexpected reason %q or age %s", i, c.Reason, time.Duration(c.Age))
+		}
+	}
+	if last := cm.LastTrimDetails(); last == nil || len(last.Closed) != 3 {
+		t.Fatalf("expected the last report to be kept, got %+v", last)
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded TrimDetails
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Closed[0].Peer != conns[0].RemotePeer() || decoded.Closed[0].Age != r.Closed[0].Age {
+		t.Fatalf("report did not survive a JSON round trip: %s", data)
+	}
+}
@@ -0,0 +1,152 @@
+package connmgr
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// PeerRecord is the reputation of a peer as persisted by a ReputationStore.
+type PeerRecord struct {
+	// Tags holds the value of each tag of the peer. Tags set with a TTL are not
+	// persisted.
+	Tags map[string]int
+
+	// FirstSeen is when we began tracking the peer.
+	FirstSeen time.Time
+}
+
+// ReputationStore persists peer reputations across restarts, see WithReputationStore.
+type ReputationStore interface {
+	// Load returns the records saved last.
+	Load() (map[peer.ID]PeerRecord, error)
+
+	// Save replaces the saved records with records.
+	Save(records map[peer.ID]PeerRecord) error
+}
+
+// loadReputations reads the records of the reputation store, to be restored as peers
+// connect.
+func (cm *PhoreConnMgr) loadReputations() {
+	if cm.store == nil {
+		return
+	}
+
+	records, err := cm.store.Load()
+	if err != nil {
+		log.Warning("cannot load peer reputations: ", err)
+		records = nil
+	}
+	if records == nil {
+		records = make(map[peer.ID]PeerRecord)
+	}
+	cm.storeMu.Lock()
+	cm.stored = records
+	cm.storeMu.Unlock()
+	cm.lastSave = cm.clock.Now()
+}
+
+// restoreReputation gives back to pi, whose first connection just opened, the tags and
+// first seen timestamp loaded from the reputation store, if any. Tags set since take
+// precedence. The segment of the peer must be locked.
+func (cm *PhoreConnMgr) restoreReputation(pi *peerInfo) {
+	if cm.store == nil {
+		return
+	}
+
+	cm.storeMu.Lock()
+	rec, ok := cm.stored[pi.id]
+	delete(cm.stored, pi.id)
+	cm.storeMu.Unlock()
+	if !ok {
+		return
+	}
+
+	for tag, val := range rec.Tags {
+		if _, ok := pi.tags[tag]; ok {
+			continue
+		}
+		pi.tags[tag] = val
+		pi.value += cm.weighTag(tag, val)
+	}
+	if !rec.FirstSeen.IsZero() && rec.FirstSeen.Before(pi.firstSeen) {
+		pi.firstSeen = rec.FirstSeen
+	}
+}
+
+// keepReputation holds on to the reputation of pi, whose last connection just closed,
+// so it is saved and restored like the loaded records. The segment of the peer must be
+// locked.
+func (cm *PhoreConnMgr) keepReputation(pi *peerInfo) {
+	if cm.store == nil {
+		return
+	}
+
+	rec := reputationOf(pi)
+	cm.storeMu.Lock()
+	cm.stored[pi.id] = rec
+	cm.storeMu.Unlock()
+}
+
+// snapshotReputations returns the records of the connected peers, along with those of
+// the peers that are not connected: loaded and not reconnected since, or disconnected.
+func (cm *PhoreConnMgr) snapshotReputations() map[peer.ID]PeerRecord {
+	cm.storeMu.Lock()
+	records := make(map[peer.ID]PeerRecord, len(cm.stored))
+	for p, rec := range cm.stored {
+		records[p] = rec
+	}
+	cm.storeMu.Unlock()
+
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+		for id, pi := range s.peers {
+			if pi.temp {
+				continue
+			}
+			records[id] = reputationOf(pi)
+		}
+		s.Unlock()
+	}
+	return records
+}
+
+// reputationOf returns the record of pi to be persisted. The segment of the peer must
+// be locked.
+func reputationOf(pi *peerInfo) PeerRecord {
+	tags := make(map[string]int, len(pi.tags))
+	for tag, val := range pi.tags {
+		if _, ok := pi.expiry[tag]; !ok {
+			tags[tag] = val
+		}
+	}
+	return PeerRecord{Tags: tags, FirstSeen: pi.firstSeen}
+}
+
+// saveReputations writes a snapshot of the peer reputations to the reputation store.
+func (cm *PhoreConnMgr) saveReputations() error {
+	if cm.store == nil {
+		return nil
+	}
+
+	cm.saveMu.Lock()
+	defer cm.saveMu.Unlock()
+	return cm.store.Save(cm.snapshotReputations())
+}
+
+// maybeSaveReputations saves the peer reputations if the save interval set through
+// WithReputationStore has elapsed since the last save. It must only be called from the
+// background goroutine.
+func (cm *PhoreConnMgr) maybeSaveReputations() {
+	if cm.store == nil {
+		return
+	}
+	now := cm.clock.Now()
+	if now.Sub(cm.lastSave) < cm.saveInterval {
+		return
+	}
+	cm.lastSave = now
+	if err := cm.saveReputations(); err != nil {
+		log.Warning("cannot save peer reputations: ", err)
+	}
+}
@@ -0,0 +1,121 @@
+package connmgr
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+// memStore is a ReputationStore keeping records in memory.
+type memStore struct {
+	mu      sync.Mutex
+	records map[peer.ID]PeerRecord
+	saves   int
+}
+
+func (ms *memStore) Load() (map[peer.ID]PeerRecord, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	records := make(map[peer.ID]PeerRecord, len(ms.records))
+	for p, rec := range ms.records {
+		records[p] = rec
+	}
+	return records, nil
+}
+
+func (ms *memStore) Save(records map[peer.ID]PeerRecord) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.records = records
+	ms.saves++
+	return nil
+}
+
+func TestReputationStore(t *testing.T) {
+	clock := newMockClock()
+	store := &memStore{}
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithReputationStore(store, time.Hour))
+	not := cm.Notifee()
+
+	c := randConn(t, nil)
+	not.Connected(nil, c)
+	firstSeen := clock.Now()
+	cm.TagPeer(c.RemotePeer(), "score", 10)
+	cm.TagPeerWithTTL(c.RemotePeer(), "fleeting", 3, time.Hour)
+	cm.Close()
+
+	rec, ok := store.records[c.RemotePeer()]
+	if !ok || len(rec.Tags) != 1 || rec.Tags["score"] != 10 || !rec.FirstSeen.Equal(firstSeen) {
+		t.Fatalf("expected the reputation to be saved without the TTL tags, got %+v", rec)
+	}
+
+	// after a restart, the reputation is restored as the peer connects.
+	clock.Add(time.Hour)
+	cm = NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithReputationStore(store, time.Hour))
+	not = cm.Notifee()
+	other := randConn(t, nil)
+	not.Connected(nil, other)
+	cm.Close()
+	if _, ok := store.records[c.RemotePeer()]; !ok {
+		t.Fatal("expected the records of peers that did not reconnect to be kept")
+	}
+
+	cm = NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithReputationStore(store, time.Hour))
+	defer cm.Close()
+	not = cm.Notifee()
+	not.Connected(nil, &tconn{peer: c.RemotePeer()})
+	ti := cm.GetTagInfo(c.RemotePeer())
+	if ti.Value != 10 || !ti.FirstSeen.Equal(firstSeen) {
+		t.Fatalf("expected the reputation to be restored, got %+v", ti)
+	}
+}
+
+func TestReputationStoreSaveInterval(t *testing.T) {
+	clock := newMockClock()
+	store := &memStore{}
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithReputationStore(store, time.Hour))
+	defer cm.Close()
+
+	cm.maybeSaveReputations()
+	if store.saves != 0 {
+		t.Fatal("expected no save before the interval elapsed")
+	}
+	clock.Add(time.Hour)
+	cm.maybeSaveReputations()
+	if store.saves != 1 {
+		t.Fatalf("expected a save once the interval elapsed, got %d", store.saves)
+	}
+}
+
+func TestReputationStoreKeepsDisconnectedPeers(t *testing.T) {
+	clock := newMockClock()
+	store := &memStore{}
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithReputationStore(store, time.Hour))
+	not := cm.Notifee()
+	c := randConn(t, nil)
+	not.Connected(nil, c)
+	cm.TagPeer(c.RemotePeer(), "score", 10)
+	cm.Close()
+
+	// the peer reconnects after a restart, then disconnects before the next save.
+	cm = NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithReputationStore(store, time.Hour))
+	not = cm.Notifee()
+	c = &tconn{peer: c.RemotePeer()}
+	not.Connected(nil, c)
+	cm.TagPeer(c.RemotePeer(), "score", 15)
+	not.Disconnected(nil, c)
+	cm.Close()
+
+	rec, ok := store.records[c.RemotePeer()]
+	if !ok || rec.Tags["score"] != 15 {
+		t.Fatalf("expected the reputation of the disconnected peer to be saved, got %+v", rec)
+	}
+}
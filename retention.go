@@ -0,0 +1,78 @@
+package connmgr
+
+import "time"
+
+// retainedPeer holds the tags of a disconnected peer until its retention window ends,
+// see WithTagRetention.
+type retainedPeer struct {
+	tags   map[string]int
+	expiry map[string]time.Time
+	until  time.Time
+}
+
+// retainTags keeps the tags of pi, whose last connection just closed, for the
+// retention window. The segment of the peer must be locked.
+func (cm *PhoreConnMgr) retainTags(pi *peerInfo, now time.Time) {
+	if cm.retention <= 0 || len(pi.tags) == 0 {
+		return
+	}
+
+	cm.retainMu.Lock()
+	defer cm.retainMu.Unlock()
+	cm.retained[pi.id] = &retainedPeer{
+		tags:   pi.tags,
+		expiry: pi.expiry,
+		until:  now.Add(cm.retention),
+	}
+}
+
+// restoreTags gives back to pi, whose first connection just opened, the tags it had
+// when it last disconnected, if that happened within the retention window. Tags set
+// while the peer was disconnected take precedence. The segment of the peer must be
+// locked.
+func (cm *PhoreConnMgr) restoreTags(pi *peerInfo, now time.Time) {
+	if cm.retention <= 0 {
+		return
+	}
+
+	cm.retainMu.Lock()
+	r, ok := cm.retained[pi.id]
+	delete(cm.retained, pi.id)
+	cm.retainMu.Unlock()
+	if !ok || !r.until.After(now) {
+		return
+	}
+
+	for tag, val := range r.tags {
+		if _, ok := pi.tags[tag]; ok {
+			continue
+		}
+		if at, ok := r.expiry[tag]; ok {
+			if !at.After(now) {
+				continue
+			}
+			if pi.expiry == nil {
+				pi.expiry = make(map[string]time.Time)
+			}
+			pi.expiry[tag] = at
+		}
+		pi.tags[tag] = val
+		pi.value += cm.weighTag(tag, val)
+	}
+}
+
+// expireRetained forgets the tags of the peers whose retention window has ended.
+func (cm *PhoreConnMgr) expireRetained() {
+	if cm.retention <= 0 {
+		return
+	}
+	now := cm.clock.Now()
+
+	cm.retainMu.Lock()
+	defer cm.retainMu.Unlock()
+	for p, r := range cm.retained {
+		if !r.until.After(now) {
+			delete(cm.retained, p)
+		}
+	}
+}
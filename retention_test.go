@@ -0,0 +1,63 @@
+package connmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestTagRetention(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithTagRetention(5*time.Minute))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	c := randConn(t, nil)
+	p := c.RemotePeer()
+	not.Connected(nil, c)
+	cm.TagPeer(p, "score", 10)
+	cm.TagPeerWithTTL(p, "fleeting", 3, time.Minute)
+	not.Disconnected(nil, c)
+	if cm.GetTagInfo(p) != nil {
+		t.Fatal("expected the disconnected peer not to be tracked")
+	}
+
+	// tags set while disconnected take precedence over the retained ones.
+	clock.Add(2 * time.Minute)
+	cm.TagPeer(p, "other", 1)
+	cm.TagPeer(p, "score", 7)
+	not.Connected(nil, &tconn{peer: p})
+	ti := cm.GetTagInfo(p)
+	if ti.Value != 8 || ti.Tags["score"] != 7 || ti.Tags["other"] != 1 {
+		t.Fatalf("expected the tags set while disconnected to take precedence, got %v", ti.Tags)
+	}
+	if _, ok := ti.Tags["fleeting"]; ok {
+		t.Fatal("expected the tag to expire while the peer was disconnected")
+	}
+
+	// the retention window elapses before the peer reconnects.
+	c2 := randConn(t, nil)
+	not.Connected(nil, c2)
+	cm.TagPeer(c2.RemotePeer(), "score", 10)
+	not.Disconnected(nil, c2)
+	clock.Add(6 * time.Minute)
+	not.Connected(nil, &tconn{peer: c2.RemotePeer()})
+	if ti := cm.GetTagInfo(c2.RemotePeer()); ti.Value != 0 {
+		t.Fatalf("expected the tags to be dropped after the retention window, got %v", ti.Tags)
+	}
+
+	// a peer reconnecting within the window gets its tags back.
+	c3 := randConn(t, nil)
+	not.Connected(nil, c3)
+	cm.TagPeer(c3.RemotePeer(), "score", 4)
+	not.Disconnected(nil, c3)
+	clock.Add(time.Minute)
+	not.Connected(nil, &tconn{peer: c3.RemotePeer()})
+	if ti := cm.GetTagInfo(c3.RemotePeer()); ti.Value != 4 || ti.Tags["score"] != 4 {
+		t.Fatalf("expected the tags to be restored, got %v", ti.Tags)
+	}
+}
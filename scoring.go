@@ -0,0 +1,115 @@
+package connmgr
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// AgeCurve maps the age of a peer, i.e. the time since we began tracking it, to the
+// bonus it receives before weighting. See WithAgeBonus.
+type AgeCurve func(age time.Duration) float64
+
+// LinearAgeCurve grows the bonus by one for every unit of age.
+func LinearAgeCurve(unit time.Duration) AgeCurve {
+	return func(age time.Duration) float64 {
+		return float64(age) / float64(unit)
+	}
+}
+
+// LogAgeCurve grows the bonus logarithmically with the age expressed in units, so peers
+// quickly earn a bonus but long-lived peers do not keep gaining on everyone else.
+func LogAgeCurve(unit time.Duration) AgeCurve {
+	return func(age time.Duration) float64 {
+		return math.Log1p(float64(age) / float64(unit))
+	}
+}
+
+// TagBounds bounds the values of a tag, see WithTagBounds.
+type TagBounds struct {
+	Min, Max int
+}
+
+// clampTag bounds the value of the given tag according to the configured TagBounds
+// and negative floor.
+func (cm *PhoreConnMgr) clampTag(tag string, val int) int {
+	if b, ok := cm.tagBounds[tag]; ok {
+		if val < b.Min {
+			val = b.Min
+		}
+		if val > b.Max {
+			val = b.Max
+		}
+	}
+	if cm.negativeTagFloor < 0 && val < cm.negativeTagFloor {
+		val = cm.negativeTagFloor
+	}
+	return val
+}
+
+// TagNamespace returns the namespace of a tag, i.e. the part before the first slash,
+// or an empty string if the tag is not namespaced.
+func TagNamespace(tag string) string {
+	if i := strings.IndexByte(tag, '/'); i >= 0 {
+		return tag[:i]
+	}
+	return ""
+}
+
+// weighTag returns the contribution of a tag of the given value to the value of the
+// peer, according to the weight of its namespace.
+func (cm *PhoreConnMgr) weighTag(tag string, val int) int {
+	w, ok := cm.namespaceWeights[TagNamespace(tag)]
+	if !ok {
+		return val
+	}
+	return int(math.Round(w * float64(val)))
+}
+
+// scorePeer computes the score of a peer snapshot taken at now: its value plus all the
+// configured bonuses.
+func (cm *PhoreConnMgr) scorePeer(p *PeerSnapshot, now time.Time) {
+	score := float64(p.Value + p.Contributed)
+	if cm.ageCurve != nil && !p.Temp {
+		score += cm.ageWeight * cm.ageCurve(now.Sub(p.FirstSeen))
+	}
+	score += cm.survivorBonus * float64(p.Survived)
+	if p.Cooldown {
+		score -= cm.cooldownPenalty
+	}
+	for _, proto := range p.Protocols {
+		score += cm.protocolWeights[proto]
+	}
+	if cm.latencyWeight != 0 && p.Latency > 0 {
+		score -= cm.latencyWeight * float64(p.Latency) / float64(cm.latencyUnit)
+	}
+	p.Score = score
+}
+
+// snapshotPeer takes a snapshot of the peer, and scores it. The segment of the peer must
+// be locked.
+func (cm *PhoreConnMgr) snapshotPeer(pi *peerInfo, now time.Time) PeerSnapshot {
+	p := pi.snapshot()
+	p.Cooldown = cm.inCooldown(p.ID, now)
+	if cm.maxPeerValue > 0 && p.Value > cm.maxPeerValue {
+		p.Value = cm.maxPeerValue
+	}
+	if len(cm.protocolWeights) > 0 {
+		if supported, err := cm.getProtocols(p.ID); err == nil {
+			for _, sp := range supported {
+				p.Protocols = append(p.Protocols, protocol.ID(sp))
+			}
+		}
+	}
+	if cm.latencyWeight != 0 {
+		p.Latency = cm.latencyOf(p.ID)
+	}
+	p.Contributed = cm.contribution(p.ID)
+	cm.scorePeer(&p, now)
+	if cm.idleTimeout > 0 {
+		p.Idle = p.Streams() == 0 && now.Sub(p.LastActivity()) >= cm.idleTimeout
+	}
+	return p
+}
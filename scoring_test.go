@@ -0,0 +1,272 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestAgeBonus(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithAgeBonus(10, LinearAgeCurve(time.Hour)))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	// an old peer with a slightly lower value than a newcomer.
+	old := randConn(t, nil)
+	not.Connected(nil, old)
+	cm.TagPeer(old.RemotePeer(), "score", 5)
+
+	clock.Add(2 * time.Hour)
+	newer := randConn(t, nil)
+	not.Connected(nil, newer)
+	cm.TagPeer(newer.RemotePeer(), "score", 10)
+
+	cm.TrimOpenConns(context.Background())
+
+	if old.(*tconn).closed {
+		t.Fatal("expected the age bonus to keep the long-lived peer")
+	}
+	if !newer.(*tconn).closed {
+		t.Fatal("expected the newer peer to be pruned")
+	}
+
+	if bonus := LogAgeCurve(time.Hour)(0); bonus != 0 {
+		t.Fatalf("expected no bonus for brand new peers, got %f", bonus)
+	}
+}
+
+func TestIdlePeersPrunedFirst(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(2, 2, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithIdleTimeout(10*time.Minute))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	idle, tagged, streaming := randConn(t, nil), randConn(t, nil), randConn(t, nil)
+	for _, c := range []network.Conn{idle, tagged, streaming} {
+		not.Connected(nil, c)
+	}
+	cm.TagPeer(idle.RemotePeer(), "score", 100)
+
+	// only the first peer stays inactive past the timeout.
+	clock.Add(20 * time.Minute)
+	cm.TagPeer(tagged.RemotePeer(), "score", 1)
+	not.OpenedStream(nil, &tstream{conn: streaming})
+
+	cm.TrimOpenConns(context.Background())
+
+	if !idle.(*tconn).closed {
+		t.Fatal("expected the idle peer to be pruned despite its value")
+	}
+	if tagged.(*tconn).closed || streaming.(*tconn).closed {
+		t.Fatal("expected active peers to be kept")
+	}
+}
+
+func TestLatencyPenalty(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithLatencyPenalty(0.5, 100*time.Millisecond))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	fast, slow := randConn(t, nil), randConn(t, nil)
+	not.Connected(nil, fast)
+	not.Connected(nil, slow)
+	ps.RecordLatency(fast.RemotePeer(), 20*time.Millisecond)
+	ps.RecordLatency(slow.RemotePeer(), 400*time.Millisecond)
+
+	// the slow peer loses its one point lead to the penalty.
+	cm.TagPeer(fast.RemotePeer(), "score", 10)
+	cm.TagPeer(slow.RemotePeer(), "score", 11)
+
+	cm.TrimOpenConns(context.Background())
+
+	if fast.(*tconn).closed {
+		t.Fatal("expected the low latency peer to be kept")
+	}
+	if !slow.(*tconn).closed {
+		t.Fatal("expected the high latency peer to be pruned")
+	}
+}
+
+func TestProtocolWeights(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithProtocolWeights(map[protocol.ID]float64{
+		"/phore/sync/1.0.0": 5,
+	}))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	sync, dht := randConn(t, nil), randConn(t, nil)
+	not.Connected(nil, sync)
+	not.Connected(nil, dht)
+	cm.TagPeer(sync.RemotePeer(), "score", 10)
+	cm.TagPeer(dht.RemotePeer(), "score", 12)
+	if err := ps.AddProtocols(sync.RemotePeer(), "/phore/sync/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.AddProtocols(dht.RemotePeer(), "/ipfs/kad/1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	cm.TrimOpenConns(context.Background())
+
+	if sync.(*tconn).closed {
+		t.Fatal("expected the weight to keep the block sync peer")
+	}
+	if !dht.(*tconn).closed {
+		t.Fatal("expected the dht peer to be pruned")
+	}
+}
+
+func TestTagBounds(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithTagBounds(map[string]TagBounds{
+		"pubsub": {Min: -10, Max: 50},
+	}), WithMaxPeerValue(60))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	runaway := randConn(t, nil)
+	not.Connected(nil, runaway)
+	p := runaway.RemotePeer()
+
+	cm.TagPeer(p, "pubsub", 1000)
+	if v := cm.GetTagInfo(p).Value; v != 50 {
+		t.Fatalf("expected the tag to be clamped to 50, got %d", v)
+	}
+	cm.UpsertTag(p, "pubsub", func(v int) int { return v - 100 })
+	if v := cm.GetTagInfo(p).Tags["pubsub"]; v != -10 {
+		t.Fatalf("expected the upserted tag to be clamped to -10, got %d", v)
+	}
+
+	// unbounded tags can still add up, but trims see a normalized value.
+	cm.TagPeer(p, "pubsub", 50)
+	cm.TagPeer(p, "other", 1000)
+	now := cm.clock.Now()
+	s := cm.segments.get(p)
+	s.Lock()
+	snap := cm.snapshotPeer(s.peers[p], now)
+	s.Unlock()
+	if snap.Value != 60 {
+		t.Fatalf("expected the peer value to be normalized to 60, got %d", snap.Value)
+	}
+}
+
+func TestNegativeTagFloor(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithNegativeTagFloor(-100))
+	defer cm.Close()
+
+	p := randConn(t, nil).RemotePeer()
+	for i := 0; i < 1000; i++ {
+		cm.UpsertTag(p, "misbehavior", func(v int) int { return v - 1 })
+	}
+	if v := cm.GetTagInfo(p).Value; v != -100 {
+		t.Fatalf("expected the penalty to saturate at -100, got %d", v)
+	}
+
+	cm.TagPeer(p, "bonus", 1000)
+	if v := cm.GetTagInfo(p).Tags["bonus"]; v != 1000 {
+		t.Fatalf("expected positive values to be left alone, got %d", v)
+	}
+}
+
+func TestNamespaceWeights(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithNamespaceWeights(map[string]float64{
+		"pubsub": 0.5,
+		"sync":   3,
+	}))
+	defer cm.Close()
+
+	p := randConn(t, nil).RemotePeer()
+	cm.TagPeer(p, "pubsub/mesh", 10)
+	cm.TagPeer(p, "sync/serving", 10)
+	cm.TagPeer(p, "plain", 10)
+	if v := cm.GetTagInfo(p).Value; v != 5+30+10 {
+		t.Fatalf("expected a weighted value of 45, got %d", v)
+	}
+
+	cm.UpsertTag(p, "sync/serving", func(v int) int { return v + 1 })
+	cm.UntagPeer(p, "pubsub/mesh")
+	info := cm.GetTagInfo(p)
+	if info.Value != 33+10 {
+		t.Fatalf("expected a weighted value of 43, got %d", info.Value)
+	}
+	if info.Tags["sync/serving"] != 11 {
+		t.Fatalf("expected the raw tag value to be kept, got %d", info.Tags["sync/serving"])
+	}
+}
+
+func TestSurvivorBonus(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithSurvivorBonus(2), WithSilencePeriod(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	veteran, loser := randConn(t, nil), randConn(t, nil)
+	not.Connected(nil, veteran)
+	not.Connected(nil, loser)
+	cm.TagPeer(veteran.RemotePeer(), "score", 10)
+	cm.TagPeer(loser.RemotePeer(), "score", 1)
+	cm.TrimOpenConns(context.Background())
+
+	// a newcomer worth slightly more than the veteran before its bonus.
+	newcomer := randConn(t, nil)
+	not.Connected(nil, newcomer)
+	cm.TagPeer(newcomer.RemotePeer(), "score", 11)
+	cm.TrimOpenConns(context.Background())
+
+	if veteran.(*tconn).closed {
+		t.Fatal("expected the peer that survived a trim to be kept")
+	}
+	if !loser.(*tconn).closed || !newcomer.(*tconn).closed {
+		t.Fatal("expected the other peers to be pruned")
+	}
+}
+
+func TestScoreContributors(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithSilencePeriod(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	staker, other := randConn(t, not.Disconnected), randConn(t, not.Disconnected)
+	not.Connected(nil, staker)
+	not.Connected(nil, other)
+	cm.TagPeer(other.RemotePeer(), "score", 10)
+
+	cm.RegisterScoreContributor("stake", func(p peer.ID) int {
+		if p == staker.RemotePeer() {
+			return 20
+		}
+		return 0
+	})
+	// a replaced contributor no longer counts.
+	cm.RegisterScoreContributor("masternode", func(p peer.ID) int { return -100 })
+	cm.RegisterScoreContributor("masternode", func(p peer.ID) int { return 0 })
+
+	cm.TrimOpenConns(context.Background())
+	if staker.(*tconn).closed || !other.(*tconn).closed {
+		t.Fatal("expected the contribution to keep the staker")
+	}
+
+	// once unregistered, the staker competes on its tags alone.
+	cm.UnregisterScoreContributor("stake")
+	again := randConn(t, not.Disconnected)
+	not.Connected(nil, again)
+	cm.TagPeer(again.RemotePeer(), "score", 10)
+	cm.TrimOpenConns(context.Background())
+	if !staker.(*tconn).closed || again.(*tconn).closed {
+		t.Fatal("expected the staker to be pruned once its contributor is unregistered")
+	}
+}
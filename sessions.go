@@ -0,0 +1,151 @@
+package connmgr
+
+import (
+	"container/list"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+	// globalSessionSamples is the number of recent sessions the global median is
+	// computed over.
+	globalSessionSamples = 1024
+
+	// peerSessionSamples is the number of recent sessions the median of a peer is
+	// computed over.
+	peerSessionSamples = 16
+)
+
+// SessionStats summarizes the durations of the sessions of peers, from their first
+// connection opening to their last connection closing.
+type SessionStats struct {
+	// Count is the number of sessions recorded.
+	Count int
+
+	// Mean and Max are computed over all the sessions recorded.
+	Mean time.Duration
+	Max  time.Duration
+
+	// Median is computed over the most recent sessions only.
+	Median time.Duration
+}
+
+// sessionAggregate accumulates session durations.
+type sessionAggregate struct {
+	count   int
+	total   time.Duration
+	max     time.Duration
+	samples []time.Duration // most recent durations, oldest first
+}
+
+// add records d, keeping at most limit samples.
+func (a *sessionAggregate) add(d time.Duration, limit int) {
+	a.count++
+	a.total += d
+	if d > a.max {
+		a.max = d
+	}
+	if len(a.samples) == limit {
+		copy(a.samples, a.samples[1:])
+		a.samples = a.samples[:limit-1]
+	}
+	a.samples = append(a.samples, d)
+}
+
+func (a *sessionAggregate) stats() SessionStats {
+	if a.count == 0 {
+		return SessionStats{}
+	}
+	sorted := append([]time.Duration(nil), a.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + median) / 2
+	}
+	return SessionStats{
+		Count:  a.count,
+		Mean:   a.total / time.Duration(a.count),
+		Max:    a.max,
+		Median: median,
+	}
+}
+
+// peerSessions are the session durations of a peer.
+type peerSessions struct {
+	id  peer.ID
+	agg sessionAggregate
+}
+
+// sessionRecorder keeps the global session durations, and those of the peers that
+// ended a session most recently.
+type sessionRecorder struct {
+	maxPeers int
+	global   sessionAggregate
+	peers    map[peer.ID]*list.Element // of *peerSessions
+	lru      *list.List                // most recently updated first
+}
+
+// record adds a session of p lasting d.
+func (sr *sessionRecorder) record(p peer.ID, d time.Duration) {
+	sr.global.add(d, globalSessionSamples)
+
+	if sr.maxPeers <= 0 {
+		return
+	}
+	e, ok := sr.peers[p]
+	if ok {
+		sr.lru.MoveToFront(e)
+	} else {
+		e = sr.lru.PushFront(&peerSessions{id: p})
+		sr.peers[p] = e
+		if sr.lru.Len() > sr.maxPeers {
+			oldest := sr.lru.Back()
+			sr.lru.Remove(oldest)
+			delete(sr.peers, oldest.Value.(*peerSessions).id)
+		}
+	}
+	e.Value.(*peerSessions).agg.add(d, peerSessionSamples)
+}
+
+// recordSession records the session of pi, whose last connection just closed. The
+// segment of the peer must be locked.
+func (cm *PhoreConnMgr) recordSession(pi *peerInfo, now time.Time) {
+	if cm.sessions == nil || pi.connectedAt.IsZero() {
+		return
+	}
+
+	cm.sessionMu.Lock()
+	defer cm.sessionMu.Unlock()
+	cm.sessions.record(pi.id, now.Sub(pi.connectedAt))
+}
+
+// SessionStats returns the statistics of the sessions of all peers, as recorded since
+// the connection manager was created with WithSessionStats. The statistics are empty
+// if session recording is not enabled.
+func (cm *PhoreConnMgr) SessionStats() SessionStats {
+	if cm.sessions == nil {
+		return SessionStats{}
+	}
+
+	cm.sessionMu.Lock()
+	defer cm.sessionMu.Unlock()
+	return cm.sessions.global.stats()
+}
+
+// PeerSessionStats returns the statistics of the sessions of p, if it is among the
+// peers whose sessions are kept, see WithSessionStats.
+func (cm *PhoreConnMgr) PeerSessionStats(p peer.ID) (SessionStats, bool) {
+	if cm.sessions == nil {
+		return SessionStats{}, false
+	}
+
+	cm.sessionMu.Lock()
+	defer cm.sessionMu.Unlock()
+	e, ok := cm.sessions.peers[p]
+	if !ok {
+		return SessionStats{}, false
+	}
+	return e.Value.(*peerSessions).agg.stats(), true
+}
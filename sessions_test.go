@@ -0,0 +1,60 @@
+package connmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	tu "github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestSessionStats(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock), WithSessionStats(1))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	session := func(c *tconn, d time.Duration) {
+		not.Connected(nil, c)
+		clock.Add(d)
+		not.Disconnected(nil, c)
+	}
+
+	id := tu.RandPeerIDFatal(t)
+	session(&tconn{peer: id}, time.Minute)
+	session(&tconn{peer: id}, 3*time.Minute)
+
+	// the session only ends with the last connection of the peer.
+	first, second := &tconn{peer: id}, &tconn{peer: id}
+	not.Connected(nil, first)
+	clock.Add(time.Minute)
+	not.Connected(nil, second)
+	clock.Add(time.Minute)
+	not.Disconnected(nil, first)
+	clock.Add(9 * time.Minute)
+	not.Disconnected(nil, second)
+
+	want := SessionStats{Count: 3, Mean: 5 * time.Minute, Max: 11 * time.Minute, Median: 3 * time.Minute}
+	if st, ok := cm.PeerSessionStats(id); !ok || st != want {
+		t.Fatalf("expected peer stats %+v, got %+v", want, st)
+	}
+	if st := cm.SessionStats(); st != want {
+		t.Fatalf("expected global stats %+v, got %+v", want, st)
+	}
+
+	// only the peer that ended a session last is kept individually.
+	other := tu.RandPeerIDFatal(t)
+	session(&tconn{peer: other}, time.Minute)
+	if _, ok := cm.PeerSessionStats(id); ok {
+		t.Error("expected the stats of the first peer to be evicted")
+	}
+	if st, ok := cm.PeerSessionStats(other); !ok || st.Count != 1 || st.Median != time.Minute {
+		t.Errorf("unexpected stats of the other peer: %+v", st)
+	}
+	if st := cm.SessionStats(); st.Count != 4 || st.Median != 2*time.Minute {
+		t.Errorf("unexpected global stats: %+v", st)
+	}
+}
@@ -0,0 +1,202 @@
+package connmgr
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// State is the document produced by MarshalState.
+type State struct {
+	// LowWater and HighWater are the watermarks in effect.
+	LowWater  int `json:"lowWater"`
+	HighWater int `json:"highWater"`
+
+	// GracePeriod and SilencePeriod are the configured periods.
+	GracePeriod   Duration `json:"gracePeriod"`
+	SilencePeriod Duration `json:"silencePeriod"`
+
+	// Protected maps the peers protected through Protect to their protection tags.
+	Protected map[peer.ID][]string `json:"protected,omitempty"`
+
+	// ProtectedProtocols are the protocols set with ProtectProtocol.
+	ProtectedProtocols []protocol.ID `json:"protectedProtocols,omitempty"`
+
+	// ProtocolMinimums maps protocols to the minimum number of peers supporting them.
+	ProtocolMinimums map[protocol.ID]int `json:"protocolMinimums,omitempty"`
+
+	// Peers are the tracked peers, by ascending ID.
+	Peers []PeerState `json:"peers"`
+}
+
+// PeerState is the state of a tracked peer in a State document.
+type PeerState struct {
+	ID        peer.ID        `json:"id"`
+	Value     int            `json:"value"`
+	Tags      map[string]int `json:"tags,omitempty"`
+	FirstSeen time.Time      `json:"firstSeen"`
+
+	// Expiring maps the tags set with a TTL to their expiry.
+	Expiring map[string]time.Time `json:"expiring,omitempty"`
+
+	// Temp is set for temporary entries, holding tags of peers not connected yet.
+	Temp bool `json:"temp,omitempty"`
+
+	Conns []ConnState `json:"conns,omitempty"`
+}
+
+// ConnState is the state of a connection in a State document.
+type ConnState struct {
+	Addr      string    `json:"addr"`
+	Opened    time.Time `json:"opened"`
+	Direction string    `json:"direction"`
+	Streams   int       `json:"streams"`
+}
+
+// MarshalState encodes the configuration in effect, the protections and the tracked
+// peers with their tags and connections as an indented JSON State document, meant for
+// support bundles and bug reports.
+func (cm *PhoreConnMgr) MarshalState() ([]byte, error) {
+	return json.MarshalIndent(cm.state(), "", "  ")
+}
+
+// State returns the document MarshalState encodes.
+func (cm *PhoreConnMgr) State() State {
+	return cm.state()
+}
+
+// state collects the State of the connection manager.
+func (cm *PhoreConnMgr) state() State {
+	low, hi := cm.watermarks()
+	grace, silence := cm.timing()
+	st := State{
+		LowWater:      low,
+		HighWater:     hi,
+		GracePeriod:   Duration(grace),
+		SilencePeriod: Duration(silence),
+		Peers:         []PeerState{},
+	}
+
+	cm.plk.RLock()
+	if len(cm.protected) > 0 {
+		st.Protected = make(map[peer.ID][]string, len(cm.protected))
+		for id, tags := range cm.protected {
+			for tag := range tags {
+				st.Protected[id] = append(st.Protected[id], tag)
+			}
+			sort.Strings(st.Protected[id])
+		}
+	}
+	for proto := range cm.protectedProtocols {
+		st.ProtectedProtocols = append(st.ProtectedProtocols, proto)
+	}
+	if len(cm.minimumPeersForProtocol) > 0 {
+		st.ProtocolMinimums = copyMinimums(cm.minimumPeersForProtocol)
+	}
+	cm.plk.RUnlock()
+	sort.Slice(st.ProtectedProtocols, func(i, j int) bool {
+		return st.ProtectedProtocols[i] < st.ProtectedProtocols[j]
+	})
+
+	now := cm.clock.Now()
+	for _, s := range cm.segments.buckets {
+		s.Lock()
+		for _, pi := range s.peers {
+			cm.expireTags(pi, now)
+			st.Peers = append(st.Peers, pi.state())
+		}
+		s.Unlock()
+	}
+	sort.Slice(st.Peers, func(i, j int) bool {
+		return st.Peers[i].ID < st.Peers[j].ID
+	})
+	return st
+}
+
+// state returns the PeerState of the peer. The segment of the peer must be locked.
+func (pi *peerInfo) state() PeerState {
+	ps := PeerState{
+		ID:        pi.id,
+		Value:     pi.value,
+		FirstSeen: pi.firstSeen,
+		Temp:      pi.temp,
+	}
+	if len(pi.tags) > 0 {
+		ps.Tags = make(map[string]int, len(pi.tags))
+		for t, v := range pi.tags {
+			ps.Tags[t] = v
+		}
+	}
+	if len(pi.expiry) > 0 {
+		ps.Expiring = make(map[string]time.Time, len(pi.expiry))
+		for t, exp := range pi.expiry {
+			ps.Expiring[t] = exp
+		}
+	}
+	for c, ci := range pi.conns {
+		ps.Conns = append(ps.Conns, ConnState{
+			Addr:      c.RemoteMultiaddr().String(),
+			Opened:    ci.opened,
+			Direction: directionName(ci.dir),
+			Streams:   len(ci.streams),
+		})
+	}
+	sort.Slice(ps.Conns, func(i, j int) bool {
+		left, right := ps.Conns[i], ps.Conns[j]
+		if !left.Opened.Equal(right.Opened) {
+			return left.Opened.Before(right.Opened)
+		}
+		return left.Addr < right.Addr
+	})
+	return ps
+}
+
+// directionName names d for human readers.
+func directionName(d network.Direction) string {
+	switch d {
+	case network.DirInbound:
+		return "inbound"
+	case network.DirOutbound:
+		return "outbound"
+	default:
+		return "unknown"
+	}
+}
+
+// RestoreTags re-applies the tags and protections of a document produced by
+// MarshalState, e.g. on another node instance. Tags of peers not connected are held in
+// temporary entries until they connect, within the limit set by WithMaxTempEntries.
+// Tags set with a TTL are restored with the time they had left, unless they have
+// expired since. The configuration recorded in the document is left alone.
+func (cm *PhoreConnMgr) RestoreTags(data []byte) error {
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	now := cm.clock.Now()
+	for _, p := range st.Peers {
+		for tag, v := range p.Tags {
+			exp, ok := p.Expiring[tag]
+			switch {
+			case !ok:
+				cm.TagPeer(p.ID, tag, v)
+			case exp.After(now):
+				cm.TagPeerWithTTL(p.ID, tag, v, exp.Sub(now))
+			}
+		}
+	}
+	for id, tags := range st.Protected {
+		for _, tag := range tags {
+			cm.Protect(id, tag)
+		}
+	}
+	for _, proto := range st.ProtectedProtocols {
+		cm.ProtectProtocol(proto)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package connmgr
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	tu "github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestMarshalState(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, time.Minute, ps, map[protocol.ID]int{
+		"/phore/1.0.0": 2,
+	}, WithClock(clock))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	c := &tconn{peer: tu.RandPeerIDFatal(t), dir: network.DirInbound, addr: ma.StringCast("/ip4/1.2.3.4/tcp/4001")}
+	not.Connected(nil, c)
+	cm.TagPeer(c.peer, "foo", 5)
+	cm.TagPeerWithTTL(c.peer, "boost", 3, time.Hour)
+	cm.Protect(c.peer, "b")
+	cm.Protect(c.peer, "a")
+	cm.ProtectProtocol("/phore/1.0.0")
+	early := tu.RandPeerIDFatal(t)
+	cm.TagPeer(early, "bar", 1)
+
+	data, err := cm.MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatal(err)
+	}
+
+	if st.LowWater != 10 || st.HighWater != 20 || time.Duration(st.GracePeriod) != time.Minute {
+		t.Errorf("unexpected configuration: %+v", st)
+	}
+	if tags := st.Protected[c.peer]; len(tags) != 2 || tags[0] != "a" || tags[1] != "b" {
+		t.Errorf("unexpected protection tags: %v", tags)
+	}
+	if len(st.ProtectedProtocols) != 1 || st.ProtectedProtocols[0] != "/phore/1.0.0" || st.ProtocolMinimums["/phore/1.0.0"] != 2 {
+		t.Errorf("unexpected protocols: %v, %v", st.ProtectedProtocols, st.ProtocolMinimums)
+	}
+	if len(st.Peers) != 2 {
+		t.Fatalf("expected 2 peers, got %d", len(st.Peers))
+	}
+	for _, p := range st.Peers {
+		switch p.ID {
+		case c.peer:
+			if p.Value != 8 || p.Tags["foo"] != 5 || p.Tags["boost"] != 3 || p.Temp {
+				t.Errorf("unexpected state of the connected peer: %+v", p)
+			}
+			if exp := p.Expiring["boost"]; !exp.Equal(clock.Now().Add(time.Hour)) || len(p.Expiring) != 1 {
+				t.Errorf("unexpected expiring tags: %v", p.Expiring)
+			}
+			if len(p.Conns) != 1 || p.Conns[0] != (ConnState{Addr: "/ip4/1.2.3.4/tcp/4001", Opened: p.Conns[0].Opened, Direction: "inbound"}) {
+				t.Errorf("unexpected connections: %+v", p.Conns)
+			}
+		case early:
+			if !p.Temp || p.Tags["bar"] != 1 || len(p.Conns) != 0 {
+				t.Errorf("unexpected state of the temporary entry: %+v", p)
+			}
+		default:
+			t.Errorf("unexpected peer %s", p.ID)
+		}
+	}
+}
+
+func TestRestoreTags(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	src := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock))
+	defer src.Close()
+
+	rc := randConn(t, nil)
+	id := rc.RemotePeer()
+	src.Notifee().Connected(nil, rc)
+	src.TagPeer(id, "foo", 5)
+	src.TagPeerWithTTL(id, "boost", 3, time.Hour)
+	src.TagPeerWithTTL(id, "short", 7, time.Minute)
+	src.Protect(id, "keep")
+	src.ProtectProtocol("/phore/1.0.0")
+
+	data, err := src.MarshalState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Add(30 * time.Minute)
+
+	dst := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock))
+	defer dst.Close()
+	if err := dst.RestoreTags(data); err != nil {
+		t.Fatal(err)
+	}
+
+	info := dst.GetTagInfo(id)
+	if info == nil || info.Value != 8 || info.Tags["foo"] != 5 || info.Tags["boost"] != 3 {
+		t.Fatalf("unexpected restored tags: %+v", info)
+	}
+	if _, ok := info.Tags["short"]; ok {
+		t.Error("expected the expired tag not to be restored")
+	}
+	if !dst.IsProtected(id, "keep") {
+		t.Error("expected the protection to be restored")
+	}
+	if st := dst.state(); len(st.ProtectedProtocols) != 1 || st.ProtectedProtocols[0] != "/phore/1.0.0" {
+		t.Errorf("unexpected protected protocols: %v", st.ProtectedProtocols)
+	}
+
+	clock.Add(31 * time.Minute)
+	if info := dst.GetTagInfo(id); info.Value != 5 {
+		t.Errorf("expected the TTL tag to expire with the time it had left, value is %d", info.Value)
+	}
+
+	if err := dst.RestoreTags([]byte("{")); err == nil {
+		t.Error("expected an error for a malformed document")
+	}
+}
@@ -0,0 +1,94 @@
+package connmgr
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// Stats is the document returned by Stats, meant to back dashboards and status pages.
+type Stats struct {
+	// Time is when the statistics were collected.
+	Time time.Time `json:"time"`
+
+	LowWater    int      `json:"lowWater"`
+	HighWater   int      `json:"highWater"`
+	GracePeriod Duration `json:"gracePeriod"`
+
+	// Connections is the number of connections tracked, of which Inbound and Outbound
+	// are the ones of known direction.
+	Connections int `json:"connections"`
+	Inbound     int `json:"inbound"`
+	Outbound    int `json:"outbound"`
+
+	// Peers is the number of peers tracked, including peers tagged but not connected,
+	// of which ProtectedPeers are protected under at least one tag.
+	Peers          int `json:"peers"`
+	ProtectedPeers int `json:"protectedPeers"`
+
+	// Protocols maps the protocols with a configured minimum, or gauged with
+	// WithProtocolGauges, to their statistics.
+	Protocols map[protocol.ID]ProtocolStats `json:"protocols"`
+
+	Churn ChurnStats `json:"churn"`
+
+	// Trims is the number of trims performed, which closed ClosedConns connections.
+	Trims       int       `json:"trims"`
+	ClosedConns int       `json:"closedConns"`
+	TrimCost    TrimCost  `json:"trimCost"`
+	LastTrim    time.Time `json:"lastTrim"`
+
+	// LastTrimDetails is the report of the last trim, nil if none completed.
+	LastTrimDetails *TrimDetails `json:"lastTrimDetails"`
+
+	// Healthy tells whether Health reports the connection manager as healthy.
+	Healthy bool `json:"healthy"`
+}
+
+// ProtocolStats are the statistics of a protocol in a Stats document.
+type ProtocolStats struct {
+	// Minimum is the configured minimum number of peers, and Counted the number of
+	// peers counted toward it, see ProtocolCount.
+	Minimum int `json:"minimum"`
+	Counted int `json:"counted"`
+
+	// Connected is the number of connected peers supporting the protocol, for the
+	// protocols gauged with WithProtocolGauges.
+	Connected int `json:"connected"`
+}
+
+// Stats combines the status data of GetInfo, the report of the last trim and the
+// health of the connection manager into a single JSON-friendly document.
+func (cm *PhoreConnMgr) Stats() Stats {
+	info := cm.GetInfo()
+	st := Stats{
+		Time:            cm.clock.Now(),
+		LowWater:        info.LowWater,
+		HighWater:       info.HighWater,
+		GracePeriod:     Duration(info.GracePeriod),
+		Connections:     info.ConnCount,
+		Inbound:         info.InboundConns,
+		Outbound:        info.OutboundConns,
+		Peers:           info.TrackedPeers,
+		ProtectedPeers:  info.ProtectedPeers,
+		Protocols:       make(map[protocol.ID]ProtocolStats),
+		Churn:           info.Churn,
+		Trims:           info.TotalTrims,
+		ClosedConns:     info.TotalClosed,
+		TrimCost:        info.TotalTrimCost,
+		LastTrim:        info.LastTrim,
+		LastTrimDetails: cm.LastTrimDetails(),
+		Healthy:         cm.Health().Healthy(),
+	}
+	for proto, pc := range info.ProtocolCounts {
+		ps := st.Protocols[proto]
+		ps.Minimum, ps.Counted = pc.Minimum, pc.Connected
+		st.Protocols[proto] = ps
+	}
+	for proto, n := range info.ProtocolPeers {
+		ps := st.Protocols[proto]
+		ps.Connected = n
+		st.Protocols[proto] = ps
+	}
+	return st
+}
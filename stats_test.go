@@ -0,0 +1,76 @@
+package connmgr
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestStats(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 2, 0, ps, map[protocol.ID]int{"/sync/1.0.0": 1}, WithTrimInterval(0), WithProtocolGauges("/sync/1.0.0", "/tx/1.0.0"))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	if st := cm.Stats(); st.LastTrimDetails != nil || st.Trims != 0 {
+		t.Fatalf("unexpected stats before any trim %+v", st)
+	}
+	for i := 0; i < 3; i++ {
+		c := &tconn{peer: randConn(t, nil).RemotePeer(), dir: network.DirInbound, disconnectNotify: not.Disconnected}
+		if i == 0 {
+			ps.AddProtocols(c.peer, "/sync/1.0.0")
+			cm.TagPeer(c.peer, "score", 100)
+		}
+		not.Connected(nil, c)
+	}
+	cm.TrimOpenConns(context.Background())
+
+	st := cm.Stats()
+	if st.Connections != 1 || st.Inbound != 1 || st.Peers != 1 || st.Trims != 1 || st.ClosedConns != 2 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if st.LastTrimDetails == nil || len(st.LastTrimDetails.Closed) != 2 {
+		t.Fatalf("expected the last trim report, got %+v", st.LastTrimDetails)
+	}
+	if st.Churn.TotalConnects != 3 || st.Churn.TotalDisconnects != 2 {
+		t.Fatalf("unexpected churn %+v", st.Churn)
+	}
+	want := map[protocol.ID]ProtocolStats{
+		"/sync/1.0.0": {Minimum: 1, Counted: 1, Connected: 1},
+		"/tx/1.0.0":   {},
+	}
+	if len(st.Protocols) != len(want) {
+		t.Fatalf("unexpected protocols %+v", st.Protocols)
+	}
+	for proto, ps := range want {
+		if st.Protocols[proto] != ps {
+			t.Errorf("%s: expected %+v, got %+v", proto, ps, st.Protocols[proto])
+		}
+	}
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"connections", "protocols", "churn", "trimCost", "lastTrimDetails", "healthy"} {
+		if _, ok := doc[key]; !ok {
+			t.Errorf("expected %q in %s", key, data)
+		}
+	}
+	// zero counts are written out rather than left for the reader to guess.
+	tx := doc["protocols"].(map[string]interface{})["/tx/1.0.0"].(map[string]interface{})
+	for _, key := range []string{"minimum", "counted", "connected"} {
+		if v, ok := tx[key]; !ok || v != 0.0 {
+			t.Errorf("expected %q to be 0 in %s", key, data)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package connmgr
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ConnDetail describes a connection of a peer.
+type ConnDetail struct {
+	// Addr is the remote multiaddr of the connection.
+	Addr string
+
+	// Opened is when the connection was reported.
+	Opened time.Time
+
+	Direction network.Direction
+
+	// Transport names the protocols of the connection above the network layer, e.g.
+	// "tcp", "udp/quic" or "tcp/p2p-circuit".
+	Transport string
+
+	// Streams is the number of streams open on the connection.
+	Streams int
+}
+
+// ExtendedTagInfo is a TagInfo detailing the connections of the peer.
+type ExtendedTagInfo struct {
+	connmgr.TagInfo
+
+	// ConnDetails describe the connections of the peer, oldest first.
+	ConnDetails []ConnDetail
+
+	// LastSeen is the time at which a connection to the peer was last opened or
+	// closed, zero for temporary entries.
+	LastSeen time.Time
+
+	// LastActivity is the latest of FirstSeen, LastSeen and the times at which a
+	// stream was last opened or closed, or the tags last updated.
+	LastActivity time.Time
+}
+
+// GetTagInfoExtended is like GetTagInfo, but it also details each connection of the
+// peer with its direction, transport and open streams, and reports when the peer was
+// last active.
+func (cm *PhoreConnMgr) GetTagInfoExtended(p peer.ID) *ExtendedTagInfo {
+	s := cm.segments.get(p)
+	s.Lock()
+	defer s.Unlock()
+
+	pi, ok := s.peers[p]
+	if !ok {
+		return nil
+	}
+
+	out := &ExtendedTagInfo{
+		TagInfo:     *cm.tagInfo(pi, cm.clock.Now()),
+		ConnDetails: make([]ConnDetail, 0, len(pi.conns)),
+
+		LastSeen:     pi.lastSeen,
+		LastActivity: latest(pi.firstSeen, pi.lastSeen, pi.lastStream, pi.lastTagged),
+	}
+	for c, ci := range pi.conns {
+		addr := c.RemoteMultiaddr()
+		out.ConnDetails = append(out.ConnDetails, ConnDetail{
+			Addr:      addr.String(),
+			Opened:    ci.opened,
+			Direction: ci.dir,
+			Transport: transportOf(addr),
+			Streams:   len(ci.streams),
+		})
+	}
+	sort.Slice(out.ConnDetails, func(i, j int) bool {
+		left, right := out.ConnDetails[i], out.ConnDetails[j]
+		if !left.Opened.Equal(right.Opened) {
+			return left.Opened.Before(right.Opened)
+		}
+		return left.Addr < right.Addr
+	})
+	return out
+}
+
+// transportOf returns the names of the protocols of addr above the network layer,
+// leaving out addresses and peer IDs.
+func transportOf(addr ma.Multiaddr) string {
+	if addr == nil {
+		return ""
+	}
+	var names []string
+	for _, p := range addr.Protocols() {
+		switch {
+		case p.Code == ma.P_IP4, p.Code == ma.P_IP6, p.Code == ma.P_IP6ZONE, p.Code == ma.P_P2P:
+		case strings.HasPrefix(p.Name, "dns"):
+		default:
+			names = append(names, p.Name)
+		}
+	}
+	return strings.Join(names, "/")
+}
@@ -0,0 +1,93 @@
+package connmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	tu "github.com/libp2p/go-libp2p-core/test"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestGetTagInfoExtended(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	id := tu.RandPeerIDFatal(t)
+	if cm.GetTagInfoExtended(id) != nil {
+		t.Fatal("expected no info for an unknown peer")
+	}
+
+	quic := &tconn{peer: id, dir: network.DirOutbound, addr: ma.StringCast("/ip4/1.2.3.4/udp/4001/quic")}
+	not.Connected(nil, quic)
+	clock.Add(time.Minute)
+	relayed := &tconn{peer: id, dir: network.DirInbound, addr: ma.StringCast("/ip4/5.6.7.8/tcp/4001/ipfs/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit")}
+	not.Connected(nil, relayed)
+	not.OpenedStream(nil, &tstream{conn: relayed})
+	not.OpenedStream(nil, &tstream{conn: relayed})
+	cm.TagPeer(id, "foo", 5)
+
+	info := cm.GetTagInfoExtended(id)
+	if info.Value != 5 || info.Tags["foo"] != 5 || len(info.Conns) != 2 {
+		t.Fatalf("unexpected tag info: %+v", info.TagInfo)
+	}
+	if len(info.ConnDetails) != 2 {
+		t.Fatalf("expected 2 connections, got %d", len(info.ConnDetails))
+	}
+	if d := info.ConnDetails[0]; d.Direction != network.DirOutbound || d.Transport != "udp/quic" || d.Streams != 0 {
+		t.Errorf("unexpected details of the quic connection: %+v", d)
+	}
+	if d := info.ConnDetails[1]; d.Direction != network.DirInbound || d.Transport != "tcp/p2p-circuit" || d.Streams != 2 {
+		t.Errorf("unexpected details of the relayed connection: %+v", d)
+	}
+}
+
+func TestLastActivity(t *testing.T) {
+	clock := newMockClock()
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(10, 20, 0, ps, map[protocol.ID]int{}, WithClock(clock))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	start := clock.Now()
+	first := randConn(t, nil)
+	id := first.RemotePeer()
+	not.Connected(nil, first)
+
+	check := func(seen, active time.Time) {
+		t.Helper()
+		info := cm.GetTagInfoExtended(id)
+		if !info.LastSeen.Equal(seen) || !info.LastActivity.Equal(active) {
+			t.Fatalf("expected last seen %s and last activity %s, got %s and %s", seen, active, info.LastSeen, info.LastActivity)
+		}
+		snaps := cm.Peers()
+		if len(snaps) != 1 || !snaps[0].LastSeen.Equal(seen) || !snaps[0].LastActivity().Equal(active) {
+			t.Fatalf("unexpected snapshot: %+v", snaps)
+		}
+	}
+	check(start, start)
+
+	clock.Add(time.Minute)
+	cm.TagPeer(id, "foo", 1)
+	check(start, clock.Now())
+
+	clock.Add(time.Minute)
+	not.OpenedStream(nil, &tstream{conn: first})
+	check(start, clock.Now())
+
+	clock.Add(time.Minute)
+	second := &tconn{peer: id}
+	not.Connected(nil, second)
+	check(clock.Now(), clock.Now())
+
+	clock.Add(time.Minute)
+	not.Disconnected(nil, second)
+	check(clock.Now(), clock.Now())
+}
@@ -0,0 +1,49 @@
+package connmgr
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p-core/network"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of trims.
+const tracerName = "github.com/phoreproject/go-phore-connmgr"
+
+// startTrimSpan starts the span covering a trim, with the tracer configured through
+// WithTracerProvider, or else the global one.
+func (cm *PhoreConnMgr) startTrimSpan(ctx context.Context) (context.Context, trace.Span) {
+	tracer := cm.tracer
+	if tracer == nil {
+		tracer = otel.Tracer(tracerName)
+	}
+	return tracer.Start(ctx, "connmgr.trim")
+}
+
+// traceSelection records on span how many candidates the trim considered, and how many
+// connections it is about to close.
+func traceSelection(span trace.Span, plan trimPlan) {
+	span.SetAttributes(
+		attribute.Int("connmgr.candidates", plan.considered),
+		attribute.Int("connmgr.selected", len(plan.conns)),
+	)
+}
+
+// traceClose records the closing of c as an event of span.
+func traceClose(span trace.Span, c network.Conn, reason CloseReason) {
+	span.AddEvent("close", trace.WithAttributes(
+		attribute.String("connmgr.peer", c.RemotePeer().Pretty()),
+		attribute.String("connmgr.reason", string(reason)),
+	))
+}
+
+// traceInterrupted marks span as failed by err after closed connections were closed.
+func traceInterrupted(span trace.Span, closed int, err error) {
+	span.SetAttributes(attribute.Int("connmgr.closed", closed))
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
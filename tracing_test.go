@@ -0,0 +1,68 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTrimSpans(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	defer tp.Shutdown(context.Background())
+
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(2, 4, 0, ps, map[protocol.ID]int{}, WithTracerProvider(tp))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 5; i++ {
+		rc := randConn(t, nil)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "score", i+1)
+	}
+	cm.TrimOpenConns(context.Background())
+
+	spans := sr.Ended()
+	if len(spans) != 1 || spans[0].Name() != "connmgr.trim" {
+		t.Fatalf("expected a single trim span, got %v", spans)
+	}
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["connmgr.candidates"].AsInt64() != 5 || attrs["connmgr.selected"].AsInt64() != 3 {
+		t.Fatalf("unexpected attributes %v", spans[0].Attributes())
+	}
+	events := spans[0].Events()
+	if len(events) != 3 {
+		t.Fatalf("expected 3 close events, got %d", len(events))
+	}
+	for _, e := range events {
+		if e.Name != "close" {
+			t.Errorf("unexpected event %q", e.Name)
+		}
+	}
+}
+
+func TestNilTracerProvider(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(1, 1, 0, ps, map[protocol.ID]int{}, WithTracerProvider(nil))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 3; i++ {
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+	cm.TrimOpenConns(context.Background())
+	if n := cm.GetInfo().ConnCount; n != 1 {
+		t.Fatalf("expected the trim to run with the global tracer, %d connections left", n)
+	}
+}
@@ -0,0 +1,63 @@
+package connmgr
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// TrimCost measures the work done by trims.
+type TrimCost struct {
+	// Plan is the time spent selecting the connections to close, and Close the time
+	// spent closing them.
+	Plan  Duration `json:"plan"`
+	Close Duration `json:"close"`
+
+	// Candidates is the number of peers considered for pruning.
+	Candidates int `json:"candidates"`
+
+	// PeerstoreLookups is the number of peerstore lookups made while selecting the
+	// connections, including lookups made meanwhile for GetInfo and Health.
+	PeerstoreLookups int `json:"peerstoreLookups"`
+}
+
+// add accumulates c into tc.
+func (tc *TrimCost) add(c TrimCost) {
+	tc.Plan += c.Plan
+	tc.Close += c.Close
+	tc.Candidates += c.Candidates
+	tc.PeerstoreLookups += c.PeerstoreLookups
+}
+
+// getProtocols reads the protocols of p from the peerstore, counting the lookup.
+func (cm *PhoreConnMgr) getProtocols(p peer.ID) ([]string, error) {
+	atomic.AddInt64(&cm.peerstoreLookups, 1)
+	return cm.peerstore.GetProtocols(p)
+}
+
+// latencyOf reads the latency of p from the peerstore, counting the lookup.
+func (cm *PhoreConnMgr) latencyOf(p peer.ID) time.Duration {
+	atomic.AddInt64(&cm.peerstoreLookups, 1)
+	return cm.peerstore.LatencyEWMA(p)
+}
+
+// measuredPlanTrim runs planTrim, and records its cost in the plan.
+func (cm *PhoreConnMgr) measuredPlanTrim(ctx context.Context, opts trimOpts) trimPlan {
+	start := time.Now()
+	lookups := atomic.LoadInt64(&cm.peerstoreLookups)
+	plan := cm.planTrim(ctx, opts)
+	plan.cost.Plan = Duration(time.Since(start))
+	plan.cost.Candidates = plan.considered
+	plan.cost.PeerstoreLookups = int(atomic.LoadInt64(&cm.peerstoreLookups) - lookups)
+	return plan
+}
+
+// trimCosts returns the cost of the last trim, and of all trims so far.
+func (cm *PhoreConnMgr) trimCosts() (last, total TrimCost) {
+	cm.lastTrimMu.RLock()
+	defer cm.lastTrimMu.RUnlock()
+
+	return cm.lastTrimCost, cm.totalTrimCost
+}
@@ -0,0 +1,42 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+func TestTrimCost(t *testing.T) {
+	ps := pstore.NewPeerstore(pstoremem.NewKeyBook(), pstoremem.NewAddrBook(), pstoremem.NewProtoBook(), pstoremem.NewPeerMetadata())
+	cm := NewConnManager(2, 4, 0, ps, map[protocol.ID]int{"/sync/1.0.0": 1}, WithSilencePeriod(0), WithTrimInterval(0))
+	defer cm.Close()
+	not := cm.Notifee()
+
+	for i := 0; i < 5; i++ {
+		not.Connected(nil, randConn(t, not.Disconnected))
+	}
+	cm.TrimOpenConns(context.Background())
+
+	info := cm.GetInfo()
+	last := info.LastTrimCost
+	// the protocols of every peer are looked up to check the minimum.
+	if last.Candidates != 5 || last.PeerstoreLookups < 5 || last.Plan < 0 || last.Close < 0 {
+		t.Fatalf("unexpected cost %+v", last)
+	}
+	if info.TotalTrimCost != last {
+		t.Fatalf("expected the total to be the cost of the only trim, got %+v", info.TotalTrimCost)
+	}
+	if report := cm.LastTrimDetails(); report.Cost != last {
+		t.Fatalf("expected the report to carry the cost, got %+v", report.Cost)
+	}
+
+	cm.SetWatermarks(1, 1)
+	cm.TrimOpenConns(context.Background())
+	info = cm.GetInfo()
+	if info.LastTrimCost.Candidates != 2 || info.TotalTrimCost.Candidates != 7 {
+		t.Fatalf("unexpected costs %+v and %+v", info.LastTrimCost, info.TotalTrimCost)
+	}
+}